@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/linkio"
+	"github.com/0xKev/url-shortener/internal/server"
+	redisStore "github.com/0xKev/url-shortener/internal/store/redis"
+)
+
+func exportLinks(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	from := flags.String("from", "", "store to export, redis://host:port/db or file:path")
+	keyPrefix := flags.String("key-prefix", redisStore.DefaultKeyPrefix, "namespace of the links in a redis store")
+	format := flags.String("format", linkio.FormatJSONL, "jsonl or csv")
+	domain := flags.String("domain", "", "domain the links are served on, recorded with every link")
+	output := flags.String("o", "-", "file to write, - for stdout")
+	keys := flags.String("encryption-keys", os.Getenv("URL_SHORTENER_ENCRYPTION_KEYS"), "keys the links may be encrypted with, written id:base64key,...")
+	flags.Parse(args)
+	if *from == "" {
+		return errors.New("-from is required")
+	}
+	keyring, err := parseKeyring(*keys, "")
+	if err != nil {
+		return err
+	}
+
+	source, err := open(*from, *keyPrefix)
+	if err != nil {
+		return fmt.Errorf("error when opening source %v", err)
+	}
+	defer closeStore(*from, source)
+	source = encrypted(source, keyring)
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("error when creating export file %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	written, err := linkio.Export(context.Background(), source, *domain, *format, w)
+	if err != nil {
+		return fmt.Errorf("export stopped after %d links: %v", written, err)
+	}
+	log.Printf("Exported %d links", written)
+	return nil
+}
+
+func importLinks(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	to := flags.String("to", "", "store to import into, redis://host:port/db or file:path")
+	keyPrefix := flags.String("key-prefix", redisStore.DefaultKeyPrefix, "namespace of the links in a redis store")
+	format := flags.String("format", linkio.FormatJSONL, "one of "+strings.Join(linkio.ImportFormats(), ", ")+", hosted reads the CSV export of hosted shorteners")
+	conflict := flags.String("conflict", string(linkio.ConflictFail), "what to do with suffixes already pointing elsewhere: skip, overwrite or fail")
+	keys := flags.String("encryption-keys", os.Getenv("URL_SHORTENER_ENCRYPTION_KEYS"), "keys the store is encrypted with, written id:base64key,... newest first")
+	keyID := flags.String("encryption-key-id", "", "ID of the key to encrypt with, defaults to the first of -encryption-keys")
+	flags.Parse(args)
+	if *to == "" || flags.NArg() != 1 {
+		return errors.New("usage: import -to <store> [flags] <file or - for stdin>")
+	}
+	policy, err := linkio.ParseConflictPolicy(*conflict)
+	if err != nil {
+		return err
+	}
+	keyring, err := parseKeyring(*keys, *keyID)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error when opening import file %v", err)
+		}
+		defer file.Close()
+		r = file
+	}
+	records, err := linkio.ReadRecords(r, *format)
+	if err != nil {
+		return err
+	}
+
+	destination, err := open(*to, *keyPrefix)
+	if err != nil {
+		return fmt.Errorf("error when opening destination %v", err)
+	}
+	defer closeStore(*to, destination)
+	destination = encrypted(destination, keyring)
+
+	// suffixes of disabled links stay taken, as with the admin import
+	reserved := func(shortSuffix string) bool {
+		return server.IsReservedSuffix(shortSuffix) || isTombstoned(destination, shortSuffix)
+	}
+	result, err := linkio.Import(records, destination, policy, reserved)
+	if result.Imported+result.Overwritten > 0 {
+		announce(*to, destination)
+	}
+	log.Printf("Imported %d links, overwrote %d, skipped %d, %d already up to date", result.Imported, result.Overwritten, result.Skipped, result.Unchanged)
+	for _, conflict := range result.Conflicts {
+		log.Printf("Row %d: %s, %s", conflict.Row, conflict.ShortSuffix, conflict.Reason)
+	}
+	return err
+}
@@ -0,0 +1,259 @@
+// Command urlShortenerMigrate copies every link from one store to another,
+// for moving between redis databases, taking backups or restoring them:
+//
+//	urlShortenerMigrate -from redis://localhost:6379/0 -to file:links.jsonl
+//	urlShortenerMigrate -from redis://localhost:6379/0 -to redis://localhost:6379/1
+//	urlShortenerMigrate -from file:links.jsonl -to redis://10.0.0.1:6379/0
+//
+// Stores are redis:// or rediss:// URLs as understood by go-redis, or file:
+// followed by the path of a JSON lines snapshot. Progress is checkpointed
+// after every batch, so running the same command again after a failure
+// resumes where it stopped. -dry-run only reports what would be copied.
+//
+// Without -encryption-keys encrypted links are copied sealed, the destination
+// must then be served with the same keys. With them links are decrypted when
+// read and sealed with the current key when written, which also encrypts
+// links of a plaintext source. Servers on a redis destination are told to
+// drop their caches and rebuild their suffix filters once links were written.
+// Tombstones and click counts are not copied.
+//
+// The export and import subcommands move the link set through a JSON lines
+// or CSV file instead, e.g. between environments:
+//
+//	urlShortenerMigrate export -from redis://localhost:6379/0 -format csv -o links.csv
+//	urlShortenerMigrate import -to redis://10.0.0.1:6379/0 -format csv -conflict skip links.csv
+//
+// Import also reads the CSV export of hosted shorteners with -format hosted,
+// keeping their back-halves as suffixes along with creation dates and click
+// totals. Rows whose suffix is taken, disabled or collides with a route are
+// reported. Both take -encryption-keys to read and write plaintext.
+//
+// When base URLs are encrypted at rest, reencrypt seals every link with the
+// current key after a rotation, and encrypts links stored before encryption
+// was enabled. The old keys can be dropped once it has finished, except the
+// ones it reports disabled links are still sealed with:
+//
+//	urlShortenerMigrate reencrypt -store redis://localhost:6379/0 -encryption-keys new:...,old:...
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/migration"
+	"github.com/0xKev/url-shortener/internal/store"
+	encryptedStore "github.com/0xKev/url-shortener/internal/store/encrypted"
+	fileStore "github.com/0xKev/url-shortener/internal/store/file"
+	redisStore "github.com/0xKev/url-shortener/internal/store/redis"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	from           = flag.String("from", "", "store to copy links from, redis://host:port/db or file:path")
+	to             = flag.String("to", "", "store to copy links to, redis://host:port/db or file:path")
+	fromKeyPrefix  = flag.String("from-key-prefix", redisStore.DefaultKeyPrefix, "namespace of the links in a redis source")
+	toKeyPrefix    = flag.String("to-key-prefix", redisStore.DefaultKeyPrefix, "namespace of the links in a redis destination")
+	checkpointPath = flag.String("checkpoint", "urlShortenerMigrate.checkpoint", "file recording progress so an interrupted run can resume, empty to disable")
+	batchSize      = flag.Int("batch", migration.DefaultBatchSize, "links read from the source per batch")
+	dryRun         = flag.Bool("dry-run", false, "count what would be copied without writing anything")
+	verify         = flag.Bool("verify", true, "check every source link against the destination afterwards")
+	encryptionKeys = flag.String("encryption-keys", os.Getenv("URL_SHORTENER_ENCRYPTION_KEYS"), "keys both stores may be encrypted with, written id:base64key,... newest first, empty to copy links as stored")
+	encryptionKey  = flag.String("encryption-key-id", "", "ID of the key to encrypt with, defaults to the first of -encryption-keys")
+)
+
+// commands are run instead of a migration when named as the first argument.
+var commands = map[string]func(args []string) error{
+	"export":    exportLinks,
+	"import":    importLinks,
+	"reencrypt": reencryptLinks,
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	flag.Parse()
+	if *from == "" || *to == "" {
+		log.Fatal("both -from and -to are required")
+	}
+	if *from == *to && *fromKeyPrefix == *toKeyPrefix {
+		log.Fatal("-from and -to are the same store")
+	}
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run does the migration, stores are closed before main exits so buffered
+// writes to a file reach the disk even when it fails.
+func run() error {
+	keyring, err := parseKeyring(*encryptionKeys, *encryptionKey)
+	if err != nil {
+		return err
+	}
+	source, err := open(*from, *fromKeyPrefix)
+	if err != nil {
+		return fmt.Errorf("error when opening source %v", err)
+	}
+	defer closeStore(*from, source)
+	destination, err := open(*to, *toKeyPrefix)
+	if err != nil {
+		return fmt.Errorf("error when opening destination %v", err)
+	}
+	defer closeStore(*to, destination)
+	source, destination = encrypted(source, keyring), encrypted(destination, keyring)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	migrator := migration.NewMigrator(source, destination)
+	if err := migrator.SetBatchSize(*batchSize); err != nil {
+		return err
+	}
+	migrator.SetDryRun(*dryRun)
+	migrator.SetCheckpoint(*checkpointPath, migration.Job{
+		From:          redactStore(*from),
+		To:            redactStore(*to),
+		FromKeyPrefix: *fromKeyPrefix,
+		ToKeyPrefix:   *toKeyPrefix,
+	})
+
+	result, err := migrator.Run(ctx)
+	if result.Copied+result.Overwritten > 0 && !*dryRun {
+		announce(*to, destination)
+	}
+	copied, overwrote := "copied", "overwrote"
+	if *dryRun {
+		copied, overwrote = "would copy", "would overwrite"
+	}
+	log.Printf("Scanned %d links, %s %d new, %s %d, %d already up to date", result.Scanned, copied, result.Copied, overwrote, result.Overwritten, result.Unchanged)
+	if err != nil {
+		return fmt.Errorf("migration stopped, run it again to resume: %v", err)
+	}
+	if *dryRun || !*verify {
+		return nil
+	}
+
+	verification, err := migration.Verify(ctx, source, destination)
+	if err != nil {
+		return fmt.Errorf("error when verifying %v", err)
+	}
+	log.Printf("Source holds %d links, destination %d, %d missing, %d with a different base URL or metadata",
+		verification.Source, verification.Destination, verification.Missing, verification.Mismatched)
+	if !verification.OK() {
+		return errors.New("verification failed, the destination doesn't match the source")
+	}
+	return nil
+}
+
+type backend interface {
+	store.URLStore
+	store.URLScanner
+	Close() error
+}
+
+// open connects to the store described by spec.
+func open(spec, keyPrefix string) (backend, error) {
+	if path, ok := strings.CutPrefix(spec, "file:"); ok {
+		return fileStore.OpenFileURLStore(path)
+	}
+
+	if !strings.HasPrefix(spec, "redis://") && !strings.HasPrefix(spec, "rediss://") {
+		return nil, fmt.Errorf("unsupported store %q, expected redis://, rediss:// or file:", spec)
+	}
+	config, err := redis.ParseURL(spec)
+	if err != nil {
+		return nil, err
+	}
+	store, err := redisStore.NewRedisURLStore(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.SetKeyPrefix(keyPrefix); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// parseKeyring reads the keys given with -encryption-keys, nil when there
+// are none.
+func parseKeyring(keys, keyID string) (*encryptedStore.Keyring, error) {
+	if keys == "" {
+		return nil, nil
+	}
+	return encryptedStore.ParseKeyring(keys, keyID)
+}
+
+// encryptedBackend is a backend seen through the encrypted store.
+type encryptedBackend struct {
+	*encryptedStore.EncryptedURLStore
+	raw backend
+}
+
+func (e encryptedBackend) Close() error {
+	return e.raw.Close()
+}
+
+// encrypted decrypts what is read from raw and seals what is written to it
+// with the primary key of keyring, raw is used as is without a keyring.
+func encrypted(raw backend, keyring *encryptedStore.Keyring) backend {
+	if keyring == nil {
+		return raw
+	}
+	return encryptedBackend{EncryptedURLStore: encryptedStore.NewEncryptedURLStore(raw, keyring), raw: raw}
+}
+
+// announce has the servers on a redis store purge their caches and rebuild
+// their suffix filters, neither saw the links written here.
+func announce(spec string, store backend) {
+	if e, ok := store.(encryptedBackend); ok {
+		store = e.raw
+	}
+	publisher, ok := store.(interface {
+		InvalidationBus() *redisStore.InvalidationBus
+	})
+	if !ok {
+		return
+	}
+	if err := publisher.InvalidationBus().PublishPurge(); err != nil {
+		log.Printf("unable to tell the servers of %s about the new links, restart them to serve them: %v", spec, err)
+	}
+}
+
+// isTombstoned reports whether a link was disabled under shortSuffix.
+func isTombstoned(urlStore backend, shortSuffix string) bool {
+	disabler, ok := urlStore.(store.LinkDisabler)
+	if !ok {
+		return false
+	}
+	_, found := disabler.Tombstone(shortSuffix)
+	return found
+}
+
+// redactStore keeps passwords in redis URLs out of the checkpoint file.
+func redactStore(spec string) string {
+	if parsed, err := url.Parse(spec); err == nil && parsed.User != nil {
+		return parsed.Redacted()
+	}
+	return spec
+}
+
+func closeStore(spec string, store backend) {
+	if err := store.Close(); err != nil {
+		log.Printf("error when closing %s, %v", spec, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/migration"
+	encryptedStore "github.com/0xKev/url-shortener/internal/store/encrypted"
+	redisStore "github.com/0xKev/url-shortener/internal/store/redis"
+)
+
+func reencryptLinks(args []string) error {
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	spec := flags.String("store", "", "store to re-encrypt, redis://host:port/db or file:path")
+	keyPrefix := flags.String("key-prefix", redisStore.DefaultKeyPrefix, "namespace of the links in a redis store")
+	keys := flags.String("encryption-keys", os.Getenv("URL_SHORTENER_ENCRYPTION_KEYS"), "every key links may be encrypted with, written id:base64key,... newest first")
+	keyID := flags.String("encryption-key-id", "", "ID of the key to encrypt with, defaults to the first of -encryption-keys")
+	batch := flags.Int("batch", migration.DefaultBatchSize, "links read per batch")
+	flags.Parse(args)
+	if *spec == "" || *keys == "" {
+		return errors.New("-store and -encryption-keys are required")
+	}
+	if *batch < 1 {
+		return fmt.Errorf("invalid batch size %d: must be positive", *batch)
+	}
+	keyring, err := encryptedStore.ParseKeyring(*keys, *keyID)
+	if err != nil {
+		return err
+	}
+
+	store, err := open(*spec, *keyPrefix)
+	if err != nil {
+		return fmt.Errorf("error when opening store %v", err)
+	}
+	defer closeStore(*spec, store)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	encrypted := encryptedStore.NewEncryptedURLStore(store, keyring)
+	result, err := encrypted.Reencrypt(ctx, *batch)
+	log.Printf("Scanned %d links, re-encrypted %d with key %v, %d already up to date, %d changed meanwhile", result.Scanned, result.Reencrypted, keyring.Primary(), result.Unchanged, result.Changed)
+	if err != nil {
+		return fmt.Errorf("re-encryption stopped, run it again to finish: %v", err)
+	}
+
+	// tombstones keep the key they were sealed with
+	keyIDs, err := encrypted.TombstoneKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if len(keyIDs) > 0 {
+		log.Printf("Disabled links are still sealed with keys %v, keep them while those links may be restored", strings.Join(keyIDs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Command urlShortenerRebalance moves links onto a newly added redis shard.
+//
+// Servers keep serving while it runs as long as they were restarted with the
+// new shard in -redis-shards and the old list in -redis-shards-previous:
+//
+//	urlShortenerRebalance -shards a=10.0.0.1:6379,b=10.0.0.2:6379 -add c=10.0.0.3:6379
+//
+// Afterwards restart the servers without -redis-shards-previous. Running it
+// again is harmless.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	redisStore "github.com/0xKev/url-shortener/internal/store/redis"
+)
+
+var (
+	shards    = flag.String("shards", "", "shards links are currently spread over, e.g. a=10.0.0.1:6379,b=10.0.0.2:6379")
+	add       = flag.String("add", "", "shard to add, e.g. c=10.0.0.3:6379")
+	password  = flag.String("redis-password", "", "password for every shard")
+	db        = flag.Int("redis-db", 0, "redis DB holding the links on every shard")
+	keyPrefix = flag.String("redis-key-prefix", redisStore.DefaultKeyPrefix, "namespace the servers use for redis keys")
+)
+
+func main() {
+	flag.Parse()
+	if *shards == "" || *add == "" {
+		log.Fatal("both -shards and -add are required")
+	}
+
+	current, err := connect(*shards)
+	if err != nil {
+		log.Fatalf("error when connecting to shards %v", err)
+	}
+	added, err := connect(*add)
+	if err != nil {
+		log.Fatalf("error when connecting to new shard %v", err)
+	}
+	if len(added) != 1 {
+		log.Fatal("-add takes exactly one shard")
+	}
+
+	store, err := redisStore.NewShardedURLStore(current)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := store.AddShard(added[0]); err != nil {
+		log.Fatal(err)
+	}
+
+	result, err := store.Rebalance(context.Background())
+	if err != nil {
+		log.Fatalf("rebalance stopped after moving %d links, run it again to resume: %v", result.Moved, err)
+	}
+	log.Printf("Scanned %d links, moved %d to their new shard, dropped %d copies already rewritten there", result.Scanned, result.Moved, result.Superseded)
+}
+
+func connect(spec string) ([]redisStore.Shard, error) {
+	configs, err := redisStore.ParseShardConfigs(spec, *password, *db)
+	if err != nil {
+		return nil, err
+	}
+	return redisStore.NewShards(configs, *keyPrefix)
+}
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"flag"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/0xKev/url-shortener/internal/base62"
+	"github.com/0xKev/url-shortener/internal/bloom"
 	"github.com/0xKev/url-shortener/internal/model"
 	"github.com/0xKev/url-shortener/internal/server"
 	"github.com/0xKev/url-shortener/internal/shortener"
+	"github.com/0xKev/url-shortener/internal/store"
+	cacheStore "github.com/0xKev/url-shortener/internal/store/cache"
+	encryptedStore "github.com/0xKev/url-shortener/internal/store/encrypted"
 	redisStore "github.com/0xKev/url-shortener/internal/store/redis"
+	replicatedStore "github.com/0xKev/url-shortener/internal/store/replicated"
 )
 
 type EncoderFunc func(num uint64) string
@@ -20,27 +29,188 @@ func (e EncoderFunc) Encode(num uint64) string {
 var encoder shortener.Encoder = EncoderFunc(base62.Encode)
 
 const (
-	redisAddr = "localhost:6379"
-	redisPass = ""
-	redisDB   = 0
+	defaultRedisAddr = "localhost:6379"
+	defaultRedisPass = ""
+	defaultRedisDB   = 0
+)
+
+var (
+	redisAddr             = flag.String("redis-addr", defaultRedisAddr, "redis address; several comma separated addresses mean a cluster, or sentinels with -redis-master-name")
+	redisMasterName       = flag.String("redis-master-name", "", "name of the sentinel monitored master, -redis-addr then lists the sentinels")
+	redisUsername         = flag.String("redis-username", "", "ACL username for redis and its sentinels")
+	redisPassword         = flag.String("redis-password", defaultRedisPass, "password for redis and its sentinels")
+	redisDB               = flag.Int("redis-db", defaultRedisDB, "redis DB holding the links, must be 0 on a cluster")
+	redisTLS              = flag.Bool("redis-tls", false, "connect to redis over TLS")
+	redisTLSCA            = flag.String("redis-tls-ca", "", "PEM file with the CA that signed the redis certificate, defaults to the system roots")
+	redisTLSCert          = flag.String("redis-tls-cert", "", "PEM client certificate for mutual TLS")
+	redisTLSKey           = flag.String("redis-tls-key", "", "PEM key of -redis-tls-cert")
+	redisTLSServerName    = flag.String("redis-tls-server-name", "", "expected name in the redis certificate when it differs from the address")
+	redisShards           = flag.String("redis-shards", "", "spread links over several redis instances, e.g. a=10.0.0.1:6379,b=10.0.0.2:6379")
+	redisShardsPrevious   = flag.String("redis-shards-previous", "", "shard names before the last one was added, set until the rebalance has finished")
+	redisReplicas         = flag.String("redis-replicas", "", "comma separated read replicas of -redis-addr, e.g. 10.0.0.2:6379,10.0.0.3:6379")
+	readSnapshot          = flag.Bool("read-snapshot", false, "keep an in-process copy of all links to serve redirects while redis is unreachable")
+	breakerFailures       = flag.Int("breaker-failures", replicatedStore.NewDefaultConfig().FailureThreshold(), "consecutive redis failures before it is skipped and shortening returns 503")
+	breakerCooldown       = flag.Duration("breaker-cooldown", replicatedStore.NewDefaultConfig().Cooldown(), "how long a failed redis is skipped before it is retried")
+	redisKeyPrefix        = flag.String("redis-key-prefix", redisStore.DefaultKeyPrefix, "namespace prepended to every redis key")
+	migrateUnprefixedKeys = flag.Bool("migrate-unprefixed-keys", false, "move links stored before key namespacing under -redis-key-prefix, then exit")
+	cacheSize             = flag.Int("cache-size", cacheStore.NewDefaultConfig().Size(), "max links held in the in-process cache, 0 disables it")
+	cacheTTL              = flag.Duration("cache-ttl", cacheStore.NewDefaultConfig().TTL(), "how long a cached link is served before reloading it")
+	cacheNegativeTTL      = flag.Duration("cache-negative-ttl", cacheStore.NewDefaultConfig().NegativeTTL(), "how long a missing suffix is remembered, 0 disables negative caching")
+	cacheInvalidation     = flag.Bool("cache-invalidation", true, "keep cached links and the suffix filter in sync with other replicas via redis pub/sub")
+	bloomCapacity         = flag.Uint64("bloom-capacity", bloom.DefaultCapacity, "links the suffix filter is sized for, 0 disables it")
+	bloomFPRate           = flag.Float64("bloom-fp-rate", bloom.DefaultFalsePositiveRate, "target false positive rate of the suffix filter")
+	domain                = flag.String("domain", envOr("URL_SHORTENER_DOMAIN", server.DefaultDomain), "public URL short links are served under, with scheme and trailing slash, e.g. https://sho.rt/")
+	redirectStatus        = flag.Int("redirect-status", server.DefaultRedirectStatus, "status short links redirect with unless created with their own: 301, 302, 307 or 308")
+	adminToken            = flag.String("admin-token", os.Getenv("URL_SHORTENER_ADMIN_TOKEN"), "bearer token for the /admin routes, they are disabled without one")
+	encryptionKeys        = flag.String("encryption-keys", os.Getenv("URL_SHORTENER_ENCRYPTION_KEYS"), "encrypt base URLs at rest with these AES keys, written id:base64key,... newest first")
+	encryptionKeyID       = flag.String("encryption-key-id", "", "ID of the key new links are encrypted with, defaults to the first of -encryption-keys")
+	suffixPoolSize        = flag.Int("suffix-pool-size", shortener.NewDefaultPoolConfig().Size(), "short suffixes generated ahead of time and checked against the store, 0 disables the pool")
+	suffixPoolLow         = flag.Int("suffix-pool-low-watermark", shortener.NewDefaultPoolConfig().LowWatermark(), "pool depth at which more suffixes are generated")
 )
 
 func main() {
+	flag.Parse()
 	// TODO(MED): Refactor all my tests and codes
-	storeConfig := redisStore.NewRedisConfig(redisAddr, redisPass, redisDB)
-	store, err := redisStore.NewRedisURLStore(storeConfig)
+	backend, err := newRedisBackend()
 	if err != nil {
 		log.Fatalf("error when creating redis store %v", err)
 	}
+
+	if *migrateUnprefixedKeys {
+		if err := migrateKeys(backend); err != nil {
+			log.Fatalf("error when migrating unprefixed keys %v", err)
+		}
+		return
+	}
+
 	testShortSuffix := "testurl"
 	testBaseURL := "https://www.example.com"
-	err = store.Save(&model.URLPair{ShortSuffix: testShortSuffix, BaseURL: testBaseURL, Domain: "shortener.com/"})
+	err = backend.Save(&model.URLPair{ShortSuffix: testShortSuffix, BaseURL: testBaseURL, Domain: "shortener.com/"})
 	if err != nil {
 		log.Printf("Error saving test URL: %v", err)
 	} else {
 		log.Printf("Test URL saved: %s -> %s", testShortSuffix, testBaseURL)
 	}
+
+	bus := backend.InvalidationBus()
+	var evictors []redisStore.Evictor
+
+	replicated, err := newReplicatedStore(backend)
+	if err != nil {
+		log.Fatalf("error when connecting to redis replicas %v", err)
+	}
+
+	var urlStore store.URLStore = replicated
+	if *encryptionKeys != "" {
+		keyring, err := encryptedStore.ParseKeyring(*encryptionKeys, *encryptionKeyID)
+		if err != nil {
+			log.Fatalf("error when reading encryption keys %v", err)
+		}
+		urlStore = encryptedStore.NewEncryptedURLStore(urlStore, keyring)
+	}
+	var cached *cacheStore.CachedURLStore
+	if *cacheSize > 0 {
+		cached, err = newCachedStore(urlStore)
+		if err != nil {
+			log.Fatalf("error when creating link cache %v", err)
+		}
+		expvar.Publish("linkCache", expvar.Func(func() any { return cached.Stats() }))
+		evictors = append(evictors, cached)
+		urlStore = cached
+	}
+
+	var suffixFilter *bloom.Filter
+	var synced *bloom.SyncedURLStore
+	if *bloomCapacity > 0 {
+		suffixFilter, err = bloom.New(*bloomCapacity, *bloomFPRate)
+		if err != nil {
+			log.Fatalf("error when creating suffix filter %v", err)
+		}
+		// disabled suffixes are kept too, so a miss needs no tombstone lookup
+		scan := func(add func(string)) error {
+			if err := backend.ForEachSuffix(context.Background(), add); err != nil {
+				return err
+			}
+			return backend.ForEachTombstone(context.Background(), add)
+		}
+		if err := suffixFilter.Rebuild(scan); err != nil {
+			log.Fatalf("error when building suffix filter %v", err)
+		}
+		expvar.Publish("suffixFilter", expvar.Func(func() any { return suffixFilter.Stats() }))
+		evictors = append(evictors, bloom.NewReplicaSync(suffixFilter, scan))
+		synced = bloom.NewSyncedURLStore(urlStore, suffixFilter)
+		urlStore = synced
+	}
+
+	if *cacheInvalidation && len(evictors) > 0 {
+		// only the outermost layer publishes, once per write
+		if synced != nil {
+			synced.SetPublisher(bus)
+		} else {
+			cached.SetPublisher(bus)
+		}
+		go bus.Run(context.Background(), evictors...)
+	}
+
 	shortenerConfig := shortener.NewDefaultConfig()
-	shortenerServer := server.NewURLShortenerServer(store, shortener.NewURLShortener(shortenerConfig, encoder))
-	log.Fatal(http.ListenAndServe(":5000", shortenerServer))
+	urlShortener := shortener.NewURLShortener(shortenerConfig, encoder)
+	shortenerServer := server.NewURLShortenerServer(urlStore, urlShortener)
+	if suffixFilter != nil {
+		shortenerServer.SetSuffixFilter(suffixFilter)
+	}
+	if *suffixPoolSize > 0 {
+		pool, err := newSuffixPool(urlShortener)
+		if err != nil {
+			log.Fatalf("error when creating suffix pool %v", err)
+		}
+		pool.SetTakenCheck(shortenerServer.SuffixTaken)
+		urlShortener.SetSuffixPool(pool)
+		expvar.Publish("suffixPool", expvar.Func(func() any { return pool.Stats() }))
+		go pool.Run(context.Background())
+	}
+	if err := shortenerServer.SetDomain(*domain); err != nil {
+		log.Fatalf("error when setting domain %v", err)
+	}
+	if err := shortenerServer.SetRedirectStatus(*redirectStatus); err != nil {
+		log.Fatalf("error when setting redirect status %v", err)
+	}
+	shortenerServer.SetAdminToken(*adminToken)
+
+	router := http.NewServeMux()
+	router.Handle("/debug/vars", expvar.Handler())
+	router.Handle("/", shortenerServer)
+	log.Fatal(http.ListenAndServe(":5000", router))
+}
+
+func newSuffixPool(urlShortener *shortener.URLShortener) (*shortener.SuffixPool, error) {
+	config := shortener.NewDefaultPoolConfig()
+	if err := config.SetSize(*suffixPoolSize); err != nil {
+		return nil, err
+	}
+	if err := config.SetLowWatermark(*suffixPoolLow); err != nil {
+		return nil, err
+	}
+	return shortener.NewSuffixPool(urlShortener.NextSuffix, config), nil
+}
+
+func newCachedStore(urlStore store.URLStore) (*cacheStore.CachedURLStore, error) {
+	config := cacheStore.NewDefaultConfig()
+	if err := config.SetSize(*cacheSize); err != nil {
+		return nil, err
+	}
+	if err := config.SetTTL(*cacheTTL); err != nil {
+		return nil, err
+	}
+	if err := config.SetNegativeTTL(*cacheNegativeTTL); err != nil {
+		return nil, err
+	}
+	return cacheStore.NewCachedURLStore(urlStore, config), nil
+}
+
+// envOr returns the environment variable, or fallback when it is unset.
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
 }
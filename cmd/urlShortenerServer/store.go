@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+	redisStore "github.com/0xKev/url-shortener/internal/store/redis"
+	replicatedStore "github.com/0xKev/url-shortener/internal/store/replicated"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisBackend is a single redis or a sharded set of them.
+type redisBackend interface {
+	store.URLStore
+	store.URLDeleter
+	Lookup(shortSuffix string) (string, error)
+	LookupURLPair(shortSuffix string) (model.URLPair, error)
+	ForEachSuffix(ctx context.Context, fn func(shortSuffix string)) error
+	ForEachTombstone(ctx context.Context, fn func(shortSuffix string)) error
+	InvalidationBus() *redisStore.InvalidationBus
+}
+
+func newRedisBackend() (redisBackend, error) {
+	if *redisShards == "" {
+		config, err := universalConfig()
+		if err != nil {
+			return nil, err
+		}
+		store, err := redisStore.NewUniversalRedisURLStore(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.SetKeyPrefix(*redisKeyPrefix); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+
+	configs, err := redisStore.ParseShardConfigs(*redisShards, *redisPassword, *redisDB)
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range configs {
+		if err := secure(&config.Options.Username, &config.Options.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+	shards, err := redisStore.NewShards(configs, *redisKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sharded, err := redisStore.NewShardedURLStore(shards)
+	if err != nil {
+		return nil, err
+	}
+	if *redisShardsPrevious != "" {
+		if err := sharded.SetPreviousShards(strings.Split(*redisShardsPrevious, ",")); err != nil {
+			return nil, err
+		}
+	}
+	return sharded, nil
+}
+
+// universalConfig turns the -redis-* flags into options for a single node,
+// a sentinel managed master or a cluster.
+func universalConfig() (*redis.UniversalOptions, error) {
+	addrs := strings.Split(*redisAddr, ",")
+	for i := range addrs {
+		addrs[i] = strings.TrimSpace(addrs[i])
+	}
+
+	var config *redis.UniversalOptions
+	switch {
+	case *redisMasterName != "":
+		config = redisStore.NewFailoverConfig(*redisMasterName, addrs, *redisUsername, *redisPassword, *redisDB)
+	case len(addrs) > 1:
+		if *redisDB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports DB 0, got -redis-db %d", *redisDB)
+		}
+		config = redisStore.NewClusterConfig(addrs, *redisUsername, *redisPassword)
+	default:
+		config = &redis.UniversalOptions{Addrs: addrs, Username: *redisUsername, Password: *redisPassword, DB: *redisDB}
+	}
+
+	if err := secure(&config.Username, &config.TLSConfig); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// secure applies -redis-username and the -redis-tls-* flags to a connection.
+func secure(username *string, tlsConfig **tls.Config) error {
+	*username = *redisUsername
+	if !*redisTLS {
+		return nil
+	}
+	config, err := redisStore.NewTLSConfig(*redisTLSCA, *redisTLSCert, *redisTLSKey, *redisTLSServerName)
+	if err != nil {
+		return err
+	}
+	*tlsConfig = config
+	return nil
+}
+
+// newReplicatedStore puts a circuit breaker in front of the backend and
+// spreads reads over any replicas.
+func newReplicatedStore(primary redisBackend) (*replicatedStore.ReplicatedURLStore, error) {
+	config := replicatedStore.NewDefaultConfig()
+	if err := config.SetFailureThreshold(*breakerFailures); err != nil {
+		return nil, err
+	}
+	if err := config.SetCooldown(*breakerCooldown); err != nil {
+		return nil, err
+	}
+
+	var replicas []replicatedStore.Backend
+	if *redisReplicas != "" {
+		if *redisShards != "" {
+			return nil, fmt.Errorf("-redis-replicas can't be combined with -redis-shards")
+		}
+		for _, addr := range strings.Split(*redisReplicas, ",") {
+			config := redisStore.NewRedisConfig(strings.TrimSpace(addr), *redisPassword, *redisDB)
+			if err := secure(&config.Username, &config.TLSConfig); err != nil {
+				return nil, err
+			}
+			replica, err := redisStore.NewRedisURLStore(config)
+			if err != nil {
+				return nil, fmt.Errorf("replica %v: %w", addr, err)
+			}
+			if err := replica.SetKeyPrefix(*redisKeyPrefix); err != nil {
+				return nil, err
+			}
+			replicas = append(replicas, replica)
+		}
+	}
+
+	replicated := replicatedStore.NewReplicatedURLStore(primary, replicas, config)
+	if *readSnapshot {
+		snapshot := memoryStore.NewInMemoryURLStore()
+		replicated.SetSnapshot(snapshot)
+		go fillSnapshot(primary, snapshot)
+	}
+	return replicated, nil
+}
+
+// fillSnapshot copies every link into the snapshot in the background, links
+// written or read meanwhile are added by the replicated store itself.
+func fillSnapshot(primary redisBackend, snapshot store.URLStore) {
+	var suffixes []string
+	err := primary.ForEachSuffix(context.Background(), func(shortSuffix string) {
+		suffixes = append(suffixes, shortSuffix)
+	})
+	if err != nil {
+		log.Printf("unable to fill read snapshot: %v", err)
+		return
+	}
+	for _, shortSuffix := range suffixes {
+		if urlPair, err := primary.LookupURLPair(shortSuffix); err == nil {
+			snapshot.Save(&urlPair)
+		}
+	}
+	log.Printf("Read snapshot holds %d links", len(suffixes))
+}
+
+func migrateKeys(backend redisBackend) error {
+	store, ok := backend.(*redisStore.RedisURLStore)
+	if !ok {
+		return fmt.Errorf("key migration is only supported on a single redis, run it before sharding")
+	}
+	result, err := store.MigrateUnprefixedKeys(context.Background())
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrated %d keys under prefix %q, skipped %d already present: %v", result.Migrated, *redisKeyPrefix, len(result.Skipped), result.Skipped)
+	return nil
+}
@@ -3,8 +3,9 @@ module github.com/0xKev/url-shortener
 go 1.23.0
 
 require (
-	github.com/approvals/go-approval-tests v0.0.0-20240417152556-434b9105e958 // indirect
-	github.com/cespare/xxhash/v2 v2.2.0 // indirect
-	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/redis/go-redis/v9 v9.6.1 // indirect
+	github.com/approvals/go-approval-tests v0.0.0-20240417152556-434b9105e958
+	github.com/cespare/xxhash/v2 v2.2.0
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f
+	github.com/redis/go-redis/v9 v9.6.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 )
@@ -26,7 +26,6 @@ var (
 )
 
 const (
-	redisAddr = "localhost:6379"
 	redisPass = ""
 	redisDB   = 9 // use 9 for tests
 )
@@ -35,7 +34,7 @@ func TestAPIRecordingBaseURLsAndRetrievingThem(t *testing.T) {
 	shortenerConfig := shortener.NewDefaultConfig()
 
 	urlShortener := shortener.NewURLShortener(shortenerConfig, encoder)
-	storeConfig := redis_store.NewRedisConfig(redisAddr, redisPass, redisDB)
+	storeConfig := redis_store.NewRedisConfig(testutil.RedisAddr(t), redisPass, redisDB)
 
 	store, err := redis_store.NewRedisURLStore(storeConfig)
 	if err != nil {
@@ -72,7 +71,7 @@ func TestAPIRecordingBaseURLsAndRetrievingThem(t *testing.T) {
 
 	response := httptest.NewRecorder()
 	shortenerServer.ServeHTTP(response, testutil.NewPostAPIShortenURLRequest(""))
-	testutil.AssertStatus(t, response.Code, http.StatusInternalServerError) // change to status 404 instead of 500
+	testutil.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
 }
 
 func TestHTMXRecordingBaseURLsAndRetrievingThem(t *testing.T) {
@@ -80,7 +79,7 @@ func TestHTMXRecordingBaseURLsAndRetrievingThem(t *testing.T) {
 	shortSuffixes := fetchShortSuffixes(t, shortenerConfig.URLCounter(), uint64(len(baseURLs)))
 	t.Log("length of shortSuffixes is", shortSuffixes)
 	urlShortener := shortener.NewURLShortener(shortenerConfig, encoder)
-	storeConfig := redis_store.NewRedisConfig(redisAddr, redisPass, redisDB)
+	storeConfig := redis_store.NewRedisConfig(testutil.RedisAddr(t), redisPass, redisDB)
 
 	store, err := redis_store.NewRedisURLStore(storeConfig)
 	if err != nil {
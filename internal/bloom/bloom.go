@@ -0,0 +1,227 @@
+package bloom
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	DefaultCapacity          = 1_000_000
+	DefaultFalsePositiveRate = 0.01
+
+	counterMax = 0x0f // counters are 4 bits, two per byte
+)
+
+// Filter is a counting Bloom filter over short suffixes. Each slot holds a
+// small counter rather than a bit so suffixes can be removed again when
+// links are deleted. A counter that saturates is never decremented, which
+// only ever costs a false positive, never a false negative.
+type Filter struct {
+	mu       sync.RWMutex
+	counters []byte
+	pending  []byte // filled by Rebuild, nil otherwise
+	// rebuilt counts the adds of each suffix to pending, only those may be
+	// removed from it again
+	rebuilt map[string]int
+	slots    uint64
+	hashes   uint64
+
+	capacity          uint64
+	falsePositiveRate float64
+
+	checks   atomic.Uint64
+	rejected atomic.Uint64
+	added    atomic.Uint64
+	removed  atomic.Uint64
+}
+
+type Stats struct {
+	Capacity          uint64  `json:"capacity"`
+	FalsePositiveRate float64 `json:"falsePositiveRate"`
+	Slots             uint64  `json:"slots"`
+	Hashes            uint64  `json:"hashes"`
+	Checks            uint64  `json:"checks"`
+	Rejected          uint64  `json:"rejected"`
+	Added             uint64  `json:"added"`
+	Removed           uint64  `json:"removed"`
+	// EstimatedFalsePositiveRate is derived from how full the filter
+	// currently is; once it drifts well above FalsePositiveRate the filter
+	// should be rebuilt with a larger capacity.
+	EstimatedFalsePositiveRate float64 `json:"estimatedFalsePositiveRate"`
+}
+
+// New sizes a filter to hold capacity suffixes at the given false positive rate.
+func New(capacity uint64, falsePositiveRate float64) (*Filter, error) {
+	if capacity == 0 {
+		return nil, fmt.Errorf("invalid bloom filter capacity: must be positive")
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, fmt.Errorf("invalid bloom filter false positive rate %v: must be between 0 and 1", falsePositiveRate)
+	}
+
+	n := float64(capacity)
+	slots := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := uint64(math.Max(1, math.Round(float64(slots)/n*math.Ln2)))
+
+	return &Filter{
+		counters:          make([]byte, (slots+1)/2),
+		slots:             slots,
+		hashes:            hashes,
+		capacity:          capacity,
+		falsePositiveRate: falsePositiveRate,
+	}, nil
+}
+
+// MightContain reports false only when shortSuffix was definitely never added.
+func (f *Filter) MightContain(shortSuffix string) bool {
+	f.checks.Add(1)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.pending != nil {
+		return true
+	}
+	for _, slot := range f.positions(shortSuffix) {
+		if counter(f.counters, slot) == 0 {
+			f.rejected.Add(1)
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Filter) Add(shortSuffix string) {
+	f.added.Add(1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	positions := f.positions(shortSuffix)
+	increment(f.counters, positions)
+	if f.pending != nil {
+		increment(f.pending, positions)
+		f.rebuilt[shortSuffix]++
+	}
+}
+
+// Remove undoes one Add. Removing a suffix that was never added corrupts the
+// filter, so callers only remove suffixes they know were stored. While a
+// rebuild runs the suffix is only removed from the new counters once the
+// scan or an Add has put it there, a suffix removed before the scan reaches
+// it is simply not found by the scan.
+func (f *Filter) Remove(shortSuffix string) {
+	f.removed.Add(1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	positions := f.positions(shortSuffix)
+	decrement(f.counters, positions)
+	if f.pending != nil && f.rebuilt[shortSuffix] > 0 {
+		decrement(f.pending, positions)
+		if f.rebuilt[shortSuffix]--; f.rebuilt[shortSuffix] == 0 {
+			delete(f.rebuilt, shortSuffix)
+		}
+	}
+}
+
+// Rebuild repopulates the filter from scratch with every suffix scan yields,
+// e.g. after deletes were missed. Until it finishes MightContain answers true
+// so no existing link is rejected, and concurrent Adds and Removes are
+// applied to both the old and the new counters, see Remove.
+func (f *Filter) Rebuild(scan func(add func(shortSuffix string)) error) error {
+	f.mu.Lock()
+	if f.pending != nil {
+		f.mu.Unlock()
+		return fmt.Errorf("bloom filter rebuild already running")
+	}
+	f.pending = make([]byte, len(f.counters))
+	f.rebuilt = map[string]int{}
+	f.mu.Unlock()
+
+	err := scan(func(shortSuffix string) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		increment(f.pending, f.positions(shortSuffix))
+		f.rebuilt[shortSuffix]++
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		f.counters = f.pending
+	}
+	f.pending, f.rebuilt = nil, nil
+	return err
+}
+
+func (f *Filter) Stats() Stats {
+	f.mu.RLock()
+	var used uint64
+	for slot := range f.slots {
+		if counter(f.counters, slot) != 0 {
+			used++
+		}
+	}
+	f.mu.RUnlock()
+
+	return Stats{
+		Capacity:                   f.capacity,
+		FalsePositiveRate:          f.falsePositiveRate,
+		Slots:                      f.slots,
+		Hashes:                     f.hashes,
+		Checks:                     f.checks.Load(),
+		Rejected:                   f.rejected.Load(),
+		Added:                      f.added.Load(),
+		Removed:                    f.removed.Load(),
+		EstimatedFalsePositiveRate: math.Pow(float64(used)/float64(f.slots), float64(f.hashes)),
+	}
+}
+
+// positions derives the filter positions for shortSuffix from one 64 bit hash
+// using double hashing (Kirsch-Mitzenmacher).
+func (f *Filter) positions(shortSuffix string) []uint64 {
+	sum := xxhash.Sum64String(shortSuffix)
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+
+	slots := make([]uint64, f.hashes)
+	for i := range slots {
+		slots[i] = (h1 + uint64(i)*h2) % f.slots
+	}
+	return slots
+}
+
+func increment(counters []byte, positions []uint64) {
+	for _, slot := range positions {
+		if c := counter(counters, slot); c < counterMax {
+			setCounter(counters, slot, c+1)
+		}
+	}
+}
+
+func decrement(counters []byte, positions []uint64) {
+	for _, slot := range positions {
+		if c := counter(counters, slot); c > 0 && c < counterMax {
+			setCounter(counters, slot, c-1)
+		}
+	}
+}
+
+func counter(counters []byte, slot uint64) byte {
+	b := counters[slot/2]
+	if slot%2 == 0 {
+		return b & 0x0f
+	}
+	return b >> 4
+}
+
+func setCounter(counters []byte, slot uint64, c byte) {
+	b := &counters[slot/2]
+	if slot%2 == 0 {
+		*b = *b&0xf0 | c
+	} else {
+		*b = *b&0x0f | c<<4
+	}
+}
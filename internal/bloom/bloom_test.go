@@ -0,0 +1,192 @@
+package bloom_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/0xKev/url-shortener/internal/base62"
+	"github.com/0xKev/url-shortener/internal/bloom"
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+type StubURLStore struct {
+	urlMap map[string]string
+	mu     sync.Mutex
+}
+
+func (s *StubURLStore) Save(urlPair *model.URLPair) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.urlMap[urlPair.ShortSuffix] = urlPair.BaseURL
+	return nil
+}
+
+func (s *StubURLStore) Load(shortSuffix string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	baseURL, found := s.urlMap[shortSuffix]
+	return baseURL, found
+}
+
+func (s *StubURLStore) Delete(shortSuffix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.urlMap, shortSuffix)
+	return nil
+}
+
+func newFilter(t testing.TB, capacity uint64) *bloom.Filter {
+	t.Helper()
+	filter, err := bloom.New(capacity, bloom.DefaultFalsePositiveRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filter
+}
+
+func TestFilter(t *testing.T) {
+	t.Run("never rejects an added suffix", func(t *testing.T) {
+		filter := newFilter(t, 10_000)
+
+		for i := range uint64(10_000) {
+			filter.Add(base62.Encode(i))
+		}
+		for i := range uint64(10_000) {
+			if !filter.MightContain(base62.Encode(i)) {
+				t.Fatalf("filter rejected added suffix %v", base62.Encode(i))
+			}
+		}
+	})
+
+	t.Run("false positive rate stays near the configured rate", func(t *testing.T) {
+		capacity := uint64(10_000)
+		filter := newFilter(t, capacity)
+		for i := range capacity {
+			filter.Add(base62.Encode(i))
+		}
+
+		probes := 100_000
+		falsePositives := 0
+		for i := range probes {
+			if filter.MightContain(base62.Encode(capacity + uint64(i))) {
+				falsePositives++
+			}
+		}
+
+		rate := float64(falsePositives) / float64(probes)
+		if rate > 2*bloom.DefaultFalsePositiveRate {
+			t.Errorf("false positive rate %v is well above the configured %v", rate, bloom.DefaultFalsePositiveRate)
+		}
+		stats := filter.Stats()
+		testutil.AssertEqual(t, stats.Checks, uint64(probes))
+		testutil.AssertEqual(t, stats.Rejected, uint64(probes-falsePositives))
+	})
+
+	t.Run("removed suffixes are rejected again", func(t *testing.T) {
+		filter := newFilter(t, 100)
+
+		filter.Add("0000001")
+		filter.Add("0000002")
+		filter.Remove("0000001")
+
+		testutil.AssertEqual(t, filter.MightContain("0000001"), false)
+		testutil.AssertEqual(t, filter.MightContain("0000002"), true)
+	})
+
+	t.Run("rebuild replaces the contents and accepts everything while running", func(t *testing.T) {
+		filter := newFilter(t, 100)
+		filter.Add("stale00")
+
+		err := filter.Rebuild(func(add func(string)) error {
+			testutil.AssertEqual(t, filter.MightContain("unknown"), true)
+			add("0000001")
+			filter.Add("0000002") // saved while the rebuild runs
+			return nil
+		})
+		testutil.AssertNoError(t, err)
+
+		testutil.AssertEqual(t, filter.MightContain("stale00"), false)
+		testutil.AssertEqual(t, filter.MightContain("0000001"), true)
+		testutil.AssertEqual(t, filter.MightContain("0000002"), true)
+	})
+
+	t.Run("remove while rebuilding keeps links the scan added", func(t *testing.T) {
+		// a single slot, every suffix shares its counter
+		filter, err := bloom.New(1, 0.9)
+		testutil.AssertNoError(t, err)
+		filter.Add("0000001")
+		filter.Add("0000002")
+		filter.Add("0000003")
+
+		err = filter.Rebuild(func(add func(string)) error {
+			add("0000001")
+			add("0000003")
+			filter.Remove("0000002") // deleted before the scan reached it
+			filter.Remove("0000003") // deleted after the scan added it
+			return nil
+		})
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, filter.MightContain("0000001"), true)
+
+		filter.Remove("0000001")
+		testutil.AssertEqual(t, filter.MightContain("0000001"), false)
+	})
+
+	t.Run("failed rebuild keeps the old contents", func(t *testing.T) {
+		filter := newFilter(t, 100)
+		filter.Add("0000001")
+
+		err := filter.Rebuild(func(add func(string)) error {
+			return fmt.Errorf("scan failed")
+		})
+		testutil.AssertError(t, err)
+		testutil.AssertEqual(t, filter.MightContain("0000001"), true)
+		testutil.AssertEqual(t, filter.MightContain("0000002"), false)
+	})
+
+	t.Run("rejects invalid configuration", func(t *testing.T) {
+		_, err := bloom.New(0, bloom.DefaultFalsePositiveRate)
+		testutil.AssertError(t, err)
+		_, err = bloom.New(100, 1)
+		testutil.AssertError(t, err)
+	})
+}
+
+func TestSyncedURLStore(t *testing.T) {
+	store := &StubURLStore{urlMap: map[string]string{}}
+	filter := newFilter(t, 100)
+	synced := bloom.NewSyncedURLStore(store, filter)
+
+	testutil.AssertNoError(t, synced.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "google.com"}))
+	testutil.AssertEqual(t, filter.MightContain("0000001"), true)
+
+	testutil.AssertNoError(t, synced.Delete("0000001"))
+	testutil.AssertEqual(t, filter.MightContain("0000001"), false)
+
+	// deleting a suffix that was never stored must not disturb other counters
+	testutil.AssertNoError(t, synced.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: "github.com"}))
+	testutil.AssertNoError(t, synced.Delete("0000002"))
+	testutil.AssertNoError(t, synced.Delete("0000002"))
+	testutil.AssertEqual(t, filter.Stats().Removed, uint64(2))
+}
+
+func TestReplicaSync(t *testing.T) {
+	filter := newFilter(t, 100)
+	replica := bloom.NewReplicaSync(filter, nil)
+
+	replica.Invalidate("0000001")
+
+	testutil.AssertEqual(t, filter.MightContain("0000001"), true)
+}
+
+func TestSyncedURLStoreConformance(t *testing.T) {
+	testutil.RunURLStoreConformance(t, func(t *testing.T) store.URLStore {
+		filter, err := bloom.New(1000, 0.01)
+		testutil.AssertNoError(t, err)
+		return bloom.NewSyncedURLStore(memoryStore.NewInMemoryURLStore(), filter)
+	})
+}
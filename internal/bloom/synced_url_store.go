@@ -0,0 +1,184 @@
+package bloom
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+)
+
+// Publisher tells other replicas that a suffix was written.
+type Publisher interface {
+	Publish(shortSuffix string) error
+}
+
+// SyncedURLStore keeps a Filter in step with the links written through it.
+type SyncedURLStore struct {
+	store     store.URLStore
+	filter    *Filter
+	publisher Publisher
+	deleteMu  sync.Mutex
+}
+
+func NewSyncedURLStore(urlStore store.URLStore, filter *Filter) *SyncedURLStore {
+	return &SyncedURLStore{store: urlStore, filter: filter}
+}
+
+// SetPublisher announces every saved or deleted suffix so other replicas'
+// filters (and caches) learn about it, see ReplicaSync.
+func (s *SyncedURLStore) SetPublisher(publisher Publisher) {
+	s.publisher = publisher
+}
+
+// Save adds the suffix even when it overwrites an existing link: counting it
+// twice only means a later delete leaves a false positive behind.
+func (s *SyncedURLStore) Save(urlPair *model.URLPair) error {
+	if err := s.store.Save(urlPair); err != nil {
+		return err
+	}
+	s.filter.Add(urlPair.ShortSuffix)
+	s.publish(urlPair.ShortSuffix)
+	return nil
+}
+
+func (s *SyncedURLStore) Load(shortSuffix string) (string, bool) {
+	return s.store.Load(shortSuffix)
+}
+
+func (s *SyncedURLStore) LoadURLPair(shortSuffix string) (model.URLPair, bool) {
+	return store.LoadURLPair(s.store, shortSuffix)
+}
+
+func (s *SyncedURLStore) LookupURLPair(shortSuffix string) (model.URLPair, error) {
+	return store.LookupURLPair(s.store, shortSuffix)
+}
+
+func (s *SyncedURLStore) ClaimSuffix(shortSuffix string) (bool, error) {
+	return store.ClaimSuffix(s.store, shortSuffix)
+}
+
+func (s *SyncedURLStore) Delete(shortSuffix string) error {
+	deleter, ok := s.store.(store.URLDeleter)
+	if !ok {
+		return fmt.Errorf("filtered store does not support deleting links")
+	}
+
+	// only remove what was really stored, removing anything else could
+	// zero a counter another suffix relies on
+	s.deleteMu.Lock()
+	defer s.deleteMu.Unlock()
+	_, existed := s.store.Load(shortSuffix)
+	if err := deleter.Delete(shortSuffix); err != nil {
+		return err
+	}
+	if existed {
+		s.filter.Remove(shortSuffix)
+	}
+	s.publish(shortSuffix)
+	return nil
+}
+
+func (s *SyncedURLStore) publish(shortSuffix string) {
+	if s.publisher == nil {
+		return
+	}
+	if err := s.publisher.Publish(shortSuffix); err != nil {
+		log.Printf("unable to publish filter update for %v: %v", shortSuffix, err)
+	}
+}
+
+// ReplicaSync applies changes announced by other replicas to the filter. An
+// announcement doesn't say whether the suffix was saved or deleted, so it is
+// always added: a stale entry only costs a store lookup. When announcements
+// may have been missed the filter is rebuilt with scan.
+type ReplicaSync struct {
+	filter *Filter
+	scan   func(add func(shortSuffix string)) error
+}
+
+func NewReplicaSync(filter *Filter, scan func(add func(shortSuffix string)) error) *ReplicaSync {
+	return &ReplicaSync{filter: filter, scan: scan}
+}
+
+func (r *ReplicaSync) Invalidate(shortSuffix string) {
+	r.filter.Add(shortSuffix)
+}
+
+func (r *ReplicaSync) Purge() {
+	go func() {
+		if err := r.filter.Rebuild(r.scan); err != nil {
+			log.Printf("unable to rebuild suffix filter: %v", err)
+		}
+	}()
+}
+
+func (s *SyncedURLStore) Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error) {
+	scanner, ok := s.store.(store.URLScanner)
+	if !ok {
+		return nil, "", fmt.Errorf("filtered store does not support scanning links")
+	}
+	return scanner.Scan(ctx, cursor, count)
+}
+
+// Disable leaves the suffix in the filter: the server only looks up the
+// tombstone of a suffix the filter might contain.
+func (s *SyncedURLStore) Disable(shortSuffix, reason string) (model.Tombstone, error) {
+	disabler, ok := s.store.(store.LinkDisabler)
+	if !ok {
+		return model.Tombstone{}, fmt.Errorf("filtered store does not support disabling links")
+	}
+	tombstone, err := disabler.Disable(shortSuffix, reason)
+	if err != nil {
+		return model.Tombstone{}, err
+	}
+	s.publish(shortSuffix)
+	return tombstone, nil
+}
+
+func (s *SyncedURLStore) Restore(shortSuffix string) error {
+	disabler, ok := s.store.(store.LinkDisabler)
+	if !ok {
+		return fmt.Errorf("filtered store does not support restoring links")
+	}
+	if err := disabler.Restore(shortSuffix); err != nil {
+		return err
+	}
+	s.filter.Add(shortSuffix)
+	s.publish(shortSuffix)
+	return nil
+}
+
+func (s *SyncedURLStore) Tombstone(shortSuffix string) (model.Tombstone, bool) {
+	disabler, ok := s.store.(store.LinkDisabler)
+	if !ok {
+		return model.Tombstone{}, false
+	}
+	return disabler.Tombstone(shortSuffix)
+}
+
+func (s *SyncedURLStore) CountClick(shortSuffix, variant string) error {
+	counter, ok := s.store.(store.ClickCounter)
+	if !ok {
+		return fmt.Errorf("filtered store does not support counting clicks")
+	}
+	return counter.CountClick(shortSuffix, variant)
+}
+
+func (s *SyncedURLStore) Clicks(shortSuffix string) (uint64, error) {
+	counter, ok := s.store.(store.ClickCounter)
+	if !ok {
+		return 0, fmt.Errorf("filtered store does not support counting clicks")
+	}
+	return counter.Clicks(shortSuffix)
+}
+
+func (s *SyncedURLStore) VariantClicks(shortSuffix string) (map[string]uint64, error) {
+	counter, ok := s.store.(store.ClickCounter)
+	if !ok {
+		return nil, fmt.Errorf("filtered store does not support counting clicks")
+	}
+	return counter.VariantClicks(shortSuffix)
+}
@@ -0,0 +1,137 @@
+package linkio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+)
+
+// aliasPattern is what a preserved back-half may contain. Anything else
+// would need escaping in the short URL.
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// HostedCSVParser reads the CSV exports of hosted shorteners. Columns are
+// found by header name, ignoring case, so the aliases cover the usual names
+// for the back-half, long URL, creation date and click total. The back-half
+// may also be a full short URL, its last path segment is kept.
+type HostedCSVParser struct {
+	SuffixColumns    []string
+	BaseURLColumns   []string
+	CreatedAtColumns []string
+	ClicksColumns    []string
+	// TimeLayouts are tried in order on creation dates, which are stored in
+	// RFC 3339. Dates without a zone are taken as UTC.
+	TimeLayouts []string
+}
+
+func NewHostedCSVParser() *HostedCSVParser {
+	return &HostedCSVParser{
+		SuffixColumns:    []string{"back-half", "back_half", "custom back-half", "backhalf", "alias", "slug", "keyword", "short link", "short_link", "short url", "short_url", "link", "bitlink"},
+		BaseURLColumns:   []string{"long url", "long_url", "destination", "destination url", "original url", "original_url", "target", "url"},
+		CreatedAtColumns: []string{"created", "created at", "created_at", "creation date", "date created", "date"},
+		ClicksColumns:    []string{"clicks", "click total", "total clicks", "total_clicks", "click_count", "visits"},
+		TimeLayouts:      []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02", "01/02/2006 15:04", "01/02/2006", "1/2/2006"},
+	}
+}
+
+func (h *HostedCSVParser) Parse(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error when reading csv header, %v", err)
+	}
+	suffixColumn := findColumn(header, h.SuffixColumns)
+	baseURLColumn := findColumn(header, h.BaseURLColumns)
+	if suffixColumn < 0 || baseURLColumn < 0 {
+		return nil, fmt.Errorf("invalid csv header: no back-half or long URL column in %v", header)
+	}
+	createdAtColumn := findColumn(header, h.CreatedAtColumns)
+	clicksColumn := findColumn(header, h.ClicksColumns)
+
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error when reading csv, %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		field := func(column int) string {
+			if column < 0 || column >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[column])
+		}
+
+		record := Record{
+			ShortSuffix: backHalf(field(suffixColumn)),
+			BaseURL:     field(baseURLColumn),
+			Row:         line,
+			Metadata:    map[string]string{model.MetadataImportedFrom: FormatHosted},
+		}
+		if record.ShortSuffix != "" && !aliasPattern.MatchString(record.ShortSuffix) {
+			return nil, fmt.Errorf("invalid back-half '%v' on row %d, only letters, digits, - and _ are kept", record.ShortSuffix, line)
+		}
+		if created := field(createdAtColumn); created != "" {
+			createdAt, err := h.parseTime(created)
+			if err != nil {
+				return nil, fmt.Errorf("invalid creation date on row %d, %v", line, err)
+			}
+			record.Metadata[model.MetadataCreatedAt] = createdAt.Format(time.RFC3339)
+		}
+		if clicks := field(clicksColumn); clicks != "" {
+			total, err := strconv.ParseUint(strings.ReplaceAll(clicks, ",", ""), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid click total '%v' on row %d", clicks, line)
+			}
+			record.Metadata[model.MetadataClicks] = strconv.FormatUint(total, 10)
+		}
+		records = append(records, record)
+	}
+}
+
+func (h *HostedCSVParser) parseTime(value string) (time.Time, error) {
+	for _, layout := range h.TimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date '%v'", value)
+}
+
+func findColumn(header, names []string) int {
+	for _, name := range names {
+		for i, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// backHalf turns "https://sho.rt/abc", "sho.rt/abc" or "abc" into "abc".
+func backHalf(value string) string {
+	if !strings.Contains(value, "/") {
+		return value
+	}
+	if !strings.Contains(value, "://") {
+		value = "https://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+	return strings.Trim(u.Path, "/")
+}
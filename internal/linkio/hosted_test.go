@@ -0,0 +1,74 @@
+package linkio_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/0xKev/url-shortener/internal/linkio"
+	"github.com/0xKev/url-shortener/internal/model"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+const hostedExport = `Title,Short link,Long URL,Date created,Total clicks
+Launch,https://bit.ly/launch-2023,https://example.com/launch,2023-04-01 09:30:00,"1,204"
+Docs,bit.ly/docs,https://example.com/docs,04/02/2023,0
+Taken,abc,https://example.com/other,2023-04-03,
+`
+
+func TestHostedCSVParser(t *testing.T) {
+	records, err := linkio.ReadRecords(strings.NewReader(hostedExport), linkio.FormatHosted)
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, len(records), 3)
+
+	launch := records[0]
+	testutil.AssertEqual(t, launch.ShortSuffix, "launch-2023")
+	testutil.AssertEqual(t, launch.BaseURL, "https://example.com/launch")
+	testutil.AssertEqual(t, launch.Row, 2)
+	testutil.AssertEqual(t, launch.Metadata[model.MetadataCreatedAt], "2023-04-01T09:30:00Z")
+	testutil.AssertEqual(t, launch.Metadata[model.MetadataClicks], "1204")
+	testutil.AssertEqual(t, launch.Metadata[model.MetadataImportedFrom], linkio.FormatHosted)
+
+	testutil.AssertEqual(t, records[1].ShortSuffix, "docs")
+	testutil.AssertEqual(t, records[1].Metadata[model.MetadataCreatedAt], "2023-04-02T00:00:00Z")
+	_, hasClicks := records[2].Metadata[model.MetadataClicks]
+	testutil.AssertEqual(t, hasClicks, false)
+
+	t.Run("reports conflicting rows", func(t *testing.T) {
+		store := memoryStore.NewInMemoryURLStore()
+		store.Save(&model.URLPair{ShortSuffix: "abc", BaseURL: "https://example.com/abc"})
+		reserved := func(shortSuffix string) bool { return shortSuffix == "docs" }
+
+		result, err := linkio.Import(records, store, linkio.ConflictSkip, reserved)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result.Imported, 1)
+		testutil.AssertEqual(t, result.Skipped, 2)
+		testutil.AssertEqual(t, len(result.Conflicts), 2)
+		testutil.AssertEqual(t, result.Conflicts[0], linkio.Conflict{Row: 3, ShortSuffix: "docs", Reason: linkio.ReasonReserved})
+		testutil.AssertEqual(t, result.Conflicts[1], linkio.Conflict{Row: 4, ShortSuffix: "abc", Reason: linkio.ReasonExists})
+	})
+
+	t.Run("rejects bad rows", func(t *testing.T) {
+		for name, export := range map[string]string{
+			"missing columns": "Title,Clicks\nLaunch,4\n",
+			"bad back-half":   "Back-half,Long URL\nsum mer,https://example.com\n",
+			"bad date":        "Back-half,Long URL,Created\nsummer,https://example.com,yesterday\n",
+			"bad clicks":      "Back-half,Long URL,Clicks\nsummer,https://example.com,many\n",
+		} {
+			_, err := linkio.ReadRecords(strings.NewReader(export), linkio.FormatHosted)
+			if err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+	})
+}
+
+func TestRegisterParser(t *testing.T) {
+	linkio.RegisterParser("lines", linkio.ParserFunc(func(r io.Reader) ([]linkio.Record, error) {
+		return []linkio.Record{{ShortSuffix: "one", BaseURL: "example.com", Row: 1}}, nil
+	}))
+	records, err := linkio.ReadRecords(strings.NewReader(""), "lines")
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, records[0].ShortSuffix, "one")
+}
@@ -0,0 +1,355 @@
+// Package linkio exports the complete link set to JSON lines or CSV and
+// imports such files back into a store.
+package linkio
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/0xKev/url-shortener/internal/model"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+	// FormatHosted is the CSV export of hosted shorteners, see
+	// HostedCSVParser.
+	FormatHosted = "hosted"
+
+	batchSize = 500
+)
+
+// ErrConflict is returned by Import under ConflictFail when some records
+// conflict. Nothing is written in that case.
+var ErrConflict = errors.New("suffixes already in use")
+
+var csvHeader = []string{"shortSuffix", "baseURL", "domain", "metadata"}
+
+// Record is one exported link. Domain is the domain the link was served on
+// when exported, imports don't use it since the domain is set per server.
+type Record struct {
+	ShortSuffix string            `json:"shortSuffix"`
+	BaseURL     string            `json:"baseURL"`
+	Domain      string            `json:"domain,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// Row is where the record was read from, for error reports.
+	Row int `json:"-"`
+}
+
+// ConflictPolicy decides what Import does with a suffix that already points
+// to another base URL.
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+)
+
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(policy); p {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy '%v', expected skip, overwrite or fail", policy)
+}
+
+// ValidateFormat checks an export format.
+func ValidateFormat(format string) error {
+	if format != FormatJSONL && format != FormatCSV {
+		return fmt.Errorf("invalid format '%v', expected jsonl or csv", format)
+	}
+	return nil
+}
+
+// Scanner and Store are the parts of store.URLScanner and store.URLStore
+// exports and imports need.
+type Scanner interface {
+	Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error)
+}
+
+type Store interface {
+	Save(*model.URLPair) error
+	Load(shortSuffix string) (string, bool)
+}
+
+// Export writes every link of the scanner to w and returns how many it
+// wrote. domain is recorded on every link.
+func Export(ctx context.Context, scanner Scanner, domain, format string, w io.Writer) (int, error) {
+	if err := ValidateFormat(format); err != nil {
+		return 0, err
+	}
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	write := func(r Record) error { return encoder.Encode(r) }
+	var csvWriter *csv.Writer
+	if format == FormatCSV {
+		csvWriter = csv.NewWriter(buffered)
+		if err := csvWriter.Write(csvHeader); err != nil {
+			return 0, err
+		}
+		write = func(r Record) error {
+			metadata := ""
+			if len(r.Metadata) > 0 {
+				encoded, err := json.Marshal(r.Metadata)
+				if err != nil {
+					return err
+				}
+				metadata = string(encoded)
+			}
+			return csvWriter.Write([]string{r.ShortSuffix, r.BaseURL, r.Domain, metadata})
+		}
+	}
+
+	written := 0
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		pairs, next, err := scanner.Scan(ctx, cursor, batchSize)
+		if err != nil {
+			return written, err
+		}
+		for _, pair := range pairs {
+			if err := write(Record{ShortSuffix: pair.ShortSuffix, BaseURL: pair.BaseURL, Domain: domain, Metadata: pair.Metadata}); err != nil {
+				return written, fmt.Errorf("error when writing export, %v", err)
+			}
+			written++
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return written, fmt.Errorf("error when writing export, %v", err)
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return written, fmt.Errorf("error when writing export, %v", err)
+	}
+	return written, nil
+}
+
+// Parser reads records from a file format. Records should carry the row
+// they were read from.
+type Parser interface {
+	Parse(r io.Reader) ([]Record, error)
+}
+
+type ParserFunc func(r io.Reader) ([]Record, error)
+
+func (f ParserFunc) Parse(r io.Reader) ([]Record, error) {
+	return f(r)
+}
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = map[string]Parser{
+		FormatJSONL:  ParserFunc(readJSONL),
+		FormatCSV:    ParserFunc(readCSV),
+		FormatHosted: NewHostedCSVParser(),
+	}
+)
+
+// RegisterParser makes ReadRecords accept another import format.
+func RegisterParser(format string, parser Parser) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	parsers[format] = parser
+}
+
+// ImportFormats lists the formats ReadRecords accepts.
+func ImportFormats() []string {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	formats := make([]string, 0, len(parsers))
+	for format := range parsers {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+	return formats
+}
+
+// ReadRecords parses a whole file, rejecting it if any record lacks a
+// suffix or base URL.
+func ReadRecords(r io.Reader, format string) ([]Record, error) {
+	parsersMu.RLock()
+	parser, ok := parsers[format]
+	parsersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("invalid format '%v', expected one of %v", format, strings.Join(ImportFormats(), ", "))
+	}
+
+	records, err := parser.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.ShortSuffix == "" || record.BaseURL == "" {
+			return nil, fmt.Errorf("invalid record on row %d: shortSuffix and baseURL are required", record.Row)
+		}
+	}
+	return records, nil
+}
+
+func readJSONL(r io.Reader) ([]Record, error) {
+	var records []Record
+	decoder := json.NewDecoder(r)
+	for {
+		var record Record
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error when reading record %d, %v", len(records)+1, err)
+		}
+		record.Row = len(records) + 1
+		records = append(records, record)
+	}
+}
+
+func readCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error when reading csv header, %v", err)
+	}
+	columns := map[string]int{}
+	for _, name := range csvHeader {
+		columns[name] = slices.Index(header, name)
+	}
+	if columns["shortSuffix"] < 0 || columns["baseURL"] < 0 {
+		return nil, fmt.Errorf("invalid csv header: shortSuffix and baseURL columns are required")
+	}
+	field := func(row []string, name string) string {
+		if i := columns[name]; i >= 0 {
+			return row[i]
+		}
+		return ""
+	}
+
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error when reading csv, %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		record := Record{
+			ShortSuffix: field(row, "shortSuffix"),
+			BaseURL:     field(row, "baseURL"),
+			Domain:      field(row, "domain"),
+			Row:         line,
+		}
+		if metadata := field(row, "metadata"); metadata != "" {
+			if err := json.Unmarshal([]byte(metadata), &record.Metadata); err != nil {
+				return nil, fmt.Errorf("invalid metadata on row %d, %v", line, err)
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+// Conflict is a record Import did not write as is.
+type Conflict struct {
+	Row         int    `json:"row"`
+	ShortSuffix string `json:"shortSuffix"`
+	Reason      string `json:"reason"`
+}
+
+const (
+	ReasonExists   = "suffix already points to another base URL"
+	ReasonReserved = "suffix is a reserved route"
+)
+
+type ImportResult struct {
+	Imported    int `json:"imported"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+	Unchanged   int `json:"unchanged"`
+	// Conflicts lists the records whose suffix already pointed to another
+	// base URL or can't be served because a route uses it.
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+}
+
+// Import saves the records into the store. A record whose suffix already
+// holds the same base URL is left alone, other existing suffixes are handled
+// as policy says. Suffixes reserved reports as routes are never written and
+// fail the whole import under ConflictFail. reserved may be nil. On a failed
+// save the result holds what was done so far.
+func Import(records []Record, store Store, policy ConflictPolicy, reserved func(shortSuffix string) bool) (ImportResult, error) {
+	var result ImportResult
+	if _, err := ParseConflictPolicy(string(policy)); err != nil {
+		return result, err
+	}
+	conflict := func(record Record) (string, bool) {
+		if reserved != nil && reserved(record.ShortSuffix) {
+			return ReasonReserved, true
+		}
+		baseURL, found := store.Load(record.ShortSuffix)
+		if found && baseURL != record.BaseURL {
+			return ReasonExists, true
+		}
+		return "", false
+	}
+
+	if policy == ConflictFail {
+		for _, record := range records {
+			if reason, ok := conflict(record); ok {
+				result.Conflicts = append(result.Conflicts, Conflict{Row: record.Row, ShortSuffix: record.ShortSuffix, Reason: reason})
+			}
+		}
+		if len(result.Conflicts) > 0 {
+			return result, fmt.Errorf("%w: %d records conflict", ErrConflict, len(result.Conflicts))
+		}
+	}
+
+	for _, record := range records {
+		if reserved != nil && reserved(record.ShortSuffix) {
+			result.Conflicts = append(result.Conflicts, Conflict{Row: record.Row, ShortSuffix: record.ShortSuffix, Reason: ReasonReserved})
+			result.Skipped++
+			continue
+		}
+		baseURL, found := store.Load(record.ShortSuffix)
+		if found && baseURL == record.BaseURL {
+			result.Unchanged++
+			continue
+		}
+		if found {
+			result.Conflicts = append(result.Conflicts, Conflict{Row: record.Row, ShortSuffix: record.ShortSuffix, Reason: ReasonExists})
+			if policy == ConflictSkip {
+				result.Skipped++
+				continue
+			}
+		}
+
+		urlPair := model.URLPair{ShortSuffix: record.ShortSuffix, BaseURL: record.BaseURL, Metadata: record.Metadata}
+		if err := store.Save(&urlPair); err != nil {
+			return result, fmt.Errorf("error when importing %s, %w", record.ShortSuffix, err)
+		}
+		if found {
+			result.Overwritten++
+		} else {
+			result.Imported++
+		}
+	}
+	return result, nil
+}
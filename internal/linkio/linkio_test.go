@@ -0,0 +1,98 @@
+package linkio_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/0xKev/url-shortener/internal/linkio"
+	"github.com/0xKev/url-shortener/internal/model"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+func newStore(t *testing.T) *memoryStore.InMemoryURLStore {
+	t.Helper()
+	store := memoryStore.NewInMemoryURLStore()
+	testutil.AssertNoError(t, store.Save(&model.URLPair{ShortSuffix: "abc", BaseURL: "example.com", Metadata: map[string]string{"owner": "ops, marketing"}}))
+	testutil.AssertNoError(t, store.Save(&model.URLPair{ShortSuffix: "def", BaseURL: "example.org"}))
+	return store
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{linkio.FormatJSONL, linkio.FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			written, err := linkio.Export(context.Background(), newStore(t), "https://sho.rt/", format, &buf)
+			testutil.AssertNoError(t, err)
+			testutil.AssertEqual(t, written, 2)
+
+			records, err := linkio.ReadRecords(&buf, format)
+			testutil.AssertNoError(t, err)
+			testutil.AssertEqual(t, len(records), 2)
+			testutil.AssertEqual(t, records[0].ShortSuffix, "abc")
+			testutil.AssertEqual(t, records[0].Domain, "https://sho.rt/")
+			testutil.AssertEqual(t, records[0].Metadata["owner"], "ops, marketing")
+
+			destination := memoryStore.NewInMemoryURLStore()
+			result, err := linkio.Import(records, destination, linkio.ConflictFail, nil)
+			testutil.AssertNoError(t, err)
+			testutil.AssertEqual(t, result.Imported, 2)
+
+			pairs, _, err := destination.Scan(context.Background(), "", 10)
+			testutil.AssertNoError(t, err)
+			testutil.AssertEqual(t, pairs[0].Metadata["owner"], "ops, marketing")
+			testutil.AssertEqual(t, pairs[1].BaseURL, "example.org")
+		})
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	records := []linkio.Record{
+		{ShortSuffix: "abc", BaseURL: "example.com"},
+		{ShortSuffix: "def", BaseURL: "changed.org"},
+		{ShortSuffix: "ghi", BaseURL: "example.net"},
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		store := newStore(t)
+		result, err := linkio.Import(records, store, linkio.ConflictSkip, nil)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result.Imported, 1)
+		testutil.AssertEqual(t, result.Skipped, 1)
+		testutil.AssertEqual(t, result.Unchanged, 1)
+		baseURL, _ := store.Load("def")
+		testutil.AssertEqual(t, baseURL, "example.org")
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		store := newStore(t)
+		result, err := linkio.Import(records, store, linkio.ConflictOverwrite, nil)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result.Overwritten, 1)
+		baseURL, _ := store.Load("def")
+		testutil.AssertEqual(t, baseURL, "changed.org")
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		store := newStore(t)
+		result, err := linkio.Import(records, store, linkio.ConflictFail, nil)
+		testutil.AssertEqual(t, errors.Is(err, linkio.ErrConflict), true)
+		testutil.AssertEqual(t, len(result.Conflicts), 1)
+		_, found := store.Load("ghi")
+		testutil.AssertEqual(t, found, false)
+	})
+}
+
+func TestReadRecordsValidation(t *testing.T) {
+	_, err := linkio.ReadRecords(strings.NewReader(`{"shortSuffix":"abc"}`), linkio.FormatJSONL)
+	testutil.AssertError(t, err)
+
+	_, err = linkio.ReadRecords(strings.NewReader("suffix,url\nabc,example.com\n"), linkio.FormatCSV)
+	testutil.AssertError(t, err)
+
+	_, err = linkio.ReadRecords(strings.NewReader(""), "xml")
+	testutil.AssertError(t, err)
+}
@@ -0,0 +1,283 @@
+// Package migration copies every link from one URL store to another, with
+// its metadata. Tombstones and click counts are left behind: disabled links
+// aren't copied and copied links count their clicks from zero.
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+)
+
+const DefaultBatchSize = 500
+
+// Syncer is implemented by stores that buffer writes. A checkpoint is only
+// written once the links before it are synced.
+type Syncer interface {
+	Sync() error
+}
+
+// Result counts what a migration did, or would do on a dry run.
+type Result struct {
+	Scanned     int `json:"scanned"`
+	Copied      int `json:"copied"`
+	Overwritten int `json:"overwritten"`
+	Unchanged   int `json:"unchanged"`
+}
+
+// Job names the stores a migration copies between. A checkpoint only
+// resumes the job that wrote it, a cursor means nothing to another source.
+type Job struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	FromKeyPrefix string `json:"fromKeyPrefix,omitempty"`
+	ToKeyPrefix   string `json:"toKeyPrefix,omitempty"`
+}
+
+// Checkpoint is where an interrupted migration resumes.
+type Checkpoint struct {
+	Job    Job    `json:"job"`
+	Cursor string `json:"cursor"`
+	Result Result `json:"result"`
+}
+
+type Migrator struct {
+	source         store.URLScanner
+	destination    store.URLStore
+	batchSize      int
+	dryRun         bool
+	checkpointPath string
+	job            Job
+}
+
+func NewMigrator(source store.URLScanner, destination store.URLStore) *Migrator {
+	return &Migrator{
+		source:      source,
+		destination: destination,
+		batchSize:   DefaultBatchSize,
+	}
+}
+
+func (m *Migrator) SetBatchSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("batch size must be at least 1, got %d", size)
+	}
+	m.batchSize = size
+	return nil
+}
+
+// SetDryRun makes Run count what it would copy without writing anything,
+// checkpoints included.
+func (m *Migrator) SetDryRun(dryRun bool) {
+	m.dryRun = dryRun
+}
+
+// SetCheckpoint makes Run resume job from the checkpoint at path and record
+// its progress there after every batch. Run fails when the checkpoint was
+// written by another job. The file is removed once Run completes.
+func (m *Migrator) SetCheckpoint(path string, job Job) {
+	m.checkpointPath = path
+	m.job = job
+}
+
+// Run copies the links of the source into the destination. Links already in
+// the destination are overwritten when their base URL differs. On error the
+// returned result holds what was done, including previous runs resumed from
+// the checkpoint.
+func (m *Migrator) Run(ctx context.Context) (Result, error) {
+	checkpoint, err := m.loadCheckpoint()
+	if err != nil {
+		return Result{}, err
+	}
+	result := checkpoint.Result
+	cursor := checkpoint.Cursor
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		pairs, next, err := m.source.Scan(ctx, cursor, m.batchSize)
+		if err != nil {
+			return result, err
+		}
+		for i := range pairs {
+			current, found := store.LoadURLPair(m.destination, pairs[i].ShortSuffix)
+			if found && current.SameLink(pairs[i]) {
+				result.Scanned++
+				result.Unchanged++
+				continue
+			}
+			if !m.dryRun {
+				if err := m.destination.Save(&pairs[i]); err != nil {
+					return result, fmt.Errorf("error when copying %s, %v", pairs[i].ShortSuffix, err)
+				}
+			}
+			result.Scanned++
+			if found {
+				result.Overwritten++
+			} else {
+				result.Copied++
+			}
+		}
+
+		if next == "" {
+			return result, m.finish()
+		}
+		cursor = next
+		if err := m.saveCheckpoint(Checkpoint{Job: m.job, Cursor: cursor, Result: result}); err != nil {
+			return result, err
+		}
+	}
+}
+
+func (m *Migrator) loadCheckpoint() (Checkpoint, error) {
+	var checkpoint Checkpoint
+	if m.checkpointPath == "" || m.dryRun {
+		return checkpoint, nil
+	}
+	data, err := os.ReadFile(m.checkpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, fmt.Errorf("error when reading checkpoint, %v", err)
+	}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("error when reading checkpoint %s, %v", m.checkpointPath, err)
+	}
+	if checkpoint.Job != m.job {
+		return Checkpoint{}, fmt.Errorf("checkpoint %s is for a migration from %s to %s, finish that one or remove the checkpoint",
+			m.checkpointPath, checkpoint.Job.From, checkpoint.Job.To)
+	}
+	return checkpoint, nil
+}
+
+// saveCheckpoint syncs the destination, then replaces the checkpoint file so
+// a crash leaves either the old or the new one behind.
+func (m *Migrator) saveCheckpoint(checkpoint Checkpoint) error {
+	if m.checkpointPath == "" || m.dryRun {
+		return nil
+	}
+	if err := m.sync(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("error when encoding checkpoint, %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.checkpointPath), filepath.Base(m.checkpointPath)+".*")
+	if err != nil {
+		return fmt.Errorf("error when writing checkpoint, %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error when writing checkpoint, %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error when writing checkpoint, %v", err)
+	}
+	if err := os.Rename(tmp.Name(), m.checkpointPath); err != nil {
+		return fmt.Errorf("error when writing checkpoint, %v", err)
+	}
+	return nil
+}
+
+func (m *Migrator) finish() error {
+	if m.dryRun {
+		return nil
+	}
+	if err := m.sync(); err != nil {
+		return err
+	}
+	if m.checkpointPath == "" {
+		return nil
+	}
+	if err := os.Remove(m.checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error when removing checkpoint, %v", err)
+	}
+	return nil
+}
+
+func (m *Migrator) sync() error {
+	if syncer, ok := m.destination.(Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// Verification compares a source with the destination it was migrated to.
+type Verification struct {
+	Source      int
+	Destination int
+	Missing     int
+	Mismatched  int
+}
+
+// OK reports whether every source link is in the destination unchanged.
+func (v Verification) OK() bool {
+	return v.Missing == 0 && v.Mismatched == 0
+}
+
+// Verify looks every source link up in the destination, a link is
+// mismatched when its base URL or metadata differ. Destination is -1 when
+// the destination can't be scanned.
+func Verify(ctx context.Context, source store.URLScanner, destination store.URLStore) (Verification, error) {
+	var verification Verification
+	err := scanAll(ctx, source, func(pair model.URLPair) {
+		verification.Source++
+		got, found := store.LoadURLPair(destination, pair.ShortSuffix)
+		switch {
+		case !found:
+			verification.Missing++
+		case !got.SameLink(pair):
+			verification.Mismatched++
+		}
+	})
+	if err != nil {
+		return verification, err
+	}
+
+	scanner, ok := destination.(store.URLScanner)
+	if !ok {
+		verification.Destination = -1
+		return verification, nil
+	}
+	verification.Destination, err = Count(ctx, scanner)
+	return verification, err
+}
+
+// Count returns how many links a store holds. Links returned twice by the
+// scan are counted twice.
+func Count(ctx context.Context, scanner store.URLScanner) (int, error) {
+	count := 0
+	err := scanAll(ctx, scanner, func(model.URLPair) { count++ })
+	return count, err
+}
+
+func scanAll(ctx context.Context, scanner store.URLScanner, fn func(pair model.URLPair)) error {
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pairs, next, err := scanner.Scan(ctx, cursor, DefaultBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			fn(pair)
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
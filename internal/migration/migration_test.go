@@ -0,0 +1,157 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+// FailingStore accepts saves until failAfter of them went through.
+type FailingStore struct {
+	*memoryStore.InMemoryURLStore
+	failAfter int
+	saves     int
+}
+
+func (f *FailingStore) Save(urlPair *model.URLPair) error {
+	if f.saves == f.failAfter {
+		return errors.New("disk full")
+	}
+	f.saves++
+	return f.InMemoryURLStore.Save(urlPair)
+}
+
+func newSource(t *testing.T, links int) *memoryStore.InMemoryURLStore {
+	t.Helper()
+	source := memoryStore.NewInMemoryURLStore()
+	for i := 0; i < links; i++ {
+		testutil.AssertNoError(t, source.Save(&model.URLPair{ShortSuffix: fmt.Sprintf("%04d", i), BaseURL: fmt.Sprintf("example.com/%d", i)}))
+	}
+	return source
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("copies every link and verifies them", func(t *testing.T) {
+		source := newSource(t, 25)
+		destination := memoryStore.NewInMemoryURLStore()
+		destination.Save(&model.URLPair{ShortSuffix: "0001", BaseURL: "example.com/1"})
+		destination.Save(&model.URLPair{ShortSuffix: "0002", BaseURL: "stale.com"})
+
+		migrator := NewMigrator(source, destination)
+		testutil.AssertNoError(t, migrator.SetBatchSize(10))
+		result, err := migrator.Run(ctx)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result, Result{Scanned: 25, Copied: 23, Overwritten: 1, Unchanged: 1})
+
+		verification, err := Verify(ctx, source, destination)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, verification, Verification{Source: 25, Destination: 25})
+		testutil.AssertEqual(t, verification.OK(), true)
+	})
+
+	t.Run("overwrites links whose metadata differs", func(t *testing.T) {
+		source := memoryStore.NewInMemoryURLStore()
+		source.Save(&model.URLPair{ShortSuffix: "0000", BaseURL: "example.com", Metadata: map[string]string{model.MetadataForwardQuery: "true"}})
+		destination := memoryStore.NewInMemoryURLStore()
+		destination.Save(&model.URLPair{ShortSuffix: "0000", BaseURL: "example.com"})
+
+		verification, err := Verify(ctx, source, destination)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, verification.Mismatched, 1)
+
+		result, err := NewMigrator(source, destination).Run(ctx)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result, Result{Scanned: 1, Overwritten: 1})
+		urlPair, _ := destination.LoadURLPair("0000")
+		testutil.AssertEqual(t, urlPair.ForwardsQuery(), true)
+	})
+
+	t.Run("dry run writes nothing", func(t *testing.T) {
+		source := newSource(t, 5)
+		destination := memoryStore.NewInMemoryURLStore()
+		checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+
+		migrator := NewMigrator(source, destination)
+		migrator.SetDryRun(true)
+		migrator.SetCheckpoint(checkpoint, Job{From: "file:source.jsonl", To: "file:destination.jsonl"})
+		result, err := migrator.Run(ctx)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result, Result{Scanned: 5, Copied: 5})
+
+		count, err := Count(ctx, destination)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, count, 0)
+		_, err = os.Stat(checkpoint)
+		testutil.AssertEqual(t, errors.Is(err, os.ErrNotExist), true)
+	})
+
+	t.Run("resumes from the checkpoint", func(t *testing.T) {
+		source := newSource(t, 25)
+		destination := &FailingStore{InMemoryURLStore: memoryStore.NewInMemoryURLStore(), failAfter: 15}
+		checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+
+		migrator := NewMigrator(source, destination)
+		testutil.AssertNoError(t, migrator.SetBatchSize(10))
+		migrator.SetCheckpoint(checkpoint, Job{From: "file:source.jsonl", To: "file:destination.jsonl"})
+		result, err := migrator.Run(ctx)
+		testutil.AssertError(t, err)
+		testutil.AssertEqual(t, result.Copied, 15)
+
+		destination.failAfter = -1
+		result, err = migrator.Run(ctx)
+		testutil.AssertNoError(t, err)
+		// the batch that failed is copied again from its start
+		testutil.AssertEqual(t, result, Result{Scanned: 25, Copied: 20, Unchanged: 5})
+		testutil.AssertEqual(t, destination.saves, 25)
+
+		_, err = os.Stat(checkpoint)
+		testutil.AssertEqual(t, errors.Is(err, os.ErrNotExist), true)
+	})
+
+	t.Run("refuses a checkpoint written by another migration", func(t *testing.T) {
+		source := newSource(t, 25)
+		destination := &FailingStore{InMemoryURLStore: memoryStore.NewInMemoryURLStore(), failAfter: 15}
+		checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+
+		migrator := NewMigrator(source, destination)
+		testutil.AssertNoError(t, migrator.SetBatchSize(10))
+		migrator.SetCheckpoint(checkpoint, Job{From: "file:source.jsonl", To: "file:destination.jsonl"})
+		_, err := migrator.Run(ctx)
+		testutil.AssertError(t, err)
+
+		destination.failAfter = -1
+		migrator.SetCheckpoint(checkpoint, Job{From: "file:other.jsonl", To: "file:destination.jsonl"})
+		result, err := migrator.Run(ctx)
+		testutil.AssertError(t, err)
+		testutil.AssertEqual(t, result, Result{})
+		_, err = os.Stat(checkpoint)
+		testutil.AssertNoError(t, err)
+	})
+
+	t.Run("rejects an empty batch", func(t *testing.T) {
+		migrator := NewMigrator(memoryStore.NewInMemoryURLStore(), memoryStore.NewInMemoryURLStore())
+		testutil.AssertError(t, migrator.SetBatchSize(0))
+	})
+}
+
+func TestVerify(t *testing.T) {
+	source := newSource(t, 3)
+	destination := memoryStore.NewInMemoryURLStore()
+	destination.Save(&model.URLPair{ShortSuffix: "0000", BaseURL: "example.com/0"})
+	destination.Save(&model.URLPair{ShortSuffix: "0001", BaseURL: "other.com"})
+	destination.Save(&model.URLPair{ShortSuffix: "9999", BaseURL: "extra.com"})
+
+	verification, err := Verify(context.Background(), source, destination)
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, verification, Verification{Source: 3, Destination: 3, Missing: 1, Mismatched: 1})
+	testutil.AssertEqual(t, verification.OK(), false)
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// LinkPreview is what the preview page of a short link shows instead of
+// redirecting, so the destination can be checked before following it.
+type LinkPreview struct {
+	ShortURL string `json:"shortURL"`
+	BaseURL  string `json:"baseURL"`
+	// Domain is the host of the destination.
+	Domain    string     `json:"domain"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	Clicks    uint64     `json:"clicks"`
+	// QRCode is a PNG of the short URL.
+	QRCode []byte `json:"qrCode,omitempty"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reasons a link is disabled for.
+const (
+	DisabledForAbuse        = "abuse"
+	DisabledByOwner         = "owner-request"
+	DisabledAfterExpiration = "expired"
+)
+
+// Tombstone is what remains of a disabled link. It keeps the link so it can
+// be restored, and keeps the suffix taken so it is never handed out again.
+type Tombstone struct {
+	ShortSuffix string            `json:"shortSuffix"`
+	BaseURL     string            `json:"baseURL"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Reason      string            `json:"reason"`
+	DisabledAt  time.Time         `json:"disabledAt"`
+}
+
+// ValidateDisableReason accepts the reasons above.
+func ValidateDisableReason(reason string) error {
+	switch reason {
+	case DisabledForAbuse, DisabledByOwner, DisabledAfterExpiration:
+		return nil
+	}
+	return fmt.Errorf("invalid reason '%v', expected %v, %v or %v", reason, DisabledForAbuse, DisabledByOwner, DisabledAfterExpiration)
+}
+
+// URLPair returns the link the tombstone replaced.
+func (t Tombstone) URLPair() URLPair {
+	return URLPair{ShortSuffix: t.ShortSuffix, BaseURL: t.BaseURL, Metadata: t.Metadata}
+}
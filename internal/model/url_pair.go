@@ -1,8 +1,151 @@
 package model
 
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Well known Metadata keys.
+const (
+	// MetadataCreatedAt is when the link was created, in RFC 3339.
+	MetadataCreatedAt = "createdAt"
+	// MetadataClicks is a click total carried over from elsewhere.
+	MetadataClicks = "clicks"
+	// MetadataImportedFrom names the format a link was imported from.
+	MetadataImportedFrom = "importedFrom"
+	// MetadataExpiresAt is when stores stop returning the link, in RFC 3339.
+	MetadataExpiresAt = "expiresAt"
+	// MetadataRedirectStatus is the status code the link redirects with,
+	// links without one use the server default.
+	MetadataRedirectStatus = "redirectStatus"
+	// MetadataForwardQuery is "true" for links that pass the query string of
+	// the short URL on to the destination.
+	MetadataForwardQuery = "forwardQuery"
+	// MetadataForwardPath is "true" for links that append the path following
+	// the suffix to the destination.
+	MetadataForwardPath = "forwardPath"
+	// MetadataDestinationPrefix followed by a platform is the destination of
+	// the link for clients on that platform, as in destination:ios.
+	MetadataDestinationPrefix = "destination:"
+)
+
+// Platforms links can have a destination of their own for.
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformDesktop = "desktop"
+	PlatformBot     = "bot"
+)
+
+// Platforms lists the platforms above.
+var Platforms = []string{PlatformIOS, PlatformAndroid, PlatformDesktop, PlatformBot}
+
 type URLPair struct {
 	ShortSuffix string `json:"shortSuffix"`
 	BaseURL     string `json:"baseURL"`
 	Domain      string `json:"domain"`
 	Error       string `json:"error"`
+	// Metadata holds optional per link details kept alongside the base URL,
+	// stores that can't keep it drop it.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ExpiresAt returns when the link expires, false if it never does or the
+// date can't be read.
+func (u URLPair) ExpiresAt() (time.Time, bool) {
+	value, ok := u.Metadata[MetadataExpiresAt]
+	if !ok {
+		return time.Time{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// Expired reports whether the link expired by now.
+func (u URLPair) Expired(now time.Time) bool {
+	expiresAt, ok := u.ExpiresAt()
+	return ok && !now.Before(expiresAt)
+}
+
+// SameLink reports whether both pairs hold the same base URL and metadata,
+// whatever their suffix and domain.
+func (u URLPair) SameLink(other URLPair) bool {
+	return u.BaseURL == other.BaseURL && maps.Equal(u.Metadata, other.Metadata)
+}
+
+// RedirectStatus returns the status code the link redirects with, false if
+// it has none or it isn't one ValidateRedirectStatus accepts.
+func (u URLPair) RedirectStatus() (int, bool) {
+	status, err := strconv.Atoi(u.Metadata[MetadataRedirectStatus])
+	if err != nil || ValidateRedirectStatus(status) != nil {
+		return 0, false
+	}
+	return status, true
+}
+
+// ValidateRedirectStatus accepts 301, 302, 307 and 308.
+func ValidateRedirectStatus(status int) error {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	}
+	return fmt.Errorf("invalid redirect status %d, expected %d, %d, %d or %d", status,
+		http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect)
+}
+
+// IsPermanentRedirect reports whether browsers may cache the redirect.
+func IsPermanentRedirect(status int) bool {
+	return status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+}
+
+// ForwardsQuery reports whether the link passes its query string on.
+func (u URLPair) ForwardsQuery() bool {
+	return u.Metadata[MetadataForwardQuery] == "true"
+}
+
+// ForwardsPath reports whether the link appends trailing path segments.
+func (u URLPair) ForwardsPath() bool {
+	return u.Metadata[MetadataForwardPath] == "true"
+}
+
+// PlatformDestination returns the destination of the link for clients on
+// platform, false if they get the base URL.
+func (u URLPair) PlatformDestination(platform string) (string, bool) {
+	destination, ok := u.Metadata[MetadataDestinationPrefix+platform]
+	return destination, ok && destination != ""
+}
+
+// PlatformDestinations returns the destinations of the link by platform.
+func (u URLPair) PlatformDestinations() map[string]string {
+	destinations := map[string]string{}
+	for key, destination := range u.Metadata {
+		if platform, ok := strings.CutPrefix(key, MetadataDestinationPrefix); ok && destination != "" {
+			destinations[platform] = destination
+		}
+	}
+	return destinations
+}
+
+// IsWebURL reports whether rawURL is an absolute http or https URL, the only
+// destinations a link may redirect to besides its base URL.
+func IsWebURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// ValidatePlatform accepts the platforms of Platforms.
+func ValidatePlatform(platform string) error {
+	if !slices.Contains(Platforms, platform) {
+		return fmt.Errorf("invalid platform '%v', expected one of %v", platform, strings.Join(Platforms, ", "))
+	}
+	return nil
 }
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// MetadataVariants holds the variants of a link as a JSON array.
+	MetadataVariants = "variants"
+	// MetadataStickyVariant is "true" for links that send a visitor back to
+	// the variant they got the first time.
+	MetadataStickyVariant = "stickyVariant"
+
+	maxVariants = 10
+	// maxVariantWeight keeps the total weight of the variants far from
+	// overflowing.
+	maxVariantWeight = 1_000_000
+)
+
+// variantName keeps names usable as cookie values and metric labels.
+var variantName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Variant is one of the destinations a link splits its clicks between. Each
+// click goes to a variant with a chance of its weight over the total weight,
+// a weight of 0 pauses the variant.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// Variants returns the variants of the link, nil when it has none or they
+// can't be read.
+func (u URLPair) Variants() []Variant {
+	value, ok := u.Metadata[MetadataVariants]
+	if !ok {
+		return nil
+	}
+	variants, err := ParseVariants(value)
+	if err != nil {
+		return nil
+	}
+	return variants
+}
+
+// ParseVariants reads and validates variants kept under MetadataVariants.
+func ParseVariants(value string) ([]Variant, error) {
+	var variants []Variant
+	if err := json.Unmarshal([]byte(value), &variants); err != nil {
+		return nil, fmt.Errorf("invalid variants, %v", err)
+	}
+	if err := ValidateVariants(variants); err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+// SticksToVariant reports whether a visitor keeps getting the same variant.
+func (u URLPair) SticksToVariant() bool {
+	return u.Metadata[MetadataStickyVariant] == "true"
+}
+
+// EncodeVariants returns variants as kept under MetadataVariants.
+func EncodeVariants(variants []Variant) (string, error) {
+	if err := ValidateVariants(variants); err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(variants)
+	if err != nil {
+		return "", fmt.Errorf("error when encoding variants, %v", err)
+	}
+	return string(value), nil
+}
+
+// ValidateVariants accepts up to 10 variants with unique names of letters,
+// digits, - and _, an absolute URL and a weight that isn't negative, as long
+// as one weight isn't 0.
+func ValidateVariants(variants []Variant) error {
+	if len(variants) == 0 || len(variants) > maxVariants {
+		return fmt.Errorf("invalid variants: expected 1 to %d, got %d", maxVariants, len(variants))
+	}
+	names := map[string]bool{}
+	total := 0
+	for _, variant := range variants {
+		if !variantName.MatchString(variant.Name) {
+			return fmt.Errorf("invalid variant name '%v': expected up to 32 letters, digits, - or _", variant.Name)
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("invalid variants: name '%v' is used twice", variant.Name)
+		}
+		names[variant.Name] = true
+		if !IsWebURL(variant.URL) {
+			return fmt.Errorf("invalid url '%v' for variant '%v': an absolute http or https URL is needed", variant.URL, variant.Name)
+		}
+		if variant.Weight < 0 || variant.Weight > maxVariantWeight {
+			return fmt.Errorf("invalid weight %d for variant '%v': must be between 0 and %d", variant.Weight, variant.Name, maxVariantWeight)
+		}
+		total += variant.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("invalid variants: every weight is 0")
+	}
+	return nil
+}
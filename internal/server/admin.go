@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/0xKev/url-shortener/internal/linkio"
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+)
+
+const (
+	AdminExportRoute = "/admin/export"
+	AdminImportRoute = "/admin/import"
+	// AdminDisableRoute and AdminRestoreRoute are followed by the suffix.
+	AdminDisableRoute = "/admin/disable/"
+	AdminRestoreRoute = "/admin/restore/"
+
+	NDJSONContentType = "application/x-ndjson"
+	CSVContentType    = "text/csv; charset=utf-8"
+)
+
+// SetAdminToken enables the admin routes for requests carrying the token as
+// a bearer token. Without one the admin routes answer 404.
+func (u *URLShortenerServer) SetAdminToken(token string) {
+	u.adminToken = token
+}
+
+// requireAdmin writes an error and returns false unless the request may use
+// the admin routes.
+func (u *URLShortenerServer) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if u.adminToken == "" {
+		u.writeError(w, r, store.ErrNotFound)
+		return false
+	}
+	want := "Bearer " + u.adminToken
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		u.writeError(w, r, fmt.Errorf("%w: missing or wrong admin token", ErrUnauthorized))
+		return false
+	}
+	return true
+}
+
+// exportHandler streams every link as JSON lines, or CSV with ?format=csv.
+func (u *URLShortenerServer) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if !u.requireAdmin(w, r) {
+		return
+	}
+	scanner, ok := u.store.(store.URLScanner)
+	if !ok {
+		u.writeError(w, r, fmt.Errorf("%w: exporting links", ErrNotImplemented))
+		return
+	}
+	format := formatParam(r)
+	if err := linkio.ValidateFormat(format); err != nil {
+		u.writeError(w, r, fmt.Errorf("%w: %v", ErrBadRequest, err))
+		return
+	}
+
+	contentType := NDJSONContentType
+	if format == linkio.FormatCSV {
+		contentType = CSVContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%v"`, format))
+	// the status is sent with the first link, a failure after that can only
+	// cut the export short
+	written, err := linkio.Export(r.Context(), scanner, u.domain, format, w)
+	if err != nil {
+		log.Printf("export stopped after %d links: %v", written, err)
+		if written == 0 {
+			w.Header().Del("Content-Disposition")
+			u.writeError(w, r, fmt.Errorf("unable to export links, %w", err))
+		}
+	}
+}
+
+// importHandler loads an export, or any format of linkio.ImportFormats, from
+// the request body. ?conflict picks what happens to suffixes already
+// pointing elsewhere and defaults to fail.
+func (u *URLShortenerServer) importHandler(w http.ResponseWriter, r *http.Request) {
+	if !u.requireAdmin(w, r) {
+		return
+	}
+	defer r.Body.Close()
+
+	conflict := r.URL.Query().Get("conflict")
+	if conflict == "" {
+		conflict = string(linkio.ConflictFail)
+	}
+	policy, err := linkio.ParseConflictPolicy(conflict)
+	if err != nil {
+		u.writeError(w, r, fmt.Errorf("%w: %v", ErrBadRequest, err))
+		return
+	}
+	records, err := linkio.ReadRecords(r.Body, formatParam(r))
+	if err != nil {
+		u.writeError(w, r, fmt.Errorf("%w: %v", ErrBadRequest, err))
+		return
+	}
+
+	// suffixes of disabled links stay taken
+	reserved := func(shortSuffix string) bool {
+		return IsReservedSuffix(shortSuffix) || u.isTombstoned(shortSuffix)
+	}
+	// the report is returned even when the import stopped, it tells which
+	// rows made it
+	result, err := linkio.Import(records, u.store, policy, reserved)
+	w.Header().Set("Content-Type", JsonContentType)
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, linkio.ErrConflict):
+		status = http.StatusConflict
+	case err != nil:
+		status = u.storeErrorStatus(w, err)
+		log.Printf("import stopped: %v", err)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// disableHandler soft deletes the link, ?reason says why: abuse,
+// owner-request or expired. It answers with the tombstone.
+func (u *URLShortenerServer) disableHandler(w http.ResponseWriter, r *http.Request) {
+	disabler, shortSuffix, ok := u.linkDisablerRequest(w, r)
+	if !ok {
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if err := model.ValidateDisableReason(reason); err != nil {
+		u.writeError(w, r, fmt.Errorf("%w: %v", ErrBadRequest, err))
+		return
+	}
+
+	tombstone, err := disabler.Disable(shortSuffix, reason)
+	if err != nil {
+		u.writeError(w, r, fmt.Errorf("unable to disable link %v, %w", shortSuffix, err))
+		return
+	}
+	log.Printf("Disabled %v: %v", shortSuffix, reason)
+	w.Header().Set("Content-Type", JsonContentType)
+	json.NewEncoder(w).Encode(tombstone)
+}
+
+// restoreHandler puts a disabled link back and answers with it.
+func (u *URLShortenerServer) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	disabler, shortSuffix, ok := u.linkDisablerRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := disabler.Restore(shortSuffix); err != nil {
+		u.writeError(w, r, fmt.Errorf("unable to restore link %v, %w", shortSuffix, err))
+		return
+	}
+	log.Printf("Restored %v", shortSuffix)
+	baseURL, _ := u.store.Load(shortSuffix)
+	w.Header().Set("Content-Type", JsonContentType)
+	json.NewEncoder(w).Encode(u.getURLPair(shortSuffix, baseURL))
+}
+
+// linkDisablerRequest checks what disableHandler and restoreHandler have in
+// common and returns the suffix of the route.
+func (u *URLShortenerServer) linkDisablerRequest(w http.ResponseWriter, r *http.Request) (store.LinkDisabler, string, bool) {
+	if !u.requireAdmin(w, r) {
+		return nil, "", false
+	}
+	disabler, ok := u.store.(store.LinkDisabler)
+	if !ok {
+		u.writeError(w, r, fmt.Errorf("%w: disabling links", ErrNotImplemented))
+		return nil, "", false
+	}
+	return disabler, r.PathValue("suffix"), true
+}
+
+func formatParam(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	return linkio.FormatJSONL
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/model"
+)
+
+// destination is where a request for the link leads: its base URL or its
+// destination for the platform of the client, with the path following the
+// suffix and the query string of the request when the link forwards them.
+func destination(urlPair model.URLPair, r *http.Request) (string, error) {
+	baseURL := platformBaseURL(urlPair, r)
+	query, path := forwardedQuery(r), r.PathValue("path")
+	forwardQuery := urlPair.ForwardsQuery() && query != ""
+	forwardPath := urlPair.ForwardsPath() && path != ""
+	if !forwardQuery && !forwardPath {
+		return baseURL, nil
+	}
+
+	target, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("error when parsing destination of %v, %v", urlPair.ShortSuffix, err)
+	}
+	if forwardPath {
+		segments := strings.Split(path, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		target = target.JoinPath(segments...)
+	}
+	if forwardQuery {
+		target.RawQuery = mergeQuery(target.RawQuery, query)
+	}
+	return target.String(), nil
+}
+
+// forwardedQuery is the query string of the request without the info
+// parameter, which is the server's own.
+func forwardedQuery(r *http.Request) string {
+	if !r.URL.Query().Has("info") {
+		return r.URL.RawQuery
+	}
+	var params []string
+	for _, param := range strings.Split(r.URL.RawQuery, "&") {
+		if name, _, _ := strings.Cut(param, "="); name != "info" {
+			params = append(params, param)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// mergeQuery appends the incoming parameters to those of the destination,
+// replacing destination parameters of the same name. Both keep their order
+// and encoding.
+func mergeQuery(destination, incoming string) string {
+	if destination == "" {
+		return incoming
+	}
+	replaced, err := url.ParseQuery(incoming)
+	if err != nil {
+		replaced = url.Values{}
+	}
+	var kept []string
+	for _, param := range strings.Split(destination, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(name); err == nil && replaced.Has(name) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(append(kept, incoming), "&")
+}
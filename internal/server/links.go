@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+)
+
+// APIv2LinksRoute is the collection of link resources, a link lives under
+// it at its ID.
+const APIv2LinksRoute = "/api/v2/links"
+
+// Link is a short link as the v2 API returns it. Unlike model.URLPair it
+// carries the complete short URL, so clients don't join the domain and the
+// suffix themselves.
+type Link struct {
+	ID        string     `json:"id"`
+	ShortURL  string     `json:"shortURL"`
+	BaseURL   string     `json:"baseURL"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// RedirectStatus is the status the short URL redirects with.
+	RedirectStatus int `json:"redirectStatus"`
+	// ForwardQuery and ForwardPath pass the query string and the path
+	// following the suffix on to the destination.
+	ForwardQuery bool `json:"forwardQuery"`
+	ForwardPath  bool `json:"forwardPath"`
+	// Destinations replace BaseURL for clients on these platforms, see
+	// model.Platforms.
+	Destinations map[string]string `json:"destinations,omitempty"`
+	// Clicks counts the redirects of the link, Variants split them between
+	// destinations by weight.
+	Clicks        uint64        `json:"clicks"`
+	Variants      []LinkVariant `json:"variants,omitempty"`
+	StickyVariant bool          `json:"stickyVariant"`
+	Links         LinkLinks     `json:"links"`
+}
+
+// LinkVariant is a variant of a link with the redirects it got.
+type LinkVariant struct {
+	model.Variant
+	Clicks uint64 `json:"clicks"`
+}
+
+// LinkLinks are the URLs related to a link.
+type LinkLinks struct {
+	Self string `json:"self"`
+}
+
+// createLinkRequest is the body of POST /api/v2/links. ExpiresAt is in
+// RFC 3339 and must be in the future, RedirectStatus defaults to the one of
+// the server. Variants without a name are named after their position, a,
+// b and so on, and BaseURL defaults to the URL of the first variant.
+type createLinkRequest struct {
+	BaseURL        string            `json:"baseURL"`
+	ExpiresAt      *time.Time        `json:"expiresAt"`
+	RedirectStatus int               `json:"redirectStatus"`
+	ForwardQuery   bool              `json:"forwardQuery"`
+	ForwardPath    bool              `json:"forwardPath"`
+	Destinations   map[string]string `json:"destinations"`
+	Variants       []model.Variant   `json:"variants"`
+	StickyVariant  bool              `json:"stickyVariant"`
+}
+
+func (u *URLShortenerServer) newLink(urlPair model.URLPair) Link {
+	link := Link{
+		ID:             urlPair.ShortSuffix,
+		ShortURL:       u.domain + urlPair.ShortSuffix,
+		BaseURL:        urlPair.BaseURL,
+		RedirectStatus: u.redirectStatusOf(urlPair),
+		ForwardQuery:   urlPair.ForwardsQuery(),
+		ForwardPath:    urlPair.ForwardsPath(),
+		Destinations:   urlPair.PlatformDestinations(),
+		Clicks:         u.clicks(urlPair),
+		StickyVariant:  urlPair.SticksToVariant(),
+		Links:          LinkLinks{Self: u.linkURL(urlPair.ShortSuffix)},
+	}
+	if variants := urlPair.Variants(); len(variants) > 0 {
+		clicks := u.variantClicks(urlPair.ShortSuffix)
+		for _, variant := range variants {
+			link.Variants = append(link.Variants, LinkVariant{Variant: variant, Clicks: clicks[variant.Name]})
+		}
+	}
+	if createdAt, err := time.Parse(time.RFC3339Nano, urlPair.Metadata[model.MetadataCreatedAt]); err == nil {
+		link.CreatedAt = &createdAt
+	}
+	if expiresAt, ok := urlPair.ExpiresAt(); ok {
+		link.ExpiresAt = &expiresAt
+	}
+	return link
+}
+
+// linkURL is the absolute URL of the link resource.
+func (u *URLShortenerServer) linkURL(shortSuffix string) string {
+	return u.domain + APIv2LinksRoute[1:] + "/" + shortSuffix
+}
+
+// createLinkHandler shortens the base URL of the body and answers 201 with
+// the link resource.
+func (u *URLShortenerServer) createLinkHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var request createLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		u.writeError(w, r, fmt.Errorf("%w: error decoding json, %v", ErrBadRequest, err))
+		return
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		u.writeError(w, r, fmt.Errorf("%w: expiresAt %v is not in the future", ErrBadRequest, request.ExpiresAt.Format(time.RFC3339)))
+		return
+	}
+	if request.RedirectStatus != 0 {
+		if err := model.ValidateRedirectStatus(request.RedirectStatus); err != nil {
+			u.writeError(w, r, fmt.Errorf("%w: %v", ErrBadRequest, err))
+			return
+		}
+	}
+	for platform, destination := range request.Destinations {
+		if err := validatePlatformDestination(platform, destination); err != nil {
+			u.writeError(w, r, err)
+			return
+		}
+	}
+	var variants string
+	if len(request.Variants) > 0 {
+		for i := range request.Variants {
+			if request.Variants[i].Name == "" {
+				request.Variants[i].Name = string(rune('a' + i))
+			}
+		}
+		var err error
+		if variants, err = model.EncodeVariants(request.Variants); err != nil {
+			u.writeError(w, r, fmt.Errorf("%w: %v", ErrBadRequest, err))
+			return
+		}
+		if request.BaseURL == "" {
+			request.BaseURL = request.Variants[0].URL
+		}
+	} else if request.StickyVariant {
+		u.writeError(w, r, fmt.Errorf("%w: stickyVariant needs variants", ErrBadRequest))
+		return
+	}
+
+	shortSuffix, err := u.shortenURL(request.BaseURL)
+	if err != nil {
+		u.writeError(w, r, err)
+		return
+	}
+	urlPair := model.URLPair{ShortSuffix: shortSuffix, BaseURL: request.BaseURL, Domain: u.domain, Metadata: map[string]string{}}
+	if request.ExpiresAt != nil {
+		urlPair.Metadata[model.MetadataExpiresAt] = request.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if request.RedirectStatus != 0 {
+		urlPair.Metadata[model.MetadataRedirectStatus] = strconv.Itoa(request.RedirectStatus)
+	}
+	if request.ForwardQuery {
+		urlPair.Metadata[model.MetadataForwardQuery] = "true"
+	}
+	if request.ForwardPath {
+		urlPair.Metadata[model.MetadataForwardPath] = "true"
+	}
+	for platform, destination := range request.Destinations {
+		urlPair.Metadata[model.MetadataDestinationPrefix+platform] = destination
+	}
+	if variants != "" {
+		urlPair.Metadata[model.MetadataVariants] = variants
+	}
+	if request.StickyVariant {
+		urlPair.Metadata[model.MetadataStickyVariant] = "true"
+	}
+	if err := u.saveLink(urlPair); err != nil {
+		u.writeError(w, r, fmt.Errorf("unable to save short link, %w", err))
+		return
+	}
+
+	// read back for the creation date the link was saved with
+	if saved, err := u.loadURLPair(shortSuffix); err == nil {
+		urlPair = saved
+	}
+	w.Header().Set("Location", u.linkURL(shortSuffix))
+	u.writeLink(w, http.StatusCreated, urlPair)
+}
+
+// linkHandler answers with the link resource, 410 for disabled links.
+func (u *URLShortenerServer) linkHandler(w http.ResponseWriter, r *http.Request) {
+	shortSuffix := r.PathValue("id")
+	if IsReservedSuffix(shortSuffix) {
+		u.writeMissingLink(w, r, JsonContentType, shortSuffix)
+		return
+	}
+	urlPair, err := u.loadURLPair(shortSuffix)
+	if err != nil {
+		u.writeLoadError(w, r, JsonContentType, shortSuffix, err)
+		return
+	}
+	u.writeLink(w, http.StatusOK, urlPair)
+}
+
+func (u *URLShortenerServer) writeLink(w http.ResponseWriter, status int, urlPair model.URLPair) {
+	w.Header().Set("Content-Type", JsonContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(u.newLink(urlPair)); err != nil {
+		log.Printf("unable to write link %v: %v", urlPair.ShortSuffix, err)
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/model"
+)
+
+// Media types responses are negotiated between.
+const (
+	HTMLMediaType = "text/html"
+	TextMediaType = "text/plain"
+	XMLMediaType  = "application/xml"
+
+	TextContentType       = "text/plain; charset=utf-8"
+	XMLContentType        = "application/xml; charset=utf-8"
+	ProblemXMLContentType = "application/problem+xml"
+)
+
+var (
+	// apiOffers are what the /api routes answer with, by preference.
+	apiOffers = []string{JsonContentType, XMLMediaType, TextMediaType}
+	// webOffers are what the other routes answer with, by preference. HTML
+	// is a redirect for short links and a partial for shorten.
+	webOffers = []string{HTMLMediaType, JsonContentType, XMLMediaType, TextMediaType}
+	// rootOffers are for shortening by posting to the root, which is meant
+	// for scripts: curl -d url=https://example.com https://sho.rt/
+	rootOffers = []string{TextMediaType, JsonContentType, XMLMediaType, HTMLMediaType}
+)
+
+// negotiate picks the media type of the response and writes 406 when the
+// request accepts none on offer. HTMX requests always get HTML partials, and
+// API clients sending JSON without asking for anything in particular keep
+// getting JSON back as they did before negotiation.
+func (u *URLShortenerServer) negotiate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	w.Header().Add("Vary", "Accept")
+	if u.isHTMXRequest(r) {
+		return HTMLMediaType, true
+	}
+	offers := webOffers
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		offers = apiOffers
+	} else if r.URL.Path == "/" {
+		offers = rootOffers
+	} else if strings.HasSuffix(r.URL.Path, PreviewSuffix) {
+		offers = previewOffers
+	}
+	accept := r.Header.Get("Accept")
+	if (accept == "" || accept == "*/*") && u.isAPIRequest(r) {
+		return JsonContentType, true
+	}
+	if mediaType := preferredMediaType(accept, offers); mediaType != "" {
+		return mediaType, true
+	}
+	http.Error(w, "Not acceptable, available: "+strings.Join(offers, ", "), http.StatusNotAcceptable)
+	return "", false
+}
+
+// preferredMediaType returns the offer accept gives the highest quality, the
+// earliest offer on a tie and "" when every offer is refused. An empty accept
+// takes anything.
+func preferredMediaType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	ranges := parseAccept(accept)
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		if quality := acceptQuality(ranges, offer); quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best
+}
+
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		if mediaType == "text/xml" {
+			mediaType = XMLMediaType
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, quality})
+	}
+	// the most specific range matching an offer sets its quality
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+	return ranges
+}
+
+func specificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	}
+	return 2
+}
+
+func acceptQuality(ranges []mediaRange, offer string) float64 {
+	offerType, _, _ := strings.Cut(offer, "/")
+	for _, r := range ranges {
+		if r.mediaType == offer || r.mediaType == offerType+"/*" || r.mediaType == "*/*" {
+			return r.quality
+		}
+	}
+	return 0
+}
+
+// xmlURLPair is the XML representation of a link, the metadata map of
+// model.URLPair has no XML encoding.
+type xmlURLPair struct {
+	XMLName     xml.Name      `xml:"link"`
+	ShortSuffix string        `xml:"shortSuffix"`
+	BaseURL     string        `xml:"baseURL"`
+	Domain      string        `xml:"domain"`
+	Metadata    []xmlMetadata `xml:"metadata>entry,omitempty"`
+}
+
+type xmlMetadata struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func newXMLURLPair(urlPair model.URLPair) xmlURLPair {
+	link := xmlURLPair{ShortSuffix: urlPair.ShortSuffix, BaseURL: urlPair.BaseURL, Domain: urlPair.Domain}
+	for key, value := range urlPair.Metadata {
+		link.Metadata = append(link.Metadata, xmlMetadata{key, value})
+	}
+	sort.Slice(link.Metadata, func(i, j int) bool { return link.Metadata[i].Key < link.Metadata[j].Key })
+	return link
+}
+
+// writeURLPair answers with the link as JSON or XML, or as text for
+// text/plain.
+func (u *URLShortenerServer) writeURLPair(w http.ResponseWriter, mediaType string, urlPair model.URLPair, text string) {
+	var err error
+	switch mediaType {
+	case TextMediaType:
+		w.Header().Set("Content-Type", TextContentType)
+		_, err = fmt.Fprintln(w, text)
+	case XMLMediaType:
+		w.Header().Set("Content-Type", XMLContentType)
+		err = xml.NewEncoder(w).Encode(newXMLURLPair(urlPair))
+	default:
+		w.Header().Set("Content-Type", JsonContentType)
+		err = json.NewEncoder(w).Encode(urlPair)
+	}
+	if err != nil {
+		log.Printf("unable to write link %v: %v", urlPair.ShortSuffix, err)
+	}
+}
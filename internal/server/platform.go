@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/shortener"
+)
+
+// botMarkers are found in the User-Agent of crawlers and of the link
+// unfurlers of chat apps and social networks.
+var botMarkers = []string{"bot", "crawler", "spider", "slurp", "facebookexternalhit", "embedly"}
+
+// clientPlatform tells the platform of the client from its User-Agent, ""
+// when it can't, as for mobiles that are neither iOS nor Android.
+func clientPlatform(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	switch {
+	case userAgent == "":
+		return ""
+	case containsAny(userAgent, botMarkers...):
+		return model.PlatformBot
+	case containsAny(userAgent, "iphone", "ipad", "ipod"):
+		return model.PlatformIOS
+	case strings.Contains(userAgent, "android"):
+		return model.PlatformAndroid
+	case strings.Contains(userAgent, "mobile"):
+		return ""
+	}
+	return model.PlatformDesktop
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// platformBaseURL returns the destination of the link for the platform of
+// the client, the base URL when the link has none for it.
+func platformBaseURL(urlPair model.URLPair, r *http.Request) string {
+	if destination, ok := urlPair.PlatformDestination(clientPlatform(r.UserAgent())); ok {
+		return destination
+	}
+	return urlPair.BaseURL
+}
+
+// validatePlatformDestination checks the destination of a link for one
+// platform, an http or https URL like the app store pages of app links.
+func validatePlatformDestination(platform, destination string) error {
+	if err := model.ValidatePlatform(platform); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+	if !model.IsWebURL(destination) {
+		return shortener.InvalidURLError{ErrorMsg: fmt.Sprintf("for %v, an absolute http or https URL is needed", platform), SubmittedURL: destination}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// PreviewSuffix follows a short suffix to preview the link rather than
+// follow it, as in /abc1234+.
+const PreviewSuffix = "+"
+
+// qrCodeSize is the width and height of preview QR codes in pixels.
+const qrCodeSize = 256
+
+// previewOffers are what previews answer with, by preference.
+var previewOffers = []string{HTMLMediaType, JsonContentType, TextMediaType}
+
+// previewHandler shows where a short link leads, with when it was created,
+// how often it was followed and a QR code, as a page or as JSON. Text is
+// the destination alone.
+func (u *URLShortenerServer) previewHandler(w http.ResponseWriter, r *http.Request, shortSuffix string) {
+	mediaType, ok := u.negotiate(w, r)
+	if !ok {
+		return
+	}
+	urlPair, err := u.loadURLPair(shortSuffix)
+	if err != nil {
+		u.writeLoadError(w, r, mediaType, shortSuffix, err)
+		return
+	}
+
+	preview := u.newLinkPreview(urlPair)
+	switch mediaType {
+	case HTMLMediaType:
+		w.Header().Set("Content-Type", HtmxResponseContentType)
+		err = u.renderer.RenderLinkPreview(w, preview)
+	case TextMediaType:
+		w.Header().Set("Content-Type", TextContentType)
+		_, err = fmt.Fprintln(w, preview.BaseURL)
+	default:
+		w.Header().Set("Content-Type", JsonContentType)
+		err = json.NewEncoder(w).Encode(preview)
+	}
+	if err != nil {
+		log.Printf("unable to write preview of %v: %v", shortSuffix, err)
+	}
+}
+
+func (u *URLShortenerServer) newLinkPreview(urlPair model.URLPair) model.LinkPreview {
+	preview := model.LinkPreview{
+		ShortURL: u.domain + urlPair.ShortSuffix,
+		BaseURL:  urlPair.BaseURL,
+		Domain:   destinationHost(urlPair.BaseURL),
+		Clicks:   u.clicks(urlPair),
+	}
+	if createdAt, err := time.Parse(time.RFC3339Nano, urlPair.Metadata[model.MetadataCreatedAt]); err == nil {
+		preview.CreatedAt = &createdAt
+	}
+	qrCode, err := qrcode.Encode(preview.ShortURL, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		log.Printf("unable to encode QR code of %v: %v", urlPair.ShortSuffix, err)
+	}
+	preview.QRCode = qrCode
+	return preview
+}
+
+// destinationHost returns the host of a base URL, which may have been saved
+// without a scheme.
+func destinationHost(baseURL string) string {
+	destination, err := url.Parse(baseURL)
+	if err == nil && destination.Host == "" {
+		destination, err = url.Parse("http://" + baseURL)
+	}
+	if err != nil {
+		return ""
+	}
+	return destination.Hostname()
+}
+
+// countClick counts a redirect to the variant, if any, when the store counts
+// clicks. A click that couldn't be counted doesn't hold up the redirect.
+func (u *URLShortenerServer) countClick(r *http.Request, shortSuffix, variant string) {
+	counter, ok := u.store.(store.ClickCounter)
+	if !ok || r.Method == http.MethodHead {
+		return
+	}
+	if err := counter.CountClick(shortSuffix, variant); err != nil {
+		log.Printf("unable to count click on %v: %v", shortSuffix, err)
+	}
+}
+
+// clicks returns the clicks counted on a link, plus any it was imported
+// with.
+func (u *URLShortenerServer) clicks(urlPair model.URLPair) uint64 {
+	clicks, _ := strconv.ParseUint(urlPair.Metadata[model.MetadataClicks], 10, 64)
+	counter, ok := u.store.(store.ClickCounter)
+	if !ok {
+		return clicks
+	}
+	counted, err := counter.Clicks(urlPair.ShortSuffix)
+	if err != nil {
+		log.Printf("unable to load clicks of %v: %v", urlPair.ShortSuffix, err)
+	}
+	return clicks + counted
+}
+
+// variantClicks returns the clicks counted on each variant of a link.
+func (u *URLShortenerServer) variantClicks(shortSuffix string) map[string]uint64 {
+	counter, ok := u.store.(store.ClickCounter)
+	if !ok {
+		return nil
+	}
+	clicks, err := counter.VariantClicks(shortSuffix)
+	if err != nil {
+		log.Printf("unable to load variant clicks of %v: %v", shortSuffix, err)
+	}
+	return clicks
+}
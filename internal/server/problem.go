@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/0xKev/url-shortener/internal/linkio"
+	"github.com/0xKev/url-shortener/internal/shortener"
+	"github.com/0xKev/url-shortener/internal/store"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Codes of the problems the API answers with, clients should rely on them
+// rather than on titles or details.
+const (
+	ProblemBadRequest        = "bad-request"
+	ProblemUnauthorized      = "unauthorized"
+	ProblemNotFound          = "not-found"
+	ProblemConflict          = "conflict"
+	ProblemLinkDisabled      = "link-disabled"
+	ProblemInvalidURL        = "invalid-url"
+	ProblemNotImplemented    = "not-implemented"
+	ProblemUnavailable       = "unavailable"
+	ProblemSuffixesExhausted = "suffixes-exhausted"
+	ProblemInternal          = "internal"
+)
+
+var (
+	// ErrBadRequest is wrapped by errors in what the client sent, such as a
+	// body that isn't JSON or an unknown query parameter value.
+	ErrBadRequest = errors.New("bad request")
+	// ErrLinkDisabled is returned for the suffix of a disabled link.
+	ErrLinkDisabled   = errors.New("link disabled")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrNotImplemented = errors.New("not supported by the store")
+)
+
+// problemTypes maps errors to the status and code they are reported with,
+// the first match wins. Any other error is a 500.
+var problemTypes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrBadRequest, http.StatusBadRequest, ProblemBadRequest},
+	{ErrUnauthorized, http.StatusUnauthorized, ProblemUnauthorized},
+	{shortener.ErrInvalidURL, http.StatusUnprocessableEntity, ProblemInvalidURL},
+	{ErrLinkDisabled, http.StatusGone, ProblemLinkDisabled},
+	{store.ErrNotFound, http.StatusNotFound, ProblemNotFound},
+	{store.ErrExists, http.StatusConflict, ProblemConflict},
+	{linkio.ErrConflict, http.StatusConflict, ProblemConflict},
+	{ErrNotImplemented, http.StatusNotImplemented, ProblemNotImplemented},
+	{store.ErrUnavailable, http.StatusServiceUnavailable, ProblemUnavailable},
+	{shortener.ErrSuffixesExhausted, http.StatusServiceUnavailable, ProblemSuffixesExhausted},
+}
+
+// Problem is an RFC 9457 problem details document. Type is left to
+// about:blank, Code tells problems apart.
+type Problem struct {
+	XMLName  xml.Name `json:"-" xml:"urn:ietf:rfc:7807 problem"`
+	Type     string   `json:"type" xml:"type"`
+	Title    string   `json:"title" xml:"title"`
+	Status   int      `json:"status" xml:"status"`
+	Detail   string   `json:"detail,omitempty" xml:"detail,omitempty"`
+	Instance string   `json:"instance,omitempty" xml:"instance,omitempty"`
+	Code     string   `json:"code" xml:"code"`
+	// Reason is why a link was disabled.
+	Reason string `json:"reason,omitempty" xml:"reason,omitempty"`
+}
+
+// NewProblem describes err. The details of errors that aren't the client's
+// are logged rather than returned.
+func NewProblem(err error) Problem {
+	problem := Problem{Type: "about:blank", Status: http.StatusInternalServerError, Code: ProblemInternal}
+	for _, problemType := range problemTypes {
+		if errors.Is(err, problemType.err) {
+			problem.Status, problem.Code = problemType.status, problemType.code
+			break
+		}
+	}
+	problem.Title = http.StatusText(problem.Status)
+	if problem.Status == http.StatusInternalServerError {
+		log.Printf("internal error: %v", err)
+	} else {
+		problem.Detail = err.Error()
+	}
+	return problem
+}
+
+// writeError answers with the problem describing err.
+func (u *URLShortenerServer) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	u.writeProblem(w, r, NewProblem(err))
+}
+
+func (u *URLShortenerServer) writeProblem(w http.ResponseWriter, r *http.Request, problem Problem) {
+	u.writeProblemAs(w, r, JsonContentType, problem)
+}
+
+// writeProblemAs answers with the problem in the negotiated media type,
+// problem+xml for XML and only the detail for text and HTML.
+func (u *URLShortenerServer) writeProblemAs(w http.ResponseWriter, r *http.Request, mediaType string, problem Problem) {
+	problem.Instance = r.URL.Path
+	if problem.Status == http.StatusServiceUnavailable && problem.Code == ProblemUnavailable {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+	}
+	switch mediaType {
+	case TextMediaType, HTMLMediaType:
+		message := problem.Detail
+		if message == "" {
+			message = problem.Title
+		}
+		http.Error(w, message, problem.Status)
+	case XMLMediaType:
+		w.Header().Set("Content-Type", ProblemXMLContentType)
+		w.WriteHeader(problem.Status)
+		xml.NewEncoder(w).Encode(problem)
+	default:
+		w.Header().Set("Content-Type", ProblemContentType)
+		w.WriteHeader(problem.Status)
+		json.NewEncoder(w).Encode(problem)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/urlrenderer"
+)
+
+// route is a method and path pattern as understood by http.ServeMux.
+type route struct {
+	method  string
+	path    string
+	handler func(*URLShortenerServer, http.ResponseWriter, *http.Request)
+}
+
+// routes are every route of the server but short links, which are served
+// under GET /{suffix} when no route matches.
+var routes = []route{
+	{http.MethodGet, "/{$}", (*URLShortenerServer).indexHandler},
+	{http.MethodPost, "/{$}", (*URLShortenerServer).shortenHandler},
+	{http.MethodPost, HtmxShortenRoute, (*URLShortenerServer).shortenHandler},
+	{http.MethodPost, APIShortenRoute, (*URLShortenerServer).shortenHandler},
+	{http.MethodGet, APIExpandRoute + "{suffix}", (*URLShortenerServer).expandHandler},
+	{http.MethodPost, APIv2LinksRoute, (*URLShortenerServer).createLinkHandler},
+	{http.MethodGet, APIv2LinksRoute + "/{id}", (*URLShortenerServer).linkHandler},
+	{http.MethodGet, AdminExportRoute, (*URLShortenerServer).exportHandler},
+	{http.MethodPost, AdminImportRoute, (*URLShortenerServer).importHandler},
+	{http.MethodPost, AdminDisableRoute + "{suffix}", (*URLShortenerServer).disableHandler},
+	{http.MethodPost, AdminRestoreRoute + "{suffix}", (*URLShortenerServer).restoreHandler},
+	{http.MethodGet, StaticRoute, (*URLShortenerServer).staticHandler},
+}
+
+// reservedPaths are the first path segments of the routes, a short link
+// named after one would be shadowed by the route.
+var reservedPaths = map[string]bool{}
+
+// routePatterns match request paths against the path of each route, the
+// handlers are never called.
+var routePatterns = map[string]*http.ServeMux{}
+
+// the handlers consult reservedPaths and routePatterns, so they can't be
+// built by their initializers
+func init() {
+	for _, route := range routes {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(route.path, "/"), "/")
+		if segment != "" && !strings.HasPrefix(segment, "{") {
+			reservedPaths[segment] = true
+		}
+		if _, ok := routePatterns[route.path]; !ok {
+			routePatterns[route.path] = http.NewServeMux()
+			routePatterns[route.path].HandleFunc(route.path, http.NotFound)
+		}
+	}
+}
+
+// ReservedPaths returns the path segments short links can't use, sorted.
+func ReservedPaths() []string {
+	paths := make([]string, 0, len(reservedPaths))
+	for path := range reservedPaths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// IsReservedSuffix reports whether a short link with this suffix would be
+// unreachable, because it is a reserved path, isn't a single path segment or
+// ends in PreviewSuffix and would be taken for a preview.
+func IsReservedSuffix(shortSuffix string) bool {
+	return shortSuffix == "" || strings.Contains(shortSuffix, "/") || strings.HasSuffix(shortSuffix, PreviewSuffix) || reservedPaths[shortSuffix]
+}
+
+func (u *URLShortenerServer) newRouter() *http.ServeMux {
+	router := http.NewServeMux()
+	for _, route := range routes {
+		handler := route.handler
+		router.HandleFunc(route.method+" "+route.path, func(w http.ResponseWriter, r *http.Request) {
+			handler(u, w, r)
+		})
+	}
+	router.HandleFunc("GET /{suffix}", u.suffixHandler)
+	// links forwarding the path following their suffix
+	router.HandleFunc("GET /{suffix}/{path...}", u.suffixHandler)
+	// other methods, which would otherwise answer 405 for any path
+	router.HandleFunc("/{suffix}", u.suffixMethodHandler)
+	router.HandleFunc("/{suffix}/{path...}", u.suffixMethodHandler)
+	return router
+}
+
+// suffixHandler expands short links, or previews them when followed by
+// PreviewSuffix. The reserved paths match it for the methods their routes
+// don't take, which answer 405 as on any other route.
+func (u *URLShortenerServer) suffixHandler(w http.ResponseWriter, r *http.Request) {
+	shortSuffix := r.PathValue("suffix")
+	if previewed, ok := strings.CutSuffix(shortSuffix, PreviewSuffix); ok && !IsReservedSuffix(previewed) && r.PathValue("path") == "" {
+		u.previewHandler(w, r, previewed)
+		return
+	}
+	if !IsReservedSuffix(shortSuffix) {
+		u.expandHandler(w, r)
+		return
+	}
+	allowed := allowedMethods(r)
+	if len(allowed) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// suffixMethodHandler answers requests with a method short links don't
+// take: 405 for a short link, 404 for what might be under one.
+func (u *URLShortenerServer) suffixMethodHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case IsReservedSuffix(r.PathValue("suffix")):
+		u.suffixHandler(w, r)
+	case strings.Count(r.URL.Path, "/") > 1:
+		http.NotFound(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// allowedMethods returns the methods of the routes matching the path of the
+// request.
+func allowedMethods(r *http.Request) []string {
+	var allowed []string
+	for _, route := range routes {
+		if _, pattern := routePatterns[route.path].Handler(r); pattern == "" {
+			continue
+		}
+		if !slices.Contains(allowed, route.method) {
+			allowed = append(allowed, route.method)
+			if route.method == http.MethodGet {
+				allowed = append(allowed, http.MethodHead)
+			}
+		}
+	}
+	return allowed
+}
+
+var staticFiles = http.FileServer(http.FS(urlrenderer.GetStaticFS()))
+
+func (u *URLShortenerServer) staticHandler(w http.ResponseWriter, r *http.Request) {
+	staticFiles.ServeHTTP(w, r)
+}
@@ -5,12 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"github.com/0xKev/url-shortener/internal/urlrenderer"
+	"log"
+	"maps"
+	"mime"
 	"net/http"
-	"strings"
-
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/shortener"
+	"github.com/0xKev/url-shortener/internal/store"
 )
 
 const (
@@ -26,8 +32,23 @@ const (
 
 	HtmxExpandRoute  = "/"
 	HtmxShortenRoute = ShortenRoute
+	StaticRoute      = "/static/"
+
+	DefaultDomain = "http://localhost:5000/"
+	// DefaultRedirectStatus is what links redirect with unless they or
+	// SetRedirectStatus say otherwise.
+	DefaultRedirectStatus = http.StatusPermanentRedirect
+
+	// permanentRedirectMaxAge bounds how long browsers keep a 301 or 308, so
+	// fixing a destination eventually reaches those who already clicked.
+	permanentRedirectMaxAge = 24 * time.Hour
 
-	DefaultDomain = "localhost:5000/"
+	ErrMsgSaveUnavailable = "Shortening is temporarily unavailable, existing short links still work. Please try again shortly."
+	retryAfterSeconds     = "10"
+
+	// maxSuffixAttempts bounds how many tombstoned suffixes are skipped
+	// before shortening gives up.
+	maxSuffixAttempts = 100
 )
 
 type URLShortener interface {
@@ -35,36 +56,32 @@ type URLShortener interface {
 }
 
 type URLShortenerServer struct {
-	store     URLStore
-	shortener URLShortener
-	renderer  *urlrenderer.URLPairRenderer
-	domain    string
+	store        store.URLStore
+	shortener    URLShortener
+	renderer     *urlrenderer.URLPairRenderer
+	domain       string
+	suffixFilter SuffixFilter
+	adminToken   string
+	// redirectStatus is the status of links without one of their own
+	redirectStatus int
 	http.Handler
 }
 
-func NewURLShortenerServer(store URLStore, shortener URLShortener) *URLShortenerServer {
-	server := &URLShortenerServer{
-		store:     store,
-		shortener: shortener,
-		domain:    DefaultDomain,
+func NewURLShortenerServer(urlStore store.URLStore, shortener URLShortener) *URLShortenerServer {
+	renderer, err := urlrenderer.NewURLPairRenderer()
+	if err != nil {
+		panic(err) // templates are embedded, this only fails on a broken build
 	}
 
-	router := http.NewServeMux()
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" {
-			server.indexHandler(w, r)
-		} else if !strings.Contains(r.URL.Path, "api") && !strings.Contains(r.URL.Path, ShortenRoute) && !strings.Contains(r.URL.Path, "static") {
-			server.expandHandler(w, r)
-		}
-	})
-	router.Handle(APIShortenRoute, http.HandlerFunc(server.shortenHandler))
-	router.Handle(APIExpandRoute, http.HandlerFunc(server.expandHandler))
-
-	router.Handle(HtmxShortenRoute, http.HandlerFunc(server.shortenHandler))
-	router.Handle("/static/", http.FileServer(http.FS(urlrenderer.GetStaticFS())))
-	// log.Printf("Routes registered: /, %s, %s", ShortenRoute, ExpandRoute)
+	server := &URLShortenerServer{
+		store:          urlStore,
+		shortener:      shortener,
+		renderer:       renderer,
+		domain:         DefaultDomain,
+		redirectStatus: DefaultRedirectStatus,
+	}
 
-	server.Handler = router
+	server.Handler = server.newRouter()
 
 	return server
 }
@@ -105,160 +122,396 @@ func (u *URLShortenerServer) GetDomain() string {
 	return u.domain
 }
 
-func (u *URLShortenerServer) indexHandler(w http.ResponseWriter, r *http.Request) {
-	if strings.TrimPrefix(r.URL.Path, "/") != "" {
-		w.WriteHeader(http.StatusNotFound)
-		return
+// SetRedirectStatus sets the status links redirect with unless they were
+// created with one: 301, 302, 307 or 308.
+func (u *URLShortenerServer) SetRedirectStatus(status int) error {
+	if err := model.ValidateRedirectStatus(status); err != nil {
+		return err
 	}
-	w.WriteHeader(http.StatusOK)
-	err := u.renderer.RenderIndex(w)
+	u.redirectStatus = status
+	return nil
+}
 
-	if err != nil {
-		panic(err)
+// redirectStatusOf returns the status the link redirects with.
+func (u *URLShortenerServer) redirectStatusOf(urlPair model.URLPair) int {
+	if status, ok := urlPair.RedirectStatus(); ok {
+		return status
 	}
+	return u.redirectStatus
 }
 
-func (u *URLShortenerServer) shortenHandler(w http.ResponseWriter, r *http.Request) {
-	if u.isAPIRequest(r) {
-		u.processAPIShortURL(w, r)
-	} else if u.isHTMXRequest(r) {
-		u.processHTMXShortURL(w, r)
-	} else {
-		u.processHTMXShortURL(w, r)
+// redirectCacheControl lets browsers keep permanent redirects for a while
+// and keeps temporary ones from being cached at all.
+func redirectCacheControl(status int) string {
+	if model.IsPermanentRedirect(status) {
+		return fmt.Sprintf("public, max-age=%d", int(permanentRedirectMaxAge.Seconds()))
 	}
+	return "no-store"
 }
 
-func (u *URLShortenerServer) expandHandler(w http.ResponseWriter, r *http.Request) {
-	if u.isAPIRequest(r) {
-		w.Header().Set("Content-Type", JsonContentType)
-		u.showAPIExpandedURL(w, r)
-	} else if u.isHTMXRequest(r) {
-		w.Header().Set("Content-Type", HtmxResponseContentType)
-		u.showHTMXExpandedURL(w, r)
-	} else { // error is that the get requests are normal web requests and not htmx
-		u.showHTMXExpandedURL(w, r)
+// SetSuffixFilter lets expand requests for suffixes the filter rules out
+// return 404 without a store lookup.
+func (u *URLShortenerServer) SetSuffixFilter(filter SuffixFilter) {
+	u.suffixFilter = filter
+}
+
+func (u *URLShortenerServer) loadBaseURL(shortSuffix string) (string, bool) {
+	if u.suffixFilter != nil && !u.suffixFilter.MightContain(shortSuffix) {
+		return "", false
 	}
+	return u.store.Load(shortSuffix)
+}
 
+// loadURLPair is loadBaseURL with the metadata of the link, and its domain.
+// It fails with store.ErrNotFound for a missing link, and with any other
+// error when the store couldn't tell.
+func (u *URLShortenerServer) loadURLPair(shortSuffix string) (model.URLPair, error) {
+	if u.suffixFilter != nil && !u.suffixFilter.MightContain(shortSuffix) {
+		return model.URLPair{}, store.ErrNotFound
+	}
+	urlPair, err := store.LookupURLPair(u.store, shortSuffix)
+	urlPair.ShortSuffix, urlPair.Domain = shortSuffix, u.domain
+	return urlPair, err
 }
 
-func (u *URLShortenerServer) isHTMXRequest(r *http.Request) bool {
-	return r.Header.Get("HX-Request") == "true"
+// tombstone returns the tombstone of a disabled link, if the store keeps them.
+func (u *URLShortenerServer) tombstone(shortSuffix string) (model.Tombstone, bool) {
+	disabler, ok := u.store.(store.LinkDisabler)
+	if !ok {
+		return model.Tombstone{}, false
+	}
+	return disabler.Tombstone(shortSuffix)
 }
 
-func (u *URLShortenerServer) isAPIRequest(r *http.Request) bool {
-	return r.Header.Get("Content-Type") == JsonContentType
+func (u *URLShortenerServer) isTombstoned(shortSuffix string) bool {
+	_, found := u.tombstone(shortSuffix)
+	return found
 }
 
-func (u *URLShortenerServer) showHTMXExpandedURL(w http.ResponseWriter, r *http.Request) {
-	shortSuffix := strings.TrimPrefix(r.URL.Path, "/")
-	baseURL, found := u.store.Load(shortSuffix)
-	if !found {
-		http.Error(w, "Page not found.", http.StatusNotFound)
-		return
-	}
-	if u.isHTMXRequest(r) {
-		w.Header().Set("HX-Redirect", baseURL)
-	} else { // normal redirect for normal web requests
-		http.Redirect(w, r, baseURL, http.StatusPermanentRedirect)
+// SuffixTaken reports whether a new link can't use shortSuffix: it is taken
+// by a link, a disabled link or a route.
+func (u *URLShortenerServer) SuffixTaken(shortSuffix string) bool {
+	if IsReservedSuffix(shortSuffix) || u.isTombstoned(shortSuffix) {
+		return true
 	}
+	_, found := u.loadBaseURL(shortSuffix)
+	return found
 }
 
-func (u *URLShortenerServer) showAPIExpandedURL(w http.ResponseWriter, r *http.Request) {
-	shortSuffix := strings.TrimPrefix(r.URL.Path, APIExpandRoute)
-
-	expandedURL, found := u.store.Load(shortSuffix)
-	w.Header().Set("Content-Type", JsonContentType)
-	if !found {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{"error": "URL not found"})
-		return
+// shortenURL asks the shortener for a suffix, skipping reserved paths and
+// the suffixes of links and disabled links, e.g. after the counter restarted
+// from its seed. The suffix is then claimed in the store so that no other
+// server sharing it hands the same one out.
+func (u *URLShortenerServer) shortenURL(baseURL string) (string, error) {
+	if baseURL == "" {
+		return "", shortener.InvalidURLError{ErrorMsg: shortener.ErrEmptyURL}
+	}
+	for attempt := 0; attempt < maxSuffixAttempts; attempt++ {
+		shortSuffix, err := u.shortener.ShortenURL(baseURL)
+		if err != nil {
+			return "", err
+		}
+		if u.SuffixTaken(shortSuffix) {
+			continue
+		}
+		claimed, err := store.ClaimSuffix(u.store, shortSuffix)
+		if err != nil {
+			return "", fmt.Errorf("unable to claim short suffix, %w", err)
+		}
+		if claimed {
+			return shortSuffix, nil
+		}
 	}
-	json.NewEncoder(w).Encode(u.getURLPair(shortSuffix, expandedURL))
+	return "", fmt.Errorf("%w after %d attempts", shortener.ErrSuffixesExhausted, maxSuffixAttempts)
 }
 
-func (u *URLShortenerServer) getURLPair(shortURL, baseURL string) model.URLPair {
-	return model.URLPair{ShortSuffix: shortURL, BaseURL: baseURL, Domain: u.domain}
+func (u *URLShortenerServer) indexHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	err := u.renderer.RenderIndex(w)
+
+	if err != nil {
+		panic(err)
+	}
 }
 
-func (u *URLShortenerServer) processAPIShortURL(w http.ResponseWriter, r *http.Request) {
-	// shortens base url to Short URL
+// shortenHandler shortens the base URL of a JSON body or of the url form
+// field, urlencoded or multipart, answering in the negotiated media type.
+func (u *URLShortenerServer) shortenHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
+	mediaType, ok := u.negotiate(w, r)
+	if !ok {
+		return
+	}
 
-	var urlPair *model.URLPair
-	var err error
-
-	if r.Header.Get("Content-Type") == JsonContentType {
-		w.Header().Set("Content-Type", JsonContentType) // set response to Json contenttype if request same
-		urlPair, err = u.processJSONShortURL(w, r)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	urlPair, err := u.readShortenRequest(r)
+	if err == nil {
+		urlPair.ShortSuffix, err = u.shortenURL(urlPair.BaseURL)
+	}
+	if err != nil {
+		if mediaType == HTMLMediaType {
+			u.renderInvalidUserInput(w, http.StatusOK, urlPair.BaseURL, err.Error())
 			return
 		}
+		u.writeProblemAs(w, r, mediaType, NewProblem(err))
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	u.store.Save(urlPair)
-}
+	urlPair.Domain = u.GetDomain()
+	if err := u.saveLink(urlPair); err != nil {
+		if mediaType == HTMLMediaType {
+			u.renderInvalidUserInput(w, u.storeErrorStatus(w, err), urlPair.BaseURL, ErrMsgSaveUnavailable)
+			return
+		}
+		u.writeProblemAs(w, r, mediaType, NewProblem(fmt.Errorf("unable to save short link, %w", err)))
+		return
+	}
 
-func (u *URLShortenerServer) processHTMXShortURL(w http.ResponseWriter, r *http.Request) (*model.URLPair, error) {
-	baseURL := r.FormValue("base-url")
-	shortSuffix, err := u.shortener.ShortenURL(baseURL) // ShortenURL has validation
-	if err != nil {
-		renderErr := u.renderer.RenderInvalidUserInput(w, model.URLPair{BaseURL: baseURL, Error: err.Error()})
-		if renderErr != nil {
+	if mediaType == HTMLMediaType {
+		if err := u.renderer.Render(w, urlPair); err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return nil, err
 		}
-		return nil, err
+		return
 	}
+	u.writeURLPair(w, mediaType, urlPair, urlPair.Domain+urlPair.ShortSuffix)
+}
 
-	urlPair := u.getURLPair(shortSuffix, baseURL)
-	u.store.Save(&urlPair)
-	err = u.renderer.Render(w, urlPair)
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return nil, err
+// saveLink saves a new link, stamped with when it was created.
+func (u *URLShortenerServer) saveLink(urlPair model.URLPair) error {
+	urlPair.Metadata = maps.Clone(urlPair.Metadata)
+	if urlPair.Metadata == nil {
+		urlPair.Metadata = map[string]string{}
 	}
-	return &urlPair, nil
+	urlPair.Metadata[model.MetadataCreatedAt] = time.Now().UTC().Format(time.RFC3339)
+	return u.store.Save(&urlPair)
 }
 
-func (u *URLShortenerServer) processJSONShortURL(w http.ResponseWriter, r *http.Request) (*model.URLPair, error) {
-	var urlPair = model.URLPair{}
+func (u *URLShortenerServer) readShortenRequest(r *http.Request) (model.URLPair, error) {
+	var urlPair model.URLPair
+	if !u.isAPIRequest(r) {
+		// the index page form names it base-url
+		urlPair.BaseURL = r.FormValue("url")
+		if urlPair.BaseURL == "" {
+			urlPair.BaseURL = r.FormValue("base-url")
+		}
+		urlPair.Metadata = map[string]string{}
+		if status := r.FormValue("redirect-status"); status != "" {
+			urlPair.Metadata[model.MetadataRedirectStatus] = status
+		}
+		for _, platform := range model.Platforms {
+			if destination := r.FormValue("destination-" + platform); destination != "" {
+				urlPair.Metadata[model.MetadataDestinationPrefix+platform] = destination
+			}
+		}
+		for field, key := range linkFlagFields {
+			if value := r.FormValue(field); value != "" {
+				// checkboxes are sent as on
+				if value == "on" {
+					value = "true"
+				}
+				urlPair.Metadata[key] = value
+			}
+		}
+		return urlPair, validateLinkOptions(urlPair)
+	}
 	// Decoding into urlPair overwrites the default data
-	err := json.NewDecoder(r.Body).Decode(&urlPair)
+	if err := json.NewDecoder(r.Body).Decode(&urlPair); err != nil {
+		return model.URLPair{}, fmt.Errorf("%w: error decoding json, %v", ErrBadRequest, err)
+	}
+	return urlPair, validateLinkOptions(urlPair)
+}
 
-	if err != nil {
-		return nil, errors.New("error decoding json")
+// linkFlagFields are the form fields of the options a link is created with
+// that are on or off, and the metadata keys they are kept under.
+var linkFlagFields = map[string]string{
+	"forward-query": model.MetadataForwardQuery,
+	"forward-path":  model.MetadataForwardPath,
+}
+
+// settableMetadata are the metadata keys a shorten request may set, along
+// with platform destinations. The rest are kept by the server and importer.
+var settableMetadata = map[string]bool{
+	model.MetadataExpiresAt:      true,
+	model.MetadataRedirectStatus: true,
+	model.MetadataForwardQuery:   true,
+	model.MetadataForwardPath:    true,
+	model.MetadataVariants:       true,
+	model.MetadataStickyVariant:  true,
+}
+
+// validateLinkOptions checks the options a new link asks for, if any.
+func validateLinkOptions(urlPair model.URLPair) error {
+	for key := range urlPair.Metadata {
+		if !settableMetadata[key] && !strings.HasPrefix(key, model.MetadataDestinationPrefix) {
+			return fmt.Errorf("%w: metadata %q can't be set", ErrBadRequest, key)
+		}
+	}
+	if value, ok := urlPair.Metadata[model.MetadataExpiresAt]; ok {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("%w: invalid %v %q, expected RFC 3339", ErrBadRequest, model.MetadataExpiresAt, value)
+		}
+		if !expiresAt.After(time.Now()) {
+			return fmt.Errorf("%w: %v %v is not in the future", ErrBadRequest, model.MetadataExpiresAt, value)
+		}
+	}
+	if value, ok := urlPair.Metadata[model.MetadataRedirectStatus]; ok {
+		status, err := strconv.Atoi(value)
+		if err == nil {
+			err = model.ValidateRedirectStatus(status)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: invalid redirect status %q", ErrBadRequest, value)
+		}
+	}
+	for _, key := range linkFlagFields {
+		if value, ok := urlPair.Metadata[key]; ok && value != "true" && value != "false" {
+			return fmt.Errorf("%w: invalid %v %q, expected true or false", ErrBadRequest, key, value)
+		}
+	}
+	for platform, destination := range urlPair.PlatformDestinations() {
+		if err := validatePlatformDestination(platform, destination); err != nil {
+			return err
+		}
 	}
+	if value, ok := urlPair.Metadata[model.MetadataVariants]; ok {
+		if _, err := model.ParseVariants(value); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadRequest, err)
+		}
+	}
+	if value, ok := urlPair.Metadata[model.MetadataStickyVariant]; ok && value != "true" && value != "false" {
+		return fmt.Errorf("%w: invalid %v %q, expected true or false", ErrBadRequest, model.MetadataStickyVariant, value)
+	}
+	return nil
+}
 
-	// VALIDATE URL THEN RETURN ERROR IF INVALID
-	if urlPair.BaseURL == "" {
-		w.WriteHeader(http.StatusInternalServerError)
-		return nil, errors.New("base url is empty")
+func (u *URLShortenerServer) renderInvalidUserInput(w http.ResponseWriter, status int, baseURL, message string) {
+	w.Header().Set("Content-Type", HtmxResponseContentType)
+	w.WriteHeader(status)
+	if err := u.renderer.RenderInvalidUserInput(w, model.URLPair{BaseURL: baseURL, Error: message}); err != nil {
+		log.Printf("unable to render invalid user input: %v", err)
 	}
+}
 
-	shortSuffix, err := u.shortener.ShortenURL(urlPair.BaseURL)
+// expandHandler answers with the base URL of a short link in the negotiated
+// media type, HTML being a redirect. ?info prints the base URL as text
+// instead of redirecting, whatever the request accepts.
+func (u *URLShortenerServer) expandHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := TextMediaType, true
+	if !r.URL.Query().Has("info") {
+		mediaType, ok = u.negotiate(w, r)
+	}
+	if !ok {
+		return
+	}
+	shortSuffix := r.PathValue("suffix")
+	urlPair, err := u.loadURLPair(shortSuffix)
+	// only links forwarding their path have anything under them
+	if err == nil && r.PathValue("path") != "" && !urlPair.ForwardsPath() {
+		err = store.ErrNotFound
+	}
 	if err != nil {
-		// issue might be here
-		return nil, errors.New("could not shorten baseURL: " + err.Error())
+		u.writeLoadError(w, r, mediaType, shortSuffix, err)
+		return
+	}
+	if len(urlPair.PlatformDestinations()) > 0 {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	// only redirects go to a variant, the link itself keeps its base URL
+	variant, hasVariant := model.Variant{}, false
+	if mediaType == HTMLMediaType {
+		if variant, hasVariant = chooseVariant(w, r, urlPair); hasVariant {
+			urlPair.BaseURL = variant.URL
+		}
+	}
+	baseURL, err := destination(urlPair, r)
+	if err != nil {
+		u.writeProblemAs(w, r, mediaType, NewProblem(err))
+		return
 	}
 
-	urlPair.Domain = u.GetDomain()
-
-	w.WriteHeader(http.StatusOK)
+	if mediaType != HTMLMediaType {
+		u.writeURLPair(w, mediaType, u.getURLPair(shortSuffix, urlPair.BaseURL), baseURL)
+		return
+	}
+	u.countClick(r, shortSuffix, variant.Name)
+	if u.isHTMXRequest(r) {
+		w.Header().Set("Content-Type", HtmxResponseContentType)
+		w.Header().Set("HX-Redirect", baseURL)
+	} else { // normal redirect for normal web requests
+		status := u.redirectStatusOf(urlPair)
+		cacheControl := redirectCacheControl(status)
+		if hasVariant {
+			// every click picks a variant, none may be answered from a cache
+			cacheControl = "no-store"
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+		http.Redirect(w, r, baseURL, status)
+	}
+}
 
-	urlPair.ShortSuffix = shortSuffix
+// writeLoadError answers for a link that couldn't be loaded, as missing when
+// the store doesn't have it and 503 when the store couldn't be reached.
+func (u *URLShortenerServer) writeLoadError(w http.ResponseWriter, r *http.Request, mediaType, shortSuffix string, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		u.writeMissingLink(w, r, mediaType, shortSuffix)
+		return
+	}
+	u.writeProblemAs(w, r, mediaType, NewProblem(fmt.Errorf("unable to load %v, %w", shortSuffix, err)))
+}
 
-	err = json.NewEncoder(w).Encode(urlPair)
-	if err != nil {
-		return nil, errors.New("could not encode to JSON: " + err.Error())
+// writeMissingLink answers 410 for disabled links and 404 otherwise.
+func (u *URLShortenerServer) writeMissingLink(w http.ResponseWriter, r *http.Request, mediaType, shortSuffix string) {
+	var tombstone model.Tombstone
+	disabled := false
+	// the filter holds disabled suffixes too, one it rules out has no tombstone
+	if u.suffixFilter == nil || u.suffixFilter.MightContain(shortSuffix) {
+		tombstone, disabled = u.tombstone(shortSuffix)
+	}
+	switch {
+	case disabled && mediaType == HTMLMediaType:
+		w.Header().Set("Content-Type", HtmxResponseContentType)
+		w.WriteHeader(http.StatusGone)
+		if err := u.renderer.RenderLinkDisabled(w, tombstone); err != nil {
+			log.Printf("unable to render disabled link page: %v", err)
+		}
+	case disabled:
+		problem := NewProblem(fmt.Errorf("%w: %v", ErrLinkDisabled, shortSuffix))
+		problem.Reason = tombstone.Reason
+		u.writeProblemAs(w, r, mediaType, problem)
+	case mediaType == HTMLMediaType:
+		http.Error(w, "Page not found.", http.StatusNotFound)
+	default:
+		u.writeProblemAs(w, r, mediaType, NewProblem(fmt.Errorf("%w: %v", store.ErrNotFound, shortSuffix)))
 	}
+}
+
+func (u *URLShortenerServer) isHTMXRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
 
-	return &urlPair, nil
+// isAPIRequest reports whether the body is JSON, whatever its parameters,
+// e.g. a charset.
+func (u *URLShortenerServer) isAPIRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == JsonContentType
+}
+
+func (u *URLShortenerServer) getURLPair(shortURL, baseURL string) model.URLPair {
+	return model.URLPair{ShortSuffix: shortURL, BaseURL: baseURL, Domain: u.domain}
+}
+
+// storeErrorStatus maps a failed store write to a status code, asking clients
+// to come back later when the store is only temporarily unavailable.
+func (u *URLShortenerServer) storeErrorStatus(w http.ResponseWriter, err error) int {
+	if errors.Is(err, store.ErrUnavailable) {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
 }
 
-type URLStore interface {
-	Save(*model.URLPair) error
-	Load(shortSuffix string) (string, bool)
+// SuffixFilter answers false only for suffixes that are certainly neither
+// stored nor disabled.
+type SuffixFilter interface {
+	MightContain(shortSuffix string) bool
 }
@@ -1,15 +1,24 @@
 package server_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/0xKev/url-shortener/internal/model"
 	server "github.com/0xKev/url-shortener/internal/server"
+	"github.com/0xKev/url-shortener/internal/shortener"
+	"github.com/0xKev/url-shortener/internal/store"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
 	testutil "github.com/0xKev/url-shortener/internal/testutil"
 )
 
@@ -36,6 +45,25 @@ func (s *StubURLStore) Load(shortSuffix string) (string, bool) {
 	return baseURL, found
 }
 
+// UnavailableURLStore behaves like a store whose primary is down: reads work,
+// writes are refused.
+type UnavailableURLStore struct {
+	StubURLStore
+}
+
+func (u *UnavailableURLStore) Save(urlPair *model.URLPair) error {
+	return fmt.Errorf("%w: primary is down", store.ErrUnavailable)
+}
+
+// UnreachableURLStore can't be read either.
+type UnreachableURLStore struct {
+	UnavailableURLStore
+}
+
+func (u *UnreachableURLStore) LookupURLPair(shortSuffix string) (model.URLPair, error) {
+	return model.URLPair{}, fmt.Errorf("%w: no store could answer for %v", store.ErrUnavailable, shortSuffix)
+}
+
 type MockURLShortener struct {
 	ShortenBaseURLFunc func(baseURL string) (string, error)
 }
@@ -155,10 +183,11 @@ func TestAPI_POST_CreateShortURL(t *testing.T) {
 	t.Run("error on invalid url POST request", func(t *testing.T) {
 		response := httptest.NewRecorder()
 
-		request := testutil.NewPostAPIShortenURLRequest(invalidBaseURL) // MAKE THIS RETURN AN ERROR
+		request := testutil.NewPostAPIShortenURLRequest(invalidBaseURL)
 		shortenerServer.ServeHTTP(response, request)
 
-		testutil.AssertStatus(t, response.Code, http.StatusInternalServerError)
+		testutil.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		testutil.AssertEqual(t, decodeProblem(t, response).Code, server.ProblemInvalidURL)
 	})
 }
 
@@ -166,7 +195,6 @@ func TestAPI_JSONResponse(t *testing.T) {
 	store := StubURLStore{
 		urlMap: map[string]string{
 			googleShortSuffix: "google.com",
-			githubShortSuffix: "github.com",
 		},
 	}
 	shortenerServer := server.NewURLShortenerServer(&store, MockURLShortener{
@@ -212,7 +240,7 @@ func TestAPI_JSONResponse(t *testing.T) {
 
 	t.Run("returns status 200 on valid POST request", func(t *testing.T) {
 		response := httptest.NewRecorder()
-		request := testutil.NewPostAPIShortenURLRequest(store.urlMap[googleShortSuffix])
+		request := testutil.NewPostAPIShortenURLRequest("github.com")
 
 		shortenerServer.ServeHTTP(response, request)
 		testutil.AssertStatus(t, response.Code, http.StatusOK)
@@ -221,14 +249,25 @@ func TestAPI_JSONResponse(t *testing.T) {
 
 	t.Run("returns valid POST request as JSON", func(t *testing.T) {
 		response := httptest.NewRecorder()
-		request := testutil.NewPostAPIShortenURLRequest(store.urlMap[googleShortSuffix])
+		request := testutil.NewPostAPIShortenURLRequest("github.com")
 
 		shortenerServer.ServeHTTP(response, request)
 
 		got := testutil.GetURLPairFromResponse(t, response.Body)
 
 		testutil.AssertContentType(t, response, server.JsonContentType)
-		assertURLPairs(t, got, model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: store.urlMap[googleShortSuffix], Domain: shortenerServer.GetDomain()})
+		assertURLPairs(t, got, model.URLPair{ShortSuffix: githubShortSuffix, BaseURL: "github.com", Domain: shortenerServer.GetDomain()})
+	})
+
+	t.Run("reads JSON bodies with a charset", func(t *testing.T) {
+		request := testutil.NewPostAPIShortenURLRequest("github.com")
+		request.Header.Set("Content-Type", "application/json; charset=utf-8")
+		response := httptest.NewRecorder()
+
+		shortenerServer.ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		assertURLPairs(t, testutil.GetURLPairFromResponse(t, response.Body), model.URLPair{ShortSuffix: githubShortSuffix, BaseURL: "github.com", Domain: shortenerServer.GetDomain()})
 	})
 }
 
@@ -339,6 +378,10 @@ func TestServer_SetAndRetrieveCorrectDomain(t *testing.T) {
 		assertURLPairs(t, urlPair, expectedUrlPair)
 	})
 
+	t.Run("the default domain is valid", func(t *testing.T) {
+		testutil.AssertNoError(t, shortenerServer.SetDomain(server.DefaultDomain))
+	})
+
 	t.Run("returns error when setting invalid domain", func(t *testing.T) {
 		invalidDomains := []string{
 			"invalid-domain",
@@ -356,6 +399,75 @@ func TestServer_SetAndRetrieveCorrectDomain(t *testing.T) {
 	})
 }
 
+type StubSuffixFilter struct {
+	known map[string]bool
+}
+
+func (s StubSuffixFilter) MightContain(shortSuffix string) bool {
+	return s.known[shortSuffix]
+}
+
+func TestServer_SuffixFilter(t *testing.T) {
+	store := StubURLStore{
+		urlMap: map[string]string{
+			googleShortSuffix: "google.com",
+		},
+	}
+	shortenerServer := server.NewURLShortenerServer(&store, MockURLShortener{})
+	shortenerServer.SetSuffixFilter(StubSuffixFilter{known: map[string]bool{googleShortSuffix: true}})
+
+	t.Run("returns 404 without a store lookup when the filter rules the suffix out", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewGetAPIExpandedURLRequest(doesNotExistShortSuffix))
+
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+		testutil.AssertEqual(t, len(store.getURLCalls), 0)
+	})
+
+	t.Run("loads suffixes the filter might contain", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewGetAPIExpandedURLRequest(googleShortSuffix))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertEqual(t, len(store.getURLCalls), 1)
+	})
+}
+
+type TombstoneCountingURLStore struct {
+	*memoryStore.InMemoryURLStore
+	tombstoneCalls int
+}
+
+func (c *TombstoneCountingURLStore) Tombstone(shortSuffix string) (model.Tombstone, bool) {
+	c.tombstoneCalls++
+	return c.InMemoryURLStore.Tombstone(shortSuffix)
+}
+
+func TestServer_SuffixFilterSkipsTombstones(t *testing.T) {
+	urlStore := &TombstoneCountingURLStore{InMemoryURLStore: memoryStore.NewInMemoryURLStore()}
+	urlStore.Save(&model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: "https://google.com"})
+	_, err := urlStore.Disable(googleShortSuffix, model.DisabledForAbuse)
+	testutil.AssertNoError(t, err)
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{})
+	shortenerServer.SetSuffixFilter(StubSuffixFilter{known: map[string]bool{googleShortSuffix: true}})
+
+	t.Run("doesn't look up the tombstone of a suffix the filter rules out", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewGetAPIExpandedURLRequest(doesNotExistShortSuffix))
+
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+		testutil.AssertEqual(t, urlStore.tombstoneCalls, 0)
+	})
+
+	t.Run("finds the tombstone of a disabled suffix the filter holds", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewGetAPIExpandedURLRequest(googleShortSuffix))
+
+		testutil.AssertStatus(t, response.Code, http.StatusGone)
+		testutil.AssertEqual(t, urlStore.tombstoneCalls, 1)
+	})
+}
+
 //	func TestServer_IndexPage(t *testing.T) {
 //		response := httptest.NewRecorder()
 //		request := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -387,14 +499,1095 @@ func TestServer_InvalidRoutes(t *testing.T) {
 	})
 }
 
-// Concurrency
-func TestConcurrent_POST_ShortenURL(t *testing.T) {
-	store := StubURLStore{
-		urlMap: map[string]string{
-			googleShortSuffix: "google.com",
-			githubShortSuffix: "github.com",
+func TestServer_Routing(t *testing.T) {
+	store := StubURLStore{urlMap: map[string]string{"xapi123": "https://example.com", "static1": "https://example.org"}}
+	shortenerServer := server.NewURLShortenerServer(&store, MockURLShortener{})
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{"suffixes containing a route name expand", http.MethodGet, "/xapi123", http.StatusPermanentRedirect, ""},
+		{"suffixes starting with a route name expand", http.MethodGet, "/static1", http.StatusPermanentRedirect, ""},
+		{"API shorten only takes POST", http.MethodGet, server.APIShortenRoute, http.StatusMethodNotAllowed, "POST"},
+		{"shorten only takes POST", http.MethodGet, server.HtmxShortenRoute, http.StatusMethodNotAllowed, "POST"},
+		{"API expand only takes GET", http.MethodPost, server.APIExpandRoute + "xapi123", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"short links only take GET", http.MethodDelete, "/xapi123", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"admin routes only take their method", http.MethodGet, server.AdminImportRoute, http.StatusMethodNotAllowed, "POST"},
+		{"reserved paths without a route are not found", http.MethodGet, "/admin", http.StatusNotFound, ""},
+		{"routes with a suffix only take their method", http.MethodGet, server.AdminDisableRoute + "xapi123", http.StatusMethodNotAllowed, "POST"},
+		{"static files only take GET", http.MethodPost, server.StaticRoute + "css/output.css", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"paths under short links not forwarding them are not found", http.MethodGet, "/xapi123/docs", http.StatusNotFound, ""},
+		{"paths under short links only take GET", http.MethodPost, "/xapi123/docs", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			shortenerServer.ServeHTTP(response, httptest.NewRequest(c.method, c.path, nil))
+
+			testutil.AssertStatus(t, response.Code, c.status)
+			testutil.AssertEqual(t, response.Header().Get("Allow"), c.allow)
+		})
+	}
+
+	t.Run("routes reserve their first path segment", func(t *testing.T) {
+		testutil.AssertEqual(t, strings.Join(server.ReservedPaths(), ","), "admin,api,shorten,static")
+		testutil.AssertEqual(t, server.IsReservedSuffix("api"), true)
+		testutil.AssertEqual(t, server.IsReservedSuffix("a/b"), true)
+		testutil.AssertEqual(t, server.IsReservedSuffix("abc"+server.PreviewSuffix), true)
+		testutil.AssertEqual(t, server.IsReservedSuffix("xapi123"), false)
+	})
+}
+
+func TestAPI_ProblemDetails(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		shorten func(baseURL string) (string, error)
+		status  int
+		code    string
+	}{
+		{"malformed JSON", `{"baseURL":`, nil, http.StatusBadRequest, server.ProblemBadRequest},
+		{"empty base URL", `{"baseURL":""}`, nil, http.StatusUnprocessableEntity, server.ProblemInvalidURL},
+		{"metadata kept by the server", `{"baseURL":"google.com","metadata":{"clicks":"1000"}}`, nil, http.StatusBadRequest, server.ProblemBadRequest},
+		{"unknown metadata", `{"baseURL":"google.com","metadata":{"owner":"ops"}}`, nil, http.StatusBadRequest, server.ProblemBadRequest},
+		{"malformed expiry", `{"baseURL":"google.com","metadata":{"expiresAt":"tomorrow"}}`, nil, http.StatusBadRequest, server.ProblemBadRequest},
+		{"past expiry", `{"baseURL":"google.com","metadata":{"expiresAt":"2000-01-01T00:00:00Z"}}`, nil, http.StatusBadRequest, server.ProblemBadRequest},
+		{"invalid base URL", `{"baseURL":"google"}`, func(baseURL string) (string, error) {
+			return "", shortener.InvalidURLError{ErrorMsg: shortener.ErrNoDomainURL, SubmittedURL: baseURL}
+		}, http.StatusUnprocessableEntity, server.ProblemInvalidURL},
+		{"no suffix left", `{"baseURL":"google.com"}`, func(baseURL string) (string, error) {
+			return "", shortener.ExceedCounterError{CurrentCounter: 10, MaxCounter: 10}
+		}, http.StatusServiceUnavailable, server.ProblemSuffixesExhausted},
+		{"unexpected shortener error", `{"baseURL":"google.com"}`, func(baseURL string) (string, error) {
+			return "", errors.New("boom")
+		}, http.StatusInternalServerError, server.ProblemInternal},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			shortenerServer := server.NewURLShortenerServer(&StubURLStore{}, MockURLShortener{ShortenBaseURLFunc: c.shorten})
+			request := httptest.NewRequest(http.MethodPost, server.APIShortenRoute, strings.NewReader(c.body))
+			request.Header.Set("Content-Type", server.JsonContentType)
+			response := httptest.NewRecorder()
+			shortenerServer.ServeHTTP(response, request)
+
+			testutil.AssertStatus(t, response.Code, c.status)
+			problem := decodeProblem(t, response)
+			testutil.AssertEqual(t, problem.Status, c.status)
+			testutil.AssertEqual(t, problem.Code, c.code)
+			testutil.AssertEqual(t, problem.Instance, server.APIShortenRoute)
+			if c.status == http.StatusInternalServerError && problem.Detail != "" {
+				t.Errorf("expected internal errors to be kept out of the response, got %q", problem.Detail)
+			}
+		})
+	}
+
+	t.Run("missing links", func(t *testing.T) {
+		shortenerServer := server.NewURLShortenerServer(&StubURLStore{}, MockURLShortener{})
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewGetAPIExpandedURLRequest(doesNotExistShortSuffix))
+
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+		testutil.AssertEqual(t, decodeProblem(t, response).Code, server.ProblemNotFound)
+	})
+}
+
+func decodeProblem(t testing.TB, response *httptest.ResponseRecorder) server.Problem {
+	t.Helper()
+	testutil.AssertContentType(t, response, server.ProblemContentType)
+	var problem server.Problem
+	if err := json.NewDecoder(response.Body).Decode(&problem); err != nil {
+		t.Fatalf("unable to decode problem %v", err)
+	}
+	return problem
+}
+
+func TestServer_ContentNegotiation(t *testing.T) {
+	store := StubURLStore{urlMap: map[string]string{googleShortSuffix: "https://google.com"}}
+	shortenerServer := server.NewURLShortenerServer(&store, MockURLShortener{
+		ShortenBaseURLFunc: func(baseURL string) (string, error) {
+			return githubShortSuffix, nil
 		},
+	})
+	shortenerServer.SetDomain("https://shortener.com/")
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	cases := []struct {
+		name        string
+		request     *http.Request
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"browsers are redirected", httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil), browser, http.StatusPermanentRedirect, "text/html; charset=utf-8", ""},
+		{"curl is redirected", httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil), "*/*", http.StatusPermanentRedirect, "text/html; charset=utf-8", ""},
+		{"short links expand to JSON", httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil), server.JsonContentType, http.StatusOK, server.JsonContentType, `"baseURL":"https://google.com"`},
+		{"short links expand to text", httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil), "text/plain", http.StatusOK, server.TextContentType, "https://google.com\n"},
+		{"short links expand to XML", httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil), "text/xml", http.StatusOK, server.XMLContentType, "<baseURL>https://google.com</baseURL>"},
+		{"quality values are honoured", httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil), "application/json;q=0.5, text/plain", http.StatusOK, server.TextContentType, "https://google.com\n"},
+		{"API expand defaults to JSON", httptest.NewRequest(http.MethodGet, server.APIExpandRoute+googleShortSuffix, nil), "*/*", http.StatusOK, server.JsonContentType, `"shortSuffix":"` + googleShortSuffix + `"`},
+		{"API problems follow the media type", httptest.NewRequest(http.MethodGet, server.APIExpandRoute+doesNotExistShortSuffix, nil), "application/xml", http.StatusNotFound, server.ProblemXMLContentType, "<code>not-found</code>"},
+		{"shorten answers JSON", newFormRequest(server.HtmxShortenRoute, "google.com"), server.JsonContentType, http.StatusOK, server.JsonContentType, `"shortSuffix":"` + githubShortSuffix + `"`},
+		{"shorten answers the short URL as text", testutil.NewPostAPIShortenURLRequest("google.com"), "text/plain", http.StatusOK, server.TextContentType, "https://shortener.com/" + githubShortSuffix + "\n"},
+		{"shorten errors follow the media type", testutil.NewPostAPIShortenURLRequest(""), "text/plain", http.StatusUnprocessableEntity, server.TextContentType, "invalid url"},
+		{"unacceptable media types are refused", httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil), "image/png", http.StatusNotAcceptable, server.TextContentType, ""},
 	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.request.Header.Set("Accept", c.accept)
+			response := httptest.NewRecorder()
+			shortenerServer.ServeHTTP(response, c.request)
+
+			testutil.AssertStatus(t, response.Code, c.status)
+			testutil.AssertContentType(t, response, c.contentType)
+			if !strings.Contains(response.Body.String(), c.body) {
+				t.Errorf("expected body to contain %q, got %q", c.body, response.Body.String())
+			}
+			testutil.AssertEqual(t, response.Header().Get("Vary"), "Accept")
+		})
+	}
+
+	t.Run("HTMX requests get partials whatever they accept", func(t *testing.T) {
+		request := testutil.NewPostHTMXShortenURLRequest("google.com")
+		request.Header.Set("Accept", server.JsonContentType)
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertContentType(t, response, server.HtmxResponseContentType)
+	})
+}
+
+func newFormRequest(path, baseURL string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader("base-url="+baseURL))
+	request.Header.Set("Content-Type", server.HtmxRequestContentType)
+	return request
+}
+
+func TestServer_PlainText(t *testing.T) {
+	store := StubURLStore{urlMap: map[string]string{googleShortSuffix: "https://google.com"}}
+	shortenerServer := server.NewURLShortenerServer(&store, MockURLShortener{
+		ShortenBaseURLFunc: func(baseURL string) (string, error) {
+			return githubShortSuffix, nil
+		},
+	})
+	shortenerServer.SetDomain("https://shortener.com/")
+
+	t.Run("curl -d shortens to a plain short URL", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("url=https%3A%2F%2Fgithub.com"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Accept", "*/*")
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertContentType(t, response, server.TextContentType)
+		testutil.AssertResponseBody(t, response.Body.String(), "https://shortener.com/"+githubShortSuffix+"\n")
+	})
+
+	t.Run("curl -F shortens to a plain short URL", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		form := multipart.NewWriter(body)
+		form.WriteField("url", "https://github.com")
+		form.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", body)
+		request.Header.Set("Content-Type", form.FormDataContentType())
+		request.Header.Set("Accept", "*/*")
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertResponseBody(t, response.Body.String(), "https://shortener.com/"+githubShortSuffix+"\n")
+	})
+
+	t.Run("a missing url is reported as text", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("link=github.com"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		testutil.AssertContentType(t, response, server.TextContentType)
+	})
+
+	t.Run("?info prints the destination", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix+"?info", nil)
+		request.Header.Set("Accept", "*/*")
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertContentType(t, response, server.TextContentType)
+		testutil.AssertResponseBody(t, response.Body.String(), "https://google.com\n")
+	})
+
+	t.Run("?info on a missing link is a 404", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/"+doesNotExistShortSuffix+"?info", nil))
+
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+		testutil.AssertContentType(t, response, server.TextContentType)
+	})
+}
+
+func TestServer_StoreUnavailable(t *testing.T) {
+	urlStore := UnavailableURLStore{StubURLStore{urlMap: map[string]string{googleShortSuffix: "google.com"}}}
+	shortenerServer := server.NewURLShortenerServer(&urlStore, MockURLShortener{
+		ShortenBaseURLFunc: func(baseURL string) (string, error) {
+			return githubShortSuffix, nil
+		},
+	})
+
+	t.Run("API shorten returns 503", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewPostAPIShortenURLRequest("github.com"))
+
+		testutil.AssertStatus(t, response.Code, http.StatusServiceUnavailable)
+		testutil.AssertEqual(t, decodeProblem(t, response).Code, server.ProblemUnavailable)
+		if response.Header().Get("Retry-After") == "" {
+			t.Error("expected a Retry-After header")
+		}
+	})
+
+	t.Run("HTMX shorten returns 503 with the error message", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewPostHTMXShortenURLRequest("github.com"))
+
+		testutil.AssertStatus(t, response.Code, http.StatusServiceUnavailable)
+		if !strings.Contains(response.Body.String(), server.ErrMsgSaveUnavailable) {
+			t.Errorf("expected body to contain %q, got %q", server.ErrMsgSaveUnavailable, response.Body.String())
+		}
+	})
+
+	t.Run("redirects keep working", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewGetAPIExpandedURLRequest(googleShortSuffix))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("redirects return 503 when no store can look up the link", func(t *testing.T) {
+		unreachable := UnreachableURLStore{UnavailableURLStore{StubURLStore{urlMap: map[string]string{googleShortSuffix: "google.com"}}}}
+		shortenerServer := server.NewURLShortenerServer(&unreachable, MockURLShortener{})
+
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewGetAPIExpandedURLRequest(googleShortSuffix))
+
+		testutil.AssertStatus(t, response.Code, http.StatusServiceUnavailable)
+		testutil.AssertEqual(t, decodeProblem(t, response).Code, server.ProblemUnavailable)
+
+		response = httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewGetHTMXExpandedURLRequest(googleShortSuffix))
+
+		testutil.AssertStatus(t, response.Code, http.StatusServiceUnavailable)
+	})
+}
+
+func newAdminRequest(method, target, body string) *http.Request {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer secret")
+	return request
+}
+
+func TestServer_AdminExportImport(t *testing.T) {
+	urlStore := memoryStore.NewInMemoryURLStore()
+	urlStore.Save(&model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: "google.com", Metadata: map[string]string{"owner": "ops"}})
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{})
+
+	t.Run("admin routes are disabled without a token", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodGet, server.AdminExportRoute, ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	shortenerServer.SetAdminToken("secret")
+
+	t.Run("rejects a wrong token", func(t *testing.T) {
+		request := newAdminRequest(http.MethodGet, server.AdminExportRoute, "")
+		request.Header.Set("Authorization", "Bearer guess")
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("exports JSON lines", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodGet, server.AdminExportRoute, ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertEqual(t, response.Header().Get("Content-Type"), server.NDJSONContentType)
+		testutil.AssertResponseBody(t, response.Body.String(), `{"shortSuffix":"`+googleShortSuffix+`","baseURL":"google.com","domain":"`+server.DefaultDomain+`","metadata":{"owner":"ops"}}`+"\n")
+	})
+
+	t.Run("exports CSV", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodGet, server.AdminExportRoute+"?format=csv", ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertEqual(t, response.Header().Get("Content-Type"), server.CSVContentType)
+		testutil.AssertResponseBody(t, response.Body.String(), "shortSuffix,baseURL,domain,metadata\n"+googleShortSuffix+",google.com,"+server.DefaultDomain+`,"{""owner"":""ops""}"`+"\n")
+	})
+
+	t.Run("import fails on conflicts without writing", func(t *testing.T) {
+		body := `{"shortSuffix":"` + googleShortSuffix + `","baseURL":"bing.com"}` + "\n" + `{"shortSuffix":"new","baseURL":"new.com"}`
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminImportRoute, body))
+
+		testutil.AssertStatus(t, response.Code, http.StatusConflict)
+		_, found := urlStore.Load("new")
+		testutil.AssertEqual(t, found, false)
+	})
+
+	t.Run("import skips conflicts when asked to", func(t *testing.T) {
+		body := "shortSuffix,baseURL\n" + googleShortSuffix + ",bing.com\nnew,new.com\n"
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminImportRoute+"?format=csv&conflict=skip", body))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertResponseBody(t, response.Body.String(), `{"imported":1,"overwritten":0,"skipped":1,"unchanged":0,"conflicts":[{"row":2,"shortSuffix":"`+googleShortSuffix+`","reason":"suffix already points to another base URL"}]}`+"\n")
+		baseURL, _ := urlStore.Load(googleShortSuffix)
+		testutil.AssertEqual(t, baseURL, "google.com")
+	})
+
+	t.Run("import reports suffixes used by routes", func(t *testing.T) {
+		body := "Back-half,Long URL,Created,Clicks\nstatic,example.com/static,2023-04-01,\"1,024\"\nlaunch,example.com/launch,2023-04-02,7\n"
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminImportRoute+"?format=hosted", body))
+
+		testutil.AssertStatus(t, response.Code, http.StatusConflict)
+		if !strings.Contains(response.Body.String(), `"reason":"suffix is a reserved route"`) {
+			t.Errorf("expected the reserved suffix to be reported, got %q", response.Body.String())
+		}
+		_, found := urlStore.Load("launch")
+		testutil.AssertEqual(t, found, false)
+	})
+
+	t.Run("import reports suffixes taken for previews", func(t *testing.T) {
+		body := "shortSuffix,baseURL\nlaunch+,example.com/launch\n"
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminImportRoute+"?format=csv", body))
+
+		testutil.AssertStatus(t, response.Code, http.StatusConflict)
+		_, found := urlStore.Load("launch+")
+		testutil.AssertEqual(t, found, false)
+	})
+
+	t.Run("import rejects an unknown policy", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminImportRoute+"?conflict=merge", ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+}
+
+func TestServer_DisableAndRestore(t *testing.T) {
+	urlStore := memoryStore.NewInMemoryURLStore()
+	urlStore.Save(&model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: "https://google.com"})
+	// the shortener hands out the disabled suffix first
+	suffixes := []string{googleShortSuffix, githubShortSuffix}
+	shortener := MockURLShortener{ShortenBaseURLFunc: func(baseURL string) (string, error) {
+		shortSuffix := suffixes[0]
+		suffixes = suffixes[1:]
+		return shortSuffix, nil
+	}}
+	shortenerServer := server.NewURLShortenerServer(urlStore, shortener)
+	shortenerServer.SetAdminToken("secret")
+
+	t.Run("rejects an unknown reason", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminDisableRoute+googleShortSuffix+"?reason=boredom", ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("returns 404 for unknown links", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminDisableRoute+doesNotExistShortSuffix+"?reason=abuse", ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("disables a link", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminDisableRoute+googleShortSuffix+"?reason=abuse", ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		if !strings.Contains(response.Body.String(), `"reason":"abuse"`) {
+			t.Errorf("expected the tombstone in the response, got %q", response.Body.String())
+		}
+	})
+
+	t.Run("disabled links answer 410 with a page", func(t *testing.T) {
+		for _, request := range []*http.Request{testutil.NewGetHTMXExpandedURLRequest(googleShortSuffix), httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil)} {
+			response := httptest.NewRecorder()
+			shortenerServer.ServeHTTP(response, request)
+
+			testutil.AssertStatus(t, response.Code, http.StatusGone)
+			testutil.AssertContentType(t, response, server.HtmxResponseContentType)
+			testutil.AssertNoHTMXRedirect(t, *response.Result())
+			if !strings.Contains(response.Body.String(), "reported for abuse") {
+				t.Errorf("expected the link disabled page, got %q", response.Body.String())
+			}
+		}
+	})
+
+	t.Run("disabled links answer 410 on the API", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewGetAPIExpandedURLRequest(googleShortSuffix))
+
+		testutil.AssertStatus(t, response.Code, http.StatusGone)
+		problem := decodeProblem(t, response)
+		testutil.AssertEqual(t, problem.Code, server.ProblemLinkDisabled)
+		testutil.AssertEqual(t, problem.Reason, model.DisabledForAbuse)
+	})
+
+	t.Run("disabled suffixes are not reissued", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, testutil.NewPostAPIShortenURLRequest("https://github.com"))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertEqual(t, testutil.GetURLPairFromResponse(t, response.Body).ShortSuffix, githubShortSuffix)
+		_, found := urlStore.Tombstone(googleShortSuffix)
+		testutil.AssertEqual(t, found, true)
+	})
+
+	t.Run("disabled suffixes are not imported over", func(t *testing.T) {
+		body := `{"shortSuffix":"` + googleShortSuffix + `","baseURL":"bing.com"}`
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminImportRoute, body))
+
+		testutil.AssertStatus(t, response.Code, http.StatusConflict)
+	})
+
+	t.Run("restores a link", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminRestoreRoute+googleShortSuffix, ""))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertEqual(t, testutil.GetURLPairFromResponse(t, response.Body).BaseURL, "https://google.com")
+
+		response = httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil))
+		testutil.AssertStatus(t, response.Code, http.StatusPermanentRedirect)
+
+		response = httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newAdminRequest(http.MethodPost, server.AdminRestoreRoute+googleShortSuffix, ""))
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func TestServer_RedirectStatus(t *testing.T) {
+	urlStore := memoryStore.NewInMemoryURLStore()
+	urlStore.Save(&model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: "https://google.com"})
+	urlStore.Save(&model.URLPair{ShortSuffix: "temporary", BaseURL: "https://google.com", Metadata: map[string]string{model.MetadataRedirectStatus: "302"}})
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{
+		ShortenBaseURLFunc: func(baseURL string) (string, error) {
+			return githubShortSuffix, nil
+		},
+	})
+
+	redirect := func(t *testing.T, shortSuffix string) *httptest.ResponseRecorder {
+		t.Helper()
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/"+shortSuffix, nil))
+		return response
+	}
+
+	t.Run("permanent redirects are cached for a bounded time", func(t *testing.T) {
+		response := redirect(t, googleShortSuffix)
+
+		testutil.AssertStatus(t, response.Code, http.StatusPermanentRedirect)
+		testutil.AssertEqual(t, response.Header().Get("Cache-Control"), "public, max-age=86400")
+	})
+
+	t.Run("a link redirects with its own status", func(t *testing.T) {
+		response := redirect(t, "temporary")
+
+		testutil.AssertStatus(t, response.Code, http.StatusFound)
+		testutil.AssertEqual(t, response.Header().Get("Cache-Control"), "no-store")
+	})
+
+	t.Run("links without a status use the configured one", func(t *testing.T) {
+		testutil.AssertNoError(t, shortenerServer.SetRedirectStatus(http.StatusTemporaryRedirect))
+		t.Cleanup(func() { shortenerServer.SetRedirectStatus(server.DefaultRedirectStatus) })
+
+		testutil.AssertStatus(t, redirect(t, googleShortSuffix).Code, http.StatusTemporaryRedirect)
+		testutil.AssertStatus(t, redirect(t, "temporary").Code, http.StatusFound)
+	})
+
+	t.Run("rejects statuses that aren't redirects", func(t *testing.T) {
+		for _, status := range []int{http.StatusOK, http.StatusSeeOther, http.StatusNotModified} {
+			testutil.AssertError(t, shortenerServer.SetRedirectStatus(status))
+		}
+	})
+
+	t.Run("links are created with a status", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newLinkRequest(`{"baseURL":"https://github.com","redirectStatus":301}`))
+
+		testutil.AssertStatus(t, response.Code, http.StatusCreated)
+		testutil.AssertEqual(t, decodeLink(t, response).RedirectStatus, http.StatusMovedPermanently)
+		testutil.AssertStatus(t, redirect(t, githubShortSuffix).Code, http.StatusMovedPermanently)
+	})
+
+	t.Run("the form takes a status", func(t *testing.T) {
+		urlStore.Delete(githubShortSuffix) // the shortener hands out the same suffix again
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("url=https%3A%2F%2Fgithub.com&redirect-status=307"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertStatus(t, redirect(t, githubShortSuffix).Code, http.StatusTemporaryRedirect)
+	})
+
+	t.Run("rejects an invalid status at creation", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newLinkRequest(`{"baseURL":"https://github.com","redirectStatus":303}`))
+		testutil.AssertStatus(t, response.Code, http.StatusBadRequest)
+
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("url=https%3A%2F%2Fgithub.com&redirect-status=200"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response = httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+		testutil.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+}
+
+func TestServer_LinkPreview(t *testing.T) {
+	urlStore := memoryStore.NewInMemoryURLStore()
+	urlStore.Save(&model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: "https://google.com/search?q=go", Metadata: map[string]string{
+		model.MetadataCreatedAt: "2024-03-01T12:00:00Z",
+		model.MetadataClicks:    "10",
+	}})
+	urlStore.Save(&model.URLPair{ShortSuffix: githubShortSuffix, BaseURL: "github.com"})
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{})
+	testutil.AssertNoError(t, shortenerServer.SetDomain("https://sho.rt/"))
+
+	preview := func(t *testing.T, shortSuffix, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, "/"+shortSuffix+server.PreviewSuffix, nil)
+		request.Header.Set("Accept", accept)
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("redirects count clicks", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			response := httptest.NewRecorder()
+			shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/"+googleShortSuffix, nil))
+			testutil.AssertStatus(t, response.Code, http.StatusPermanentRedirect)
+		}
+		clicks, err := urlStore.Clicks(googleShortSuffix)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, clicks, uint64(2))
+	})
+
+	t.Run("browsers get a page instead of a redirect", func(t *testing.T) {
+		response := preview(t, googleShortSuffix, "text/html")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertContentType(t, response, server.HtmxResponseContentType)
+		for _, want := range []string{"https://google.com/search?q=go", "leads to google.com", "March 1, 2024", "Clicked 12 times", "data:image/png;base64,"} {
+			if !strings.Contains(response.Body.String(), want) {
+				t.Errorf("expected %q in the preview, got %q", want, response.Body.String())
+			}
+		}
+	})
+
+	t.Run("API clients get JSON", func(t *testing.T) {
+		response := preview(t, googleShortSuffix, server.JsonContentType)
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertContentType(t, response, server.JsonContentType)
+		var got model.LinkPreview
+		testutil.AssertNoError(t, json.NewDecoder(response.Body).Decode(&got))
+		testutil.AssertEqual(t, got.ShortURL, "https://sho.rt/"+googleShortSuffix)
+		testutil.AssertEqual(t, got.BaseURL, "https://google.com/search?q=go")
+		testutil.AssertEqual(t, got.Domain, "google.com")
+		testutil.AssertEqual(t, got.Clicks, uint64(12))
+		testutil.AssertEqual(t, got.CreatedAt.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)), true)
+		if !bytes.HasPrefix(got.QRCode, []byte("\x89PNG")) {
+			t.Errorf("expected a PNG QR code")
+		}
+	})
+
+	t.Run("previewing doesn't count a click", func(t *testing.T) {
+		clicks, _ := urlStore.Clicks(googleShortSuffix)
+		testutil.AssertEqual(t, clicks, uint64(2))
+	})
+
+	t.Run("destinations saved without a scheme have a domain", func(t *testing.T) {
+		response := preview(t, githubShortSuffix, server.JsonContentType)
+
+		var got model.LinkPreview
+		testutil.AssertNoError(t, json.NewDecoder(response.Body).Decode(&got))
+		testutil.AssertEqual(t, got.Domain, "github.com")
+		if got.CreatedAt != nil {
+			t.Errorf("expected no creation date, got %v", got.CreatedAt)
+		}
+	})
+
+	t.Run("returns 404 for unknown links", func(t *testing.T) {
+		testutil.AssertStatus(t, preview(t, doesNotExistShortSuffix, "text/html").Code, http.StatusNotFound)
+		testutil.AssertStatus(t, preview(t, doesNotExistShortSuffix, server.JsonContentType).Code, http.StatusNotFound)
+	})
+
+	t.Run("reserved paths aren't previewed", func(t *testing.T) {
+		testutil.AssertStatus(t, preview(t, "api", "text/html").Code, http.StatusNotFound)
+	})
+}
+
+func TestServer_Forwarding(t *testing.T) {
+	urlStore := memoryStore.NewInMemoryURLStore()
+	urlStore.Save(&model.URLPair{ShortSuffix: "docs", BaseURL: "https://example.com/docs/", Metadata: map[string]string{model.MetadataForwardPath: "true"}})
+	urlStore.Save(&model.URLPair{ShortSuffix: "news", BaseURL: "https://example.com/news?utm_source=short&lang=en", Metadata: map[string]string{model.MetadataForwardQuery: "true"}})
+	urlStore.Save(&model.URLPair{ShortSuffix: "both", BaseURL: "https://example.com/api", Metadata: map[string]string{model.MetadataForwardPath: "true", model.MetadataForwardQuery: "true"}})
+	urlStore.Save(&model.URLPair{ShortSuffix: "plain", BaseURL: "https://example.com/plain"})
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{
+		ShortenBaseURLFunc: func(baseURL string) (string, error) {
+			return githubShortSuffix, nil
+		},
+	})
+
+	cases := []struct {
+		name     string
+		path     string
+		status   int
+		location string
+	}{
+		{"appends the path following the suffix", "/docs/guide/install", http.StatusPermanentRedirect, "https://example.com/docs/guide/install"},
+		{"escapes the forwarded path", "/docs/a%20b", http.StatusPermanentRedirect, "https://example.com/docs/a%20b"},
+		{"redirects to the base URL without a path", "/docs", http.StatusPermanentRedirect, "https://example.com/docs/"},
+		{"merges the query string", "/news?utm_source=newsletter&page=2", http.StatusPermanentRedirect, "https://example.com/news?lang=en&utm_source=newsletter&page=2"},
+		{"forwards both", "/both/v1/users?limit=10", http.StatusPermanentRedirect, "https://example.com/api/v1/users?limit=10"},
+		{"drops the query string of other links", "/plain?utm_source=newsletter", http.StatusPermanentRedirect, "https://example.com/plain"},
+		{"doesn't forward paths of other links", "/plain/extra", http.StatusNotFound, ""},
+		{"doesn't forward paths of links forwarding the query string", "/news/extra", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, c.path, nil))
+
+			testutil.AssertStatus(t, response.Code, c.status)
+			testutil.AssertEqual(t, response.Header().Get("Location"), c.location)
+		})
+	}
+
+	t.Run("?info prints the destination without itself", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/both/v1?info&limit=10", nil))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertResponseBody(t, response.Body.String(), "https://example.com/api/v1?limit=10\n")
+	})
+
+	t.Run("links are created forwarding", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newLinkRequest(`{"baseURL":"https://github.com","forwardPath":true,"forwardQuery":true}`))
+
+		testutil.AssertStatus(t, response.Code, http.StatusCreated)
+		link := decodeLink(t, response)
+		testutil.AssertEqual(t, link.ForwardPath, true)
+		testutil.AssertEqual(t, link.ForwardQuery, true)
+
+		response = httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/"+githubShortSuffix+"/golang/go?tab=readme", nil))
+		testutil.AssertEqual(t, response.Header().Get("Location"), "https://github.com/golang/go?tab=readme")
+	})
+
+	t.Run("the form takes the options as checkboxes", func(t *testing.T) {
+		urlStore.Delete(githubShortSuffix) // the shortener hands out the same suffix again
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("url=https%3A%2F%2Fgithub.com&forward-path=on"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+
+		response = httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/"+githubShortSuffix+"/golang?tab=readme", nil))
+		testutil.AssertEqual(t, response.Header().Get("Location"), "https://github.com/golang")
+	})
+
+	t.Run("rejects an option that isn't true or false", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("url=https%3A%2F%2Fgithub.com&forward-query=maybe"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+		testutil.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+}
+
+func TestServer_PlatformDestinations(t *testing.T) {
+	const (
+		appStore  = "https://apps.apple.com/app/id123"
+		playStore = "https://play.google.com/store/apps/details?id=com.example"
+		web       = "https://example.com/app"
+	)
+	urlStore := memoryStore.NewInMemoryURLStore()
+	urlStore.Save(&model.URLPair{ShortSuffix: "app", BaseURL: web, Metadata: map[string]string{
+		model.MetadataDestinationPrefix + model.PlatformIOS:     appStore,
+		model.MetadataDestinationPrefix + model.PlatformAndroid: playStore,
+		model.MetadataDestinationPrefix + model.PlatformBot:     web + "?bot",
+	}})
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{
+		ShortenBaseURLFunc: func(baseURL string) (string, error) {
+			return githubShortSuffix, nil
+		},
+	})
+
+	cases := []struct {
+		name      string
+		userAgent string
+		location  string
+	}{
+		{"iPhones go to the App Store", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", appStore},
+		{"iPads go to the App Store", "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148", appStore},
+		{"Android goes to the Play Store", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Mobile Safari/537.36", playStore},
+		{"bots get their own destination", "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", web + "?bot"},
+		{"desktops fall back to the base URL", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36", web},
+		{"unknown clients fall back to the base URL", "", web},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/app", nil)
+			request.Header.Set("User-Agent", c.userAgent)
+			response := httptest.NewRecorder()
+			shortenerServer.ServeHTTP(response, request)
+
+			testutil.AssertStatus(t, response.Code, http.StatusPermanentRedirect)
+			testutil.AssertEqual(t, response.Header().Get("Location"), c.location)
+			if !strings.Contains(strings.Join(response.Header().Values("Vary"), ","), "User-Agent") {
+				t.Errorf("expected Vary: User-Agent, got %v", response.Header().Values("Vary"))
+			}
+		})
+	}
+
+	t.Run("links are created with destinations", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newLinkRequest(`{"baseURL":"https://example.com","destinations":{"ios":"`+appStore+`","android":"`+playStore+`"}}`))
+
+		testutil.AssertStatus(t, response.Code, http.StatusCreated)
+		link := decodeLink(t, response)
+		testutil.AssertEqual(t, link.Destinations[model.PlatformIOS], appStore)
+		testutil.AssertEqual(t, link.Destinations[model.PlatformAndroid], playStore)
+	})
+
+	t.Run("rejects unknown platforms, relative destinations and other schemes", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newLinkRequest(`{"baseURL":"https://example.com","destinations":{"windows":"https://example.com"}}`))
+		testutil.AssertStatus(t, response.Code, http.StatusBadRequest)
+
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("url=https%3A%2F%2Fexample.com&destination-ios=apps.apple.com"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response = httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+		testutil.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+
+		for _, destination := range []string{"javascript:alert(1)", "data:text/html,hi", "market://details?id=com.example"} {
+			response = httptest.NewRecorder()
+			shortenerServer.ServeHTTP(response, newLinkRequest(`{"baseURL":"https://example.com","destinations":{"android":"`+destination+`"}}`))
+			testutil.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		}
+	})
+}
+
+func TestServer_Variants(t *testing.T) {
+	const (
+		web     = "https://example.com"
+		landing = "https://example.com/landing"
+		promo   = "https://example.com/promo"
+	)
+	urlStore := memoryStore.NewInMemoryURLStore()
+	saveWithVariants := func(shortSuffix string, sticky bool, variants ...model.Variant) {
+		t.Helper()
+		encoded, err := model.EncodeVariants(variants)
+		testutil.AssertNoError(t, err)
+		metadata := map[string]string{model.MetadataVariants: encoded}
+		if sticky {
+			metadata[model.MetadataStickyVariant] = "true"
+		}
+		testutil.AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: shortSuffix, BaseURL: web, Metadata: metadata}))
+	}
+	// paused variants make the choice predictable
+	saveWithVariants("split", false, model.Variant{Name: "a", URL: landing, Weight: 0}, model.Variant{Name: "b", URL: promo, Weight: 1})
+	saveWithVariants("sticky", true, model.Variant{Name: "a", URL: landing, Weight: 1}, model.Variant{Name: "b", URL: promo, Weight: 1})
+	saveWithVariants("paused", true, model.Variant{Name: "a", URL: landing, Weight: 0}, model.Variant{Name: "b", URL: promo, Weight: 1})
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{
+		ShortenBaseURLFunc: func(baseURL string) (string, error) {
+			return githubShortSuffix, nil
+		},
+	})
+	redirect := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("redirects to a variant by weight without caching", func(t *testing.T) {
+		response := redirect("/split")
+
+		testutil.AssertStatus(t, response.Code, http.StatusPermanentRedirect)
+		testutil.AssertEqual(t, response.Header().Get("Location"), promo)
+		testutil.AssertEqual(t, response.Header().Get("Cache-Control"), "no-store")
+		testutil.AssertEqual(t, len(response.Result().Cookies()), 0)
+	})
+
+	t.Run("info shows the base URL", func(t *testing.T) {
+		response := redirect("/split?info")
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		testutil.AssertResponseBody(t, response.Body.String(), web+"\n")
+	})
+
+	t.Run("sticks a visitor to their variant", func(t *testing.T) {
+		response := redirect("/sticky")
+
+		cookies := response.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != server.VariantCookie {
+			t.Fatalf("expected a %v cookie, got %v", server.VariantCookie, cookies)
+		}
+		testutil.AssertEqual(t, cookies[0].Path, "/sticky")
+		location := response.Header().Get("Location")
+		want := map[string]string{"a": landing, "b": promo}[cookies[0].Value]
+		testutil.AssertEqual(t, location, want)
+
+		for range 10 {
+			response = redirect("/sticky", cookies[0])
+			testutil.AssertEqual(t, response.Header().Get("Location"), location)
+			testutil.AssertEqual(t, len(response.Result().Cookies()), 0)
+		}
+	})
+
+	t.Run("moves visitors of a paused variant", func(t *testing.T) {
+		response := redirect("/paused", &http.Cookie{Name: server.VariantCookie, Value: "a"})
+
+		testutil.AssertEqual(t, response.Header().Get("Location"), promo)
+		cookies := response.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Value != "b" {
+			t.Errorf("expected the cookie to move to variant b, got %v", cookies)
+		}
+	})
+
+	t.Run("reports clicks per variant", func(t *testing.T) {
+		for range 2 {
+			redirect("/split")
+		}
+		request := httptest.NewRequest(http.MethodGet, server.APIv2LinksRoute+"/split", nil)
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		link := decodeLink(t, response)
+		// one more from the first subtest
+		testutil.AssertEqual(t, link.Clicks, uint64(3))
+		testutil.AssertEqual(t, len(link.Variants), 2)
+		testutil.AssertEqual(t, link.Variants[0].Clicks, uint64(0))
+		testutil.AssertEqual(t, link.Variants[1].Name, "b")
+		testutil.AssertEqual(t, link.Variants[1].Clicks, uint64(3))
+	})
+
+	t.Run("platform destinations win over variants", func(t *testing.T) {
+		encoded, err := model.EncodeVariants([]model.Variant{{Name: "a", URL: landing, Weight: 1}})
+		testutil.AssertNoError(t, err)
+		testutil.AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "app", BaseURL: web, Metadata: map[string]string{
+			model.MetadataVariants:                              encoded,
+			model.MetadataDestinationPrefix + model.PlatformIOS: "https://apps.apple.com/app/id123",
+		}}))
+
+		request := httptest.NewRequest(http.MethodGet, "/app", nil)
+		request.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X)")
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, request)
+		testutil.AssertEqual(t, response.Header().Get("Location"), "https://apps.apple.com/app/id123")
+
+		testutil.AssertEqual(t, redirect("/app").Header().Get("Location"), landing)
+
+		clicks, err := urlStore.Clicks("app")
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, clicks, uint64(2))
+		variantClicks, err := urlStore.VariantClicks("app")
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, variantClicks["a"], uint64(1))
+	})
+
+	t.Run("links are created with variants", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newLinkRequest(`{"variants":[{"url":"`+landing+`","weight":70},{"url":"`+promo+`","weight":30}],"stickyVariant":true}`))
+
+		testutil.AssertStatus(t, response.Code, http.StatusCreated)
+		link := decodeLink(t, response)
+		testutil.AssertEqual(t, link.BaseURL, landing)
+		testutil.AssertEqual(t, link.StickyVariant, true)
+		testutil.AssertEqual(t, len(link.Variants), 2)
+		testutil.AssertEqual(t, link.Variants[0].Variant, model.Variant{Name: "a", URL: landing, Weight: 70})
+		testutil.AssertEqual(t, link.Variants[1].Variant, model.Variant{Name: "b", URL: promo, Weight: 30})
+	})
+
+	t.Run("rejects invalid variants", func(t *testing.T) {
+		for _, body := range []string{
+			`{"variants":[{"url":"` + landing + `","weight":0}]}`,
+			`{"variants":[{"url":"` + landing + `","weight":-1},{"url":"` + promo + `","weight":2}]}`,
+			`{"variants":[{"url":"example.com/landing","weight":1}]}`,
+			`{"variants":[{"url":"javascript:alert(1)","weight":1}]}`,
+			`{"variants":[{"url":"` + landing + `","weight":9223372036854775807},{"url":"` + promo + `","weight":9223372036854775807}]}`,
+			`{"variants":[{"name":"a b","url":"` + landing + `","weight":1}]}`,
+			`{"baseURL":"` + web + `","stickyVariant":true}`,
+		} {
+			response := httptest.NewRecorder()
+			shortenerServer.ServeHTTP(response, newLinkRequest(body))
+			testutil.AssertStatus(t, response.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func newLinkRequest(body string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, server.APIv2LinksRoute, strings.NewReader(body))
+	request.Header.Set("Content-Type", server.JsonContentType)
+	return request
+}
+
+func decodeLink(t testing.TB, response *httptest.ResponseRecorder) server.Link {
+	t.Helper()
+	var link server.Link
+	if err := json.NewDecoder(response.Body).Decode(&link); err != nil {
+		t.Fatalf("unable to decode link %q, %v", response.Body.String(), err)
+	}
+	return link
+}
+
+func TestAPIv2_Links(t *testing.T) {
+	urlStore := memoryStore.NewInMemoryURLStore()
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{
+		ShortenBaseURLFunc: func(baseURL string) (string, error) {
+			return githubShortSuffix, nil
+		},
+	})
+	testutil.AssertNoError(t, shortenerServer.SetDomain("https://sho.rt/"))
+	selfURL := "https://sho.rt/api/v2/links/" + githubShortSuffix
+
+	t.Run("creates a link with its complete short URL", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newLinkRequest(`{"baseURL":"https://github.com","expiresAt":"`+expiresAt.Format(time.RFC3339)+`"}`))
+
+		testutil.AssertStatus(t, response.Code, http.StatusCreated)
+		testutil.AssertContentType(t, response, server.JsonContentType)
+		testutil.AssertEqual(t, response.Header().Get("Location"), selfURL)
+		link := decodeLink(t, response)
+		testutil.AssertEqual(t, link.ID, githubShortSuffix)
+		testutil.AssertEqual(t, link.ShortURL, "https://sho.rt/"+githubShortSuffix)
+		testutil.AssertEqual(t, link.BaseURL, "https://github.com")
+		testutil.AssertEqual(t, link.Links.Self, selfURL)
+		if link.CreatedAt == nil || time.Since(*link.CreatedAt) > time.Minute {
+			t.Errorf("expected a recent createdAt, got %v", link.CreatedAt)
+		}
+		if link.ExpiresAt == nil || !link.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("expected expiresAt %v, got %v", expiresAt, link.ExpiresAt)
+		}
+	})
+
+	t.Run("gets a link at its self URL", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, server.APIv2LinksRoute+"/"+githubShortSuffix, nil))
+
+		testutil.AssertStatus(t, response.Code, http.StatusOK)
+		link := decodeLink(t, response)
+		testutil.AssertEqual(t, link.ShortURL, "https://sho.rt/"+githubShortSuffix)
+		if link.CreatedAt == nil {
+			t.Errorf("expected createdAt on a stored link")
+		}
+	})
+
+	t.Run("returns 404 for unknown links", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, httptest.NewRequest(http.MethodGet, server.APIv2LinksRoute+"/"+doesNotExistShortSuffix, nil))
+
+		testutil.AssertStatus(t, response.Code, http.StatusNotFound)
+		testutil.AssertEqual(t, decodeProblem(t, response).Code, server.ProblemNotFound)
+	})
+
+	t.Run("rejects an expiry in the past", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newLinkRequest(`{"baseURL":"https://github.com","expiresAt":"2000-01-01T00:00:00Z"}`))
+
+		testutil.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("rejects an empty base URL", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		shortenerServer.ServeHTTP(response, newLinkRequest(`{"baseURL":""}`))
+
+		testutil.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		testutil.AssertEqual(t, decodeProblem(t, response).Code, server.ProblemInvalidURL)
+	})
+}
+
+func TestServer_SuffixTaken(t *testing.T) {
+	urlStore := memoryStore.NewInMemoryURLStore()
+	urlStore.Save(&model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: "https://google.com"})
+	urlStore.Save(&model.URLPair{ShortSuffix: githubShortSuffix, BaseURL: "https://github.com"})
+	_, err := urlStore.Disable(githubShortSuffix, model.DisabledForAbuse)
+	testutil.AssertNoError(t, err)
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{})
+
+	testutil.AssertEqual(t, shortenerServer.SuffixTaken(googleShortSuffix), true)
+	testutil.AssertEqual(t, shortenerServer.SuffixTaken(githubShortSuffix), true)
+	testutil.AssertEqual(t, shortenerServer.SuffixTaken("api"), true)
+	testutil.AssertEqual(t, shortenerServer.SuffixTaken(doesNotExistShortSuffix), false)
+}
+
+// ClaimingURLStore lets one claim of each suffix through, like a store shared
+// with other servers.
+type ClaimingURLStore struct {
+	*memoryStore.InMemoryURLStore
+	claimed map[string]bool
+}
+
+func (c *ClaimingURLStore) ClaimSuffix(shortSuffix string) (bool, error) {
+	if c.claimed[shortSuffix] {
+		return false, nil
+	}
+	c.claimed[shortSuffix] = true
+	return true, nil
+}
+
+func TestServer_ShortenSkipsTakenSuffixes(t *testing.T) {
+	urlStore := &ClaimingURLStore{InMemoryURLStore: memoryStore.NewInMemoryURLStore(), claimed: map[string]bool{"0000003": true}}
+	urlStore.Save(&model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: "https://google.com"})
+	suffixes := []string{googleShortSuffix, "0000003", githubShortSuffix}
+	shortenerServer := server.NewURLShortenerServer(urlStore, MockURLShortener{
+		ShortenBaseURLFunc: func(baseURL string) (string, error) {
+			suffix := suffixes[0]
+			suffixes = suffixes[1:]
+			return suffix, nil
+		},
+	})
+
+	response := httptest.NewRecorder()
+	shortenerServer.ServeHTTP(response, testutil.NewPostAPIShortenURLRequest("https://github.com"))
+
+	testutil.AssertStatus(t, response.Code, http.StatusOK)
+	testutil.AssertEqual(t, testutil.GetURLPairFromResponse(t, response.Body).ShortSuffix, githubShortSuffix)
+	baseURL, _ := urlStore.Load(googleShortSuffix)
+	testutil.AssertEqual(t, baseURL, "https://google.com")
+}
+
+// Concurrency
+func TestConcurrent_POST_ShortenURL(t *testing.T) {
+	store := StubURLStore{urlMap: map[string]string{}}
 
 	createCount := 1000
 	var wg sync.WaitGroup
@@ -416,13 +1609,13 @@ func TestConcurrent_POST_ShortenURL(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			response := httptest.NewRecorder()
-			request := testutil.NewPostAPIShortenURLRequest(store.urlMap[googleShortSuffix])
+			request := testutil.NewPostAPIShortenURLRequest("google.com")
 			shortenerServer.ServeHTTP(response, request)
 
 			testutil.AssertStatus(t, response.Code, http.StatusOK)
 			gotPair := testutil.GetURLPairFromResponse(t, response.Body)
 			testutil.AssertContentType(t, response, server.JsonContentType)
-			assertURLPairs(t, gotPair, model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: store.urlMap[googleShortSuffix], Domain: shortenerServer.GetDomain()})
+			assertURLPairs(t, gotPair, model.URLPair{ShortSuffix: googleShortSuffix, BaseURL: "google.com", Domain: shortenerServer.GetDomain()})
 		}()
 	}
 	wg.Wait()
@@ -435,7 +1628,6 @@ func TestConcurrent_CreateAndGetShortURL(t *testing.T) {
 	store := StubURLStore{
 		urlMap: map[string]string{
 			googleShortSuffix: "google.com",
-			githubShortSuffix: "github.com",
 		},
 	}
 
@@ -476,11 +1668,11 @@ func TestConcurrent_CreateAndGetShortURL(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			response := httptest.NewRecorder()
-			request := testutil.NewPostAPIShortenURLRequest(store.urlMap[githubShortSuffix])
+			request := testutil.NewPostAPIShortenURLRequest("github.com")
 			shortenerServer.ServeHTTP(response, request)
 			urlPair := testutil.GetURLPairFromResponse(t, response.Body)
 			testutil.AssertContentType(t, response, server.JsonContentType)
-			assertURLPairs(t, urlPair, model.URLPair{ShortSuffix: githubShortSuffix, BaseURL: store.urlMap[githubShortSuffix], Domain: shortenerServer.GetDomain()})
+			assertURLPairs(t, urlPair, model.URLPair{ShortSuffix: githubShortSuffix, BaseURL: "github.com", Domain: shortenerServer.GetDomain()})
 
 			testutil.AssertStatus(t, response.Code, http.StatusOK)
 		}()
@@ -491,8 +1683,9 @@ func TestConcurrent_CreateAndGetShortURL(t *testing.T) {
 		t.Errorf("expected %d calls to create short url but got %d", createCount, len(store.shortURLCalls))
 	}
 
-	if len(store.getURLCalls) != requestCount {
-		t.Errorf("expected %d calls to get base url but got %d calls", requestCount, len(store.shortURLCalls))
+	// every create checks its suffix isn't taken
+	if len(store.getURLCalls) != requestCount+createCount {
+		t.Errorf("expected %d calls to get base url but got %d calls", requestCount+createCount, len(store.getURLCalls))
 	}
 }
 
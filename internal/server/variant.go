@@ -0,0 +1,70 @@
+package server
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+)
+
+// VariantCookie remembers the variant a visitor got for links that stick to
+// it, it is scoped to the path of the link.
+const VariantCookie = "variant"
+
+const variantCookieMaxAge = 30 * 24 * time.Hour
+
+// chooseVariant picks the variant a click on the link goes to, false for
+// links without variants. A destination for the platform of the client wins
+// over the variants, such clients get no variant either and their clicks
+// count towards none. Links that stick to a variant send a visitor with the
+// cookie back to their variant unless it was paused, and give everyone else
+// the cookie.
+func chooseVariant(w http.ResponseWriter, r *http.Request, urlPair model.URLPair) (model.Variant, bool) {
+	variants := urlPair.Variants()
+	if len(variants) == 0 {
+		return model.Variant{}, false
+	}
+	if _, ok := urlPair.PlatformDestination(clientPlatform(r.UserAgent())); ok {
+		return model.Variant{}, false
+	}
+	if !urlPair.SticksToVariant() {
+		return pickVariant(variants), true
+	}
+
+	if cookie, err := r.Cookie(VariantCookie); err == nil {
+		for _, variant := range variants {
+			if variant.Name == cookie.Value && variant.Weight > 0 {
+				return variant, true
+			}
+		}
+	}
+	variant := pickVariant(variants)
+	http.SetCookie(w, &http.Cookie{
+		Name:     VariantCookie,
+		Value:    variant.Name,
+		Path:     "/" + url.PathEscape(urlPair.ShortSuffix),
+		MaxAge:   int(variantCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return variant, true
+}
+
+// pickVariant picks a variant with a chance of its weight over the total
+// weight, variants are validated to have a total weight above 0.
+func pickVariant(variants []model.Variant) model.Variant {
+	total := 0
+	for _, variant := range variants {
+		total += variant.Weight
+	}
+	n := rand.IntN(total)
+	for _, variant := range variants {
+		if n < variant.Weight {
+			return variant
+		}
+		n -= variant.Weight
+	}
+	return variants[len(variants)-1]
+}
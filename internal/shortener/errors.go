@@ -1,6 +1,17 @@
 package shortener
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidURL matches every InvalidURLError.
+	ErrInvalidURL = errors.New("invalid url")
+	// ErrSuffixesExhausted is returned when no free short suffix is left to
+	// hand out, ExceedCounterError matches it.
+	ErrSuffixesExhausted = errors.New("no free short suffix")
+)
 
 type InvalidURLError struct {
 	ErrorMsg     string
@@ -10,3 +21,7 @@ type InvalidURLError struct {
 func (i InvalidURLError) Error() string {
 	return fmt.Sprintf("invalid url %s, %v", i.ErrorMsg, i.SubmittedURL)
 }
+
+func (i InvalidURLError) Is(target error) bool {
+	return target == ErrInvalidURL
+}
@@ -0,0 +1,195 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+const (
+	defaultPoolSize         = 1000
+	defaultPoolLowWatermark = 250
+
+	// maxPoolAttempts bounds how many taken suffixes are skipped for one
+	// suffix, so a generator stuck on used suffixes doesn't spin forever.
+	maxPoolAttempts = 100
+)
+
+type PoolConfig struct {
+	size         int
+	lowWatermark int
+}
+
+func NewDefaultPoolConfig() *PoolConfig {
+	return &PoolConfig{
+		size:         defaultPoolSize,
+		lowWatermark: defaultPoolLowWatermark,
+	}
+}
+
+func (c *PoolConfig) Size() int {
+	return c.size
+}
+
+func (c *PoolConfig) LowWatermark() int {
+	return c.lowWatermark
+}
+
+func (c *PoolConfig) SetSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("invalid pool size %d, must be at least 1", size)
+	}
+	if c.lowWatermark >= size {
+		c.lowWatermark = size / 4
+	}
+	c.size = size
+	return nil
+}
+
+// SetLowWatermark sets the depth at which the pool is refilled, it must be
+// below the pool size.
+func (c *PoolConfig) SetLowWatermark(lowWatermark int) error {
+	if lowWatermark < 0 || lowWatermark >= c.size {
+		return fmt.Errorf("invalid low watermark %d, must be between 0 and %d", lowWatermark, c.size-1)
+	}
+	c.lowWatermark = lowWatermark
+	return nil
+}
+
+type PoolStats struct {
+	Depth        int    `json:"depth"`
+	Size         int    `json:"size"`
+	LowWatermark int    `json:"lowWatermark"`
+	Refills      uint64 `json:"refills"`
+	Generated    uint64 `json:"generated"`
+	Skipped      uint64 `json:"skipped"`
+	Served       uint64 `json:"served"`
+	Misses       uint64 `json:"misses"`
+}
+
+// SuffixPool keeps suffixes generated ahead of time so shortening a URL only
+// takes one off a channel. Every suffix is checked with the taken func before
+// it enters the pool, suffixes already used by a link are skipped. A worker
+// started with Run refills the pool whenever its depth drops to the low
+// watermark, when the pool runs dry a suffix is generated inline instead.
+//
+// Pooled suffixes are only checked when they are generated: a link saved
+// under one of them by someone else before it is handed out, e.g. by an
+// import, isn't noticed, so callers check and claim a suffix again before
+// using it. Suffixes still pooled when the process stops are never used.
+type SuffixPool struct {
+	config   *PoolConfig
+	generate func() (string, error)
+	taken    func(shortSuffix string) bool
+	suffixes chan string
+	refill   chan struct{}
+
+	refills   atomic.Uint64
+	generated atomic.Uint64
+	skipped   atomic.Uint64
+	served    atomic.Uint64
+	misses    atomic.Uint64
+}
+
+func NewSuffixPool(generate func() (string, error), config *PoolConfig) *SuffixPool {
+	if config == nil {
+		config = NewDefaultPoolConfig()
+	}
+	return &SuffixPool{
+		config:   config,
+		generate: generate,
+		suffixes: make(chan string, config.size),
+		refill:   make(chan struct{}, 1),
+	}
+}
+
+// SetTakenCheck sets how the pool finds out a suffix is already used, it has
+// to be set before Run.
+func (p *SuffixPool) SetTakenCheck(taken func(shortSuffix string) bool) {
+	p.taken = taken
+}
+
+// Run fills the pool, then refills it whenever it is signalled to, until ctx
+// is done.
+func (p *SuffixPool) Run(ctx context.Context) {
+	for {
+		p.fill(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.refill:
+		}
+	}
+}
+
+func (p *SuffixPool) fill(ctx context.Context) {
+	p.refills.Add(1)
+	for len(p.suffixes) < cap(p.suffixes) {
+		if ctx.Err() != nil {
+			return
+		}
+		suffix, err := p.next()
+		if err != nil {
+			log.Printf("error when refilling suffix pool, %v", err)
+			return
+		}
+		p.suffixes <- suffix
+	}
+}
+
+// Pop returns a pooled suffix, or generates one when the pool is empty.
+func (p *SuffixPool) Pop() (string, error) {
+	select {
+	case suffix := <-p.suffixes:
+		p.served.Add(1)
+		if len(p.suffixes) <= p.config.lowWatermark {
+			p.signalRefill()
+		}
+		return suffix, nil
+	default:
+		p.misses.Add(1)
+		p.signalRefill()
+		return p.next()
+	}
+}
+
+func (p *SuffixPool) signalRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// next generates suffixes until one isn't taken.
+func (p *SuffixPool) next() (string, error) {
+	for attempt := 0; attempt < maxPoolAttempts; attempt++ {
+		suffix, err := p.generate()
+		if err != nil {
+			return "", err
+		}
+		p.generated.Add(1)
+		if p.taken == nil || !p.taken(suffix) {
+			return suffix, nil
+		}
+		p.skipped.Add(1)
+	}
+	return "", fmt.Errorf("%w after %d attempts", ErrSuffixesExhausted, maxPoolAttempts)
+}
+
+func (p *SuffixPool) Depth() int {
+	return len(p.suffixes)
+}
+
+func (p *SuffixPool) Stats() PoolStats {
+	return PoolStats{
+		Depth:        len(p.suffixes),
+		Size:         p.config.size,
+		LowWatermark: p.config.lowWatermark,
+		Refills:      p.refills.Load(),
+		Generated:    p.generated.Load(),
+		Skipped:      p.skipped.Load(),
+		Served:       p.served.Load(),
+		Misses:       p.misses.Load(),
+	}
+}
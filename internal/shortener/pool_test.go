@@ -0,0 +1,150 @@
+package shortener_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	shortener "github.com/0xKev/url-shortener/internal/shortener"
+)
+
+func newPoolConfig(t testing.TB, size, lowWatermark int) *shortener.PoolConfig {
+	t.Helper()
+	config := shortener.NewDefaultPoolConfig()
+	assertNoError(t, config.SetSize(size))
+	assertNoError(t, config.SetLowWatermark(lowWatermark))
+	return config
+}
+
+func runPool(t testing.TB, pool *shortener.SuffixPool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go pool.Run(ctx)
+}
+
+func waitForDepth(t testing.TB, pool *shortener.SuffixPool, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for pool.Depth() != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected pool depth %d but got %d", depth, pool.Depth())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSuffixPool(t *testing.T) {
+	t.Run("fills up to its size in the background", func(t *testing.T) {
+		urlShortener, _ := setUpShortener()
+		pool := shortener.NewSuffixPool(urlShortener.NextSuffix, newPoolConfig(t, 10, 2))
+		runPool(t, pool)
+
+		waitForDepth(t, pool, 10)
+		assertEqual(t, pool.Stats().Generated, uint64(10))
+	})
+
+	t.Run("skips suffixes that are taken", func(t *testing.T) {
+		urlShortener, _ := setUpShortener()
+		taken := map[string]bool{}
+		for i := 0; i < 3; i++ {
+			suffix, err := urlShortener.NextSuffix()
+			assertNoError(t, err)
+			taken[suffix] = true
+		}
+		urlShortener.Config.SetURLCounter(500) // as after a restart
+
+		pool := shortener.NewSuffixPool(urlShortener.NextSuffix, newPoolConfig(t, 5, 1))
+		pool.SetTakenCheck(func(shortSuffix string) bool { return taken[shortSuffix] })
+		runPool(t, pool)
+		waitForDepth(t, pool, 5)
+
+		for i := 0; i < 5; i++ {
+			suffix, err := pool.Pop()
+			assertNoError(t, err)
+			if taken[suffix] {
+				t.Fatalf("pool handed out taken suffix %v", suffix)
+			}
+		}
+		assertEqual(t, pool.Stats().Skipped, uint64(3))
+	})
+
+	t.Run("refills once the low watermark is reached", func(t *testing.T) {
+		urlShortener, _ := setUpShortener()
+		pool := shortener.NewSuffixPool(urlShortener.NextSuffix, newPoolConfig(t, 10, 5))
+		runPool(t, pool)
+		waitForDepth(t, pool, 10)
+
+		for i := 0; i < 4; i++ {
+			_, err := pool.Pop()
+			assertNoError(t, err)
+		}
+		assertEqual(t, pool.Stats().Refills, uint64(1))
+
+		_, err := pool.Pop()
+		assertNoError(t, err)
+		waitForDepth(t, pool, 10)
+		assertEqual(t, pool.Stats().Refills, uint64(2))
+	})
+
+	t.Run("generates inline when empty", func(t *testing.T) {
+		urlShortener, _ := setUpShortener()
+		pool := shortener.NewSuffixPool(urlShortener.NextSuffix, newPoolConfig(t, 10, 2))
+
+		suffix, err := pool.Pop()
+		assertNoError(t, err)
+		assertSuffixLength(t, suffix, urlShortener)
+		assertEqual(t, pool.Stats().Misses, uint64(1))
+	})
+
+	t.Run("gives up when every suffix is taken", func(t *testing.T) {
+		urlShortener, _ := setUpShortener()
+		pool := shortener.NewSuffixPool(urlShortener.NextSuffix, newPoolConfig(t, 10, 2))
+		pool.SetTakenCheck(func(string) bool { return true })
+
+		_, err := pool.Pop()
+		assertError(t, err)
+	})
+
+	t.Run("returns the counter limit error", func(t *testing.T) {
+		urlShortener, _ := setUpShortener()
+		urlShortener.Config.SetURLCounter(urlShortener.Config.URLCounterLimit())
+		pool := shortener.NewSuffixPool(urlShortener.NextSuffix, newPoolConfig(t, 10, 2))
+
+		_, err := pool.Pop()
+		var exceeded shortener.ExceedCounterError
+		assertEqual(t, errors.As(err, &exceeded), true)
+	})
+
+	t.Run("rejects a low watermark outside the pool", func(t *testing.T) {
+		config := shortener.NewDefaultPoolConfig()
+		assertError(t, config.SetSize(0))
+		assertNoError(t, config.SetSize(10))
+		assertError(t, config.SetLowWatermark(10))
+		assertError(t, config.SetLowWatermark(-1))
+	})
+}
+
+func TestShortenURLWithSuffixPool(t *testing.T) {
+	urlShortener, _ := setUpShortener()
+	pool := shortener.NewSuffixPool(urlShortener.NextSuffix, newPoolConfig(t, 50, 10))
+	urlShortener.SetSuffixPool(pool)
+	runPool(t, pool)
+	waitForDepth(t, pool, 50)
+
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		suffix, err := urlShortener.ShortenURL(fmt.Sprintf("example%d.com", i))
+		assertNoError(t, err)
+		assertSuffixLength(t, suffix, urlShortener)
+		if seen[suffix] {
+			t.Fatalf("suffix %v handed out twice", suffix)
+		}
+		seen[suffix] = true
+	}
+
+	_, err := urlShortener.ShortenURL("")
+	assertError(t, err)
+}
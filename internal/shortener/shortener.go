@@ -28,6 +28,10 @@ func (e ExceedCounterError) Error() string {
 	return fmt.Sprintf(ErrCounterLimitReached+"current count %d, max count %d", e.CurrentCounter, e.MaxCounter)
 }
 
+func (e ExceedCounterError) Is(target error) bool {
+	return target == ErrSuffixesExhausted
+}
+
 type ShortURLNotFoundError struct {
 	ShortURL string
 }
@@ -70,6 +74,7 @@ type URLShortener struct {
 	Config  *Config
 	mu      sync.Mutex
 	encoder Encoder
+	pool    *SuffixPool
 }
 
 func NewURLShortener(config *Config, encoder Encoder) *URLShortener {
@@ -82,7 +87,20 @@ func NewURLShortener(config *Config, encoder Encoder) *URLShortener {
 	}
 }
 
+// SetSuffixPool makes ShortenURL take suffixes from pool, which should be
+// generating them with NextSuffix.
+func (u *URLShortener) SetSuffixPool(pool *SuffixPool) {
+	u.pool = pool
+}
+
 func (u *URLShortener) ShortenURL(baseURL string) (string, error) {
+	if u.pool != nil {
+		if err := u.validateURL(baseURL); err != nil {
+			return "", err
+		}
+		return u.pool.Pop()
+	}
+
 	u.mu.Lock()
 	defer u.mu.Unlock()
 
@@ -99,6 +117,18 @@ func (u *URLShortener) ShortenURL(baseURL string) (string, error) {
 	return u.generateShortSuffix(), nil
 }
 
+// NextSuffix generates a suffix without a URL to shorten, for filling a
+// SuffixPool.
+func (u *URLShortener) NextSuffix() (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if over, err := u.isOverCounterLimit(); over {
+		return "", err
+	}
+	return u.generateShortSuffix(), nil
+}
+
 func (u *URLShortener) isOverCounterLimit() (bool, error) {
 	if u.Config.urlCounter >= u.Config.urlCounterLimit {
 		return true, ExceedCounterError{
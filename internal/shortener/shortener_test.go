@@ -84,6 +84,9 @@ func TestShortenURL(t *testing.T) {
 			if !errors.Is(err, c.expectedErr) {
 				t.Fatalf("expected error %v, but got %v", c.expectedErr.Error(), err)
 			}
+			if !errors.Is(err, shortener.ErrInvalidURL) {
+				t.Fatalf("expected %v to match ErrInvalidURL", err)
+			}
 		}
 	})
 
@@ -127,6 +130,9 @@ func TestShortenURL(t *testing.T) {
 				if !errors.As(err, &wantError) {
 					t.Fatalf("expected error %v but did not get one", c.expectedError)
 				}
+				if !errors.Is(err, shortener.ErrSuffixesExhausted) {
+					t.Fatalf("expected %v to match ErrSuffixesExhausted", err)
+				}
 			}
 		}
 	})
@@ -0,0 +1,409 @@
+package cache_store
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+)
+
+const (
+	defaultSize        = 10_000
+	defaultTTL         = 10 * time.Minute
+	defaultNegativeTTL = 30 * time.Second
+)
+
+type Config struct {
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+func NewDefaultConfig() *Config {
+	return &Config{
+		size:        defaultSize,
+		ttl:         defaultTTL,
+		negativeTTL: defaultNegativeTTL,
+	}
+}
+
+func (c *Config) Size() int {
+	return c.size
+}
+
+func (c *Config) TTL() time.Duration {
+	return c.ttl
+}
+
+func (c *Config) NegativeTTL() time.Duration {
+	return c.negativeTTL
+}
+
+func (c *Config) SetSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid cache size %d: must be positive", size)
+	}
+	c.size = size
+	return nil
+}
+
+func (c *Config) SetTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid cache ttl %v: must be positive", ttl)
+	}
+	c.ttl = ttl
+	return nil
+}
+
+// SetNegativeTTL controls how long a miss is remembered. Zero disables
+// negative caching.
+func (c *Config) SetNegativeTTL(ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("invalid cache negative ttl %v: must not be negative", ttl)
+	}
+	c.negativeTTL = ttl
+	return nil
+}
+
+type Stats struct {
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negativeHits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	Entries      int    `json:"entries"`
+}
+
+type entry struct {
+	shortSuffix string
+	urlPair     model.URLPair
+	found       bool
+	expiresAt   time.Time
+}
+
+// call is an in-flight Load shared by every caller asking for the same suffix.
+type call struct {
+	done    chan struct{}
+	urlPair model.URLPair
+	err     error
+}
+
+// Publisher tells other processes caching the same store that a link changed.
+type Publisher interface {
+	Publish(shortSuffix string) error
+}
+
+// CachedURLStore is a read-through LRU in front of another URLStore. Misses
+// are cached too, but not a store that couldn't answer, and concurrent loads
+// of the same suffix hit the wrapped store once.
+type CachedURLStore struct {
+	store     store.URLStore
+	config    Config
+	now       func() time.Time
+	publisher Publisher
+
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	inflight   map[string]*call
+	generation uint64 // bumped on every write so in-flight loads can't cache stale values
+
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+}
+
+func NewCachedURLStore(urlStore store.URLStore, config *Config) *CachedURLStore {
+	if config == nil {
+		config = NewDefaultConfig()
+	}
+	return &CachedURLStore{
+		store:    urlStore,
+		config:   *config,
+		now:      time.Now,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		inflight: map[string]*call{},
+	}
+}
+
+// SetPublisher makes every Save and Delete announce the changed suffix so
+// other replicas can evict it.
+func (c *CachedURLStore) SetPublisher(publisher Publisher) {
+	c.publisher = publisher
+}
+
+func (c *CachedURLStore) Save(urlPair *model.URLPair) error {
+	if err := c.store.Save(urlPair); err != nil {
+		c.Invalidate(urlPair.ShortSuffix)
+		return err
+	}
+
+	c.mu.Lock()
+	c.generation++
+	if _, expires := urlPair.ExpiresAt(); expires {
+		// the next load asks the store, which knows whether it expired
+		if el, ok := c.entries[urlPair.ShortSuffix]; ok {
+			c.remove(el)
+		}
+	} else {
+		c.put(urlPair.ShortSuffix, model.URLPair{ShortSuffix: urlPair.ShortSuffix, BaseURL: urlPair.BaseURL, Metadata: maps.Clone(urlPair.Metadata)}, true)
+	}
+	c.mu.Unlock()
+
+	c.publish(urlPair.ShortSuffix)
+	return nil
+}
+
+func (c *CachedURLStore) Delete(shortSuffix string) error {
+	deleter, ok := c.store.(store.URLDeleter)
+	if !ok {
+		return fmt.Errorf("cached store does not support deleting links")
+	}
+
+	err := deleter.Delete(shortSuffix)
+	c.Invalidate(shortSuffix)
+	if err != nil {
+		return err
+	}
+
+	c.publish(shortSuffix)
+	return nil
+}
+
+func (c *CachedURLStore) Disable(shortSuffix, reason string) (model.Tombstone, error) {
+	disabler, ok := c.store.(store.LinkDisabler)
+	if !ok {
+		return model.Tombstone{}, fmt.Errorf("cached store does not support disabling links")
+	}
+
+	tombstone, err := disabler.Disable(shortSuffix, reason)
+	c.Invalidate(shortSuffix)
+	if err != nil {
+		return model.Tombstone{}, err
+	}
+
+	c.publish(shortSuffix)
+	return tombstone, nil
+}
+
+func (c *CachedURLStore) Restore(shortSuffix string) error {
+	disabler, ok := c.store.(store.LinkDisabler)
+	if !ok {
+		return fmt.Errorf("cached store does not support restoring links")
+	}
+
+	err := disabler.Restore(shortSuffix)
+	c.Invalidate(shortSuffix)
+	if err != nil {
+		return err
+	}
+
+	c.publish(shortSuffix)
+	return nil
+}
+
+// Tombstone asks the underlying store, tombstones are not cached.
+func (c *CachedURLStore) Tombstone(shortSuffix string) (model.Tombstone, bool) {
+	disabler, ok := c.store.(store.LinkDisabler)
+	if !ok {
+		return model.Tombstone{}, false
+	}
+	return disabler.Tombstone(shortSuffix)
+}
+
+// publish is best effort: the write already succeeded, and other replicas
+// still expire the entry after the TTL.
+func (c *CachedURLStore) publish(shortSuffix string) {
+	if c.publisher == nil {
+		return
+	}
+	if err := c.publisher.Publish(shortSuffix); err != nil {
+		log.Printf("unable to publish cache invalidation for %v: %v", shortSuffix, err)
+	}
+}
+
+func (c *CachedURLStore) Load(shortSuffix string) (string, bool) {
+	urlPair, found := c.LoadURLPair(shortSuffix)
+	return urlPair.BaseURL, found
+}
+
+// LoadURLPair caches the metadata of the link along with its base URL, the
+// metadata of the returned link is a copy.
+func (c *CachedURLStore) LoadURLPair(shortSuffix string) (model.URLPair, bool) {
+	urlPair, err := c.LookupURLPair(shortSuffix)
+	return urlPair, err == nil
+}
+
+func (c *CachedURLStore) LookupURLPair(shortSuffix string) (model.URLPair, error) {
+	urlPair, err := c.load(shortSuffix)
+	urlPair.Metadata = maps.Clone(urlPair.Metadata)
+	return urlPair, err
+}
+
+func (c *CachedURLStore) load(shortSuffix string) (model.URLPair, error) {
+	c.mu.Lock()
+	if e, ok := c.lookup(shortSuffix); ok {
+		c.mu.Unlock()
+		if e.found {
+			c.hits.Add(1)
+			return e.urlPair, nil
+		}
+		c.negativeHits.Add(1)
+		return model.URLPair{}, store.ErrNotFound
+	}
+
+	c.misses.Add(1)
+	if inflight, ok := c.inflight[shortSuffix]; ok {
+		c.mu.Unlock()
+		<-inflight.done
+		return inflight.urlPair, inflight.err
+	}
+
+	inflight := &call{done: make(chan struct{})}
+	c.inflight[shortSuffix] = inflight
+	generation := c.generation
+	c.mu.Unlock()
+
+	inflight.urlPair, inflight.err = store.LookupURLPair(c.store, shortSuffix)
+
+	c.mu.Lock()
+	delete(c.inflight, shortSuffix)
+	notFound := errors.Is(inflight.err, store.ErrNotFound)
+	if generation == c.generation && (inflight.err == nil || notFound) {
+		c.put(shortSuffix, inflight.urlPair, !notFound)
+	}
+	c.mu.Unlock()
+	close(inflight.done)
+
+	return inflight.urlPair, inflight.err
+}
+
+// Invalidate drops any cached value for shortSuffix, positive or negative.
+func (c *CachedURLStore) Invalidate(shortSuffix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if el, ok := c.entries[shortSuffix]; ok {
+		c.remove(el)
+	}
+}
+
+// Purge empties the cache.
+func (c *CachedURLStore) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+}
+
+func (c *CachedURLStore) Stats() Stats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Entries:      entries,
+	}
+}
+
+// lookup returns a fresh entry and marks it recently used. Callers hold c.mu.
+func (c *CachedURLStore) lookup(shortSuffix string) (*entry, bool) {
+	el, ok := c.entries[shortSuffix]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expiresAt) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+// put inserts or replaces an entry, evicting the least recently used one when
+// full. Callers hold c.mu.
+func (c *CachedURLStore) put(shortSuffix string, urlPair model.URLPair, found bool) {
+	ttl := c.config.ttl
+	if !found {
+		ttl = c.config.negativeTTL
+	}
+
+	if el, ok := c.entries[shortSuffix]; ok {
+		c.remove(el)
+	}
+	if ttl == 0 {
+		return
+	}
+
+	expiresAt := c.now().Add(ttl)
+	// a link is never served from the cache past its own expiry
+	if linkExpiresAt, ok := urlPair.ExpiresAt(); found && ok && linkExpiresAt.Before(expiresAt) {
+		expiresAt = linkExpiresAt
+	}
+	e := &entry{shortSuffix: shortSuffix, urlPair: urlPair, found: found, expiresAt: expiresAt}
+	c.entries[shortSuffix] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.config.size {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *CachedURLStore) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).shortSuffix)
+}
+
+// ClaimSuffix goes to the underlying store, claims are not cached.
+func (c *CachedURLStore) ClaimSuffix(shortSuffix string) (bool, error) {
+	return store.ClaimSuffix(c.store, shortSuffix)
+}
+
+// CountClick and Clicks go to the underlying store, clicks are not cached.
+func (c *CachedURLStore) CountClick(shortSuffix, variant string) error {
+	counter, ok := c.store.(store.ClickCounter)
+	if !ok {
+		return fmt.Errorf("cached store does not support counting clicks")
+	}
+	return counter.CountClick(shortSuffix, variant)
+}
+
+func (c *CachedURLStore) Clicks(shortSuffix string) (uint64, error) {
+	counter, ok := c.store.(store.ClickCounter)
+	if !ok {
+		return 0, fmt.Errorf("cached store does not support counting clicks")
+	}
+	return counter.Clicks(shortSuffix)
+}
+
+func (c *CachedURLStore) VariantClicks(shortSuffix string) (map[string]uint64, error) {
+	counter, ok := c.store.(store.ClickCounter)
+	if !ok {
+		return nil, fmt.Errorf("cached store does not support counting clicks")
+	}
+	return counter.VariantClicks(shortSuffix)
+}
+
+// Scan pages through the underlying store, bypassing the cache.
+func (c *CachedURLStore) Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error) {
+	scanner, ok := c.store.(store.URLScanner)
+	if !ok {
+		return nil, "", fmt.Errorf("cached store does not support scanning links")
+	}
+	return scanner.Scan(ctx, cursor, count)
+}
@@ -0,0 +1,238 @@
+package cache_store
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+type StubURLStore struct {
+	urlMap    map[string]string
+	loadCalls int
+	release   chan struct{} // when set, Load blocks until closed
+	mu        sync.Mutex
+}
+
+func (s *StubURLStore) Save(urlPair *model.URLPair) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.urlMap[urlPair.ShortSuffix] = urlPair.BaseURL
+	return nil
+}
+
+func (s *StubURLStore) Delete(shortSuffix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.urlMap, shortSuffix)
+	return nil
+}
+
+func (s *StubURLStore) Load(shortSuffix string) (string, bool) {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadCalls++
+	baseURL, found := s.urlMap[shortSuffix]
+	return baseURL, found
+}
+
+type SpyPublisher struct {
+	published []string
+}
+
+func (s *SpyPublisher) Publish(shortSuffix string) error {
+	s.published = append(s.published, shortSuffix)
+	return nil
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func newTestCache(t testing.TB, size int) (*CachedURLStore, *StubURLStore, *fakeClock) {
+	t.Helper()
+	store := &StubURLStore{urlMap: map[string]string{"0000001": "google.com", "0000002": "github.com", "0000003": "youtube.com"}}
+	config := NewDefaultConfig()
+	testutil.AssertNoError(t, config.SetSize(size))
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cached := NewCachedURLStore(store, config)
+	cached.now = clock.Now
+	return cached, store, clock
+}
+
+func TestCachedURLStore(t *testing.T) {
+	t.Run("serves repeated loads from the cache", func(t *testing.T) {
+		cached, store, _ := newTestCache(t, 10)
+
+		for range 3 {
+			got, found := cached.Load("0000001")
+			testutil.AssertEqual(t, found, true)
+			testutil.AssertEqual(t, got, "google.com")
+		}
+
+		testutil.AssertEqual(t, store.loadCalls, 1)
+		testutil.AssertEqual(t, cached.Stats().Hits, 2)
+		testutil.AssertEqual(t, cached.Stats().Misses, 1)
+	})
+
+	t.Run("caches misses", func(t *testing.T) {
+		cached, store, _ := newTestCache(t, 10)
+
+		for range 3 {
+			_, found := cached.Load("1000000")
+			testutil.AssertEqual(t, found, false)
+		}
+
+		testutil.AssertEqual(t, store.loadCalls, 1)
+		testutil.AssertEqual(t, cached.Stats().NegativeHits, 2)
+	})
+
+	t.Run("entries expire after their ttl", func(t *testing.T) {
+		cached, store, clock := newTestCache(t, 10)
+
+		cached.Load("0000001")
+		cached.Load("1000000")
+		clock.now = clock.now.Add(cached.config.NegativeTTL())
+		cached.Load("0000001")
+		cached.Load("1000000")
+		testutil.AssertEqual(t, store.loadCalls, 3)
+
+		clock.now = clock.now.Add(cached.config.TTL())
+		cached.Load("0000001")
+		testutil.AssertEqual(t, store.loadCalls, 4)
+	})
+
+	t.Run("entries expire with their link", func(t *testing.T) {
+		cached := NewCachedURLStore(memoryStore.NewInMemoryURLStore(), NewDefaultConfig())
+		clock := &fakeClock{now: time.Now()}
+		cached.now = clock.Now
+		expiresAt := clock.now.Add(time.Minute)
+		cached.store.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "google.com", Metadata: map[string]string{
+			model.MetadataExpiresAt: expiresAt.Format(time.RFC3339Nano),
+		}})
+
+		cached.Load("0000001")
+		cached.Load("0000001")
+		testutil.AssertEqual(t, cached.Stats().Misses, 1)
+
+		clock.now = expiresAt
+		cached.Load("0000001")
+		testutil.AssertEqual(t, cached.Stats().Misses, 2)
+	})
+
+	t.Run("evicts the least recently used entry when full", func(t *testing.T) {
+		cached, store, _ := newTestCache(t, 2)
+
+		cached.Load("0000001")
+		cached.Load("0000002")
+		cached.Load("0000001")
+		cached.Load("0000003") // evicts 0000002
+
+		cached.Load("0000001")
+		testutil.AssertEqual(t, store.loadCalls, 3)
+		cached.Load("0000002")
+		testutil.AssertEqual(t, store.loadCalls, 4)
+
+		testutil.AssertEqual(t, cached.Stats().Evictions, 2)
+		testutil.AssertEqual(t, cached.Stats().Entries, 2)
+	})
+
+	t.Run("save writes through and replaces a cached miss", func(t *testing.T) {
+		cached, store, _ := newTestCache(t, 10)
+
+		cached.Load("1000000")
+		testutil.AssertNoError(t, cached.Save(&model.URLPair{ShortSuffix: "1000000", BaseURL: "reddit.com"}))
+
+		got, found := cached.Load("1000000")
+		testutil.AssertEqual(t, found, true)
+		testutil.AssertEqual(t, got, "reddit.com")
+		testutil.AssertEqual(t, store.loadCalls, 1)
+	})
+
+	t.Run("delete removes the link and its cached entry", func(t *testing.T) {
+		cached, _, _ := newTestCache(t, 10)
+
+		cached.Load("0000001")
+		testutil.AssertNoError(t, cached.Delete("0000001"))
+
+		_, found := cached.Load("0000001")
+		testutil.AssertEqual(t, found, false)
+	})
+
+	t.Run("save and delete publish the changed suffix", func(t *testing.T) {
+		cached, _, _ := newTestCache(t, 10)
+		publisher := &SpyPublisher{}
+		cached.SetPublisher(publisher)
+
+		cached.Load("0000001")
+		testutil.AssertNoError(t, cached.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "reddit.com"}))
+		testutil.AssertNoError(t, cached.Delete("0000002"))
+
+		testutil.AssertEqual(t, len(publisher.published), 2)
+		testutil.AssertEqual(t, publisher.published[0], "0000001")
+		testutil.AssertEqual(t, publisher.published[1], "0000002")
+	})
+
+	t.Run("invalidate and purge drop entries", func(t *testing.T) {
+		cached, store, _ := newTestCache(t, 10)
+
+		cached.Load("0000001")
+		cached.Load("0000002")
+		cached.Invalidate("0000001")
+		cached.Load("0000001")
+		cached.Load("0000002")
+		testutil.AssertEqual(t, store.loadCalls, 3)
+
+		cached.Purge()
+		testutil.AssertEqual(t, cached.Stats().Entries, 0)
+		cached.Load("0000002")
+		testutil.AssertEqual(t, store.loadCalls, 4)
+	})
+}
+
+func TestCachedURLStore_CollapsesConcurrentLoads(t *testing.T) {
+	cached, store, _ := newTestCache(t, 10)
+	store.release = make(chan struct{})
+
+	loadCount := 100
+	var wg sync.WaitGroup
+	wg.Add(loadCount)
+	for range loadCount {
+		go func() {
+			defer wg.Done()
+			got, found := cached.Load("0000001")
+			testutil.AssertEqual(t, found, true)
+			testutil.AssertEqual(t, got, "google.com")
+		}()
+	}
+
+	// wait until every goroutine has either started the load or joined it
+	for {
+		if cached.Stats().Misses == uint64(loadCount) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(store.release)
+	wg.Wait()
+
+	testutil.AssertEqual(t, store.loadCalls, 1)
+}
+
+func TestCachedURLStoreConformance(t *testing.T) {
+	testutil.RunURLStoreConformance(t, func(t *testing.T) store.URLStore {
+		return NewCachedURLStore(memoryStore.NewInMemoryURLStore(), nil)
+	})
+}
@@ -0,0 +1,445 @@
+package encrypted_store
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+)
+
+// sealedPrefix marks a sealed base URL, it is followed by the key ID, the
+// wrapped data key and the encrypted URL, separated by colons.
+const sealedPrefix = "enc:v1:"
+
+const dataKeySize = 32
+
+var encoding = base64.RawURLEncoding
+
+// EncryptedURLStore encrypts base URLs before they reach another store, so
+// tokens in query strings are not readable at rest. Every link gets its own
+// AES-256-GCM data key, wrapped by the primary key of the keyring and stored
+// with the ID of that key, so rotating keys only needs the old ones kept
+// around until Reencrypt has run. The short suffix is authenticated with the
+// URL, a value copied to another suffix doesn't open. The platform
+// destinations and variants of a link are sealed the same way, the rest of
+// its metadata is stored as it is. Values stored before encryption was
+// enabled are returned as they are.
+type EncryptedURLStore struct {
+	store   store.URLStore
+	keyring *Keyring
+}
+
+func NewEncryptedURLStore(urlStore store.URLStore, keyring *Keyring) *EncryptedURLStore {
+	return &EncryptedURLStore{store: urlStore, keyring: keyring}
+}
+
+// sealedMetadata reports whether the metadata value under key holds URLs
+// that are sealed like the base URL.
+func sealedMetadata(key string) bool {
+	return strings.HasPrefix(key, model.MetadataDestinationPrefix) || key == model.MetadataVariants
+}
+
+// metadataData is what a metadata value is authenticated with, so it opens
+// neither under another suffix nor under another key.
+func metadataData(shortSuffix, key string) string {
+	return shortSuffix + "/" + key
+}
+
+func (e *EncryptedURLStore) Save(urlPair *model.URLPair) error {
+	encrypted, err := e.sealPair(urlPair)
+	if err != nil {
+		return err
+	}
+	return e.store.Save(&encrypted)
+}
+
+// sealPair returns a copy of the link with its base URL and sealed metadata
+// encrypted, the caller keeps its plaintext pair.
+func (e *EncryptedURLStore) sealPair(urlPair *model.URLPair) (model.URLPair, error) {
+	encrypted := *urlPair
+	encrypted.Metadata = maps.Clone(urlPair.Metadata)
+	var err error
+	if encrypted.BaseURL, err = e.seal(urlPair.ShortSuffix, urlPair.BaseURL); err != nil {
+		return model.URLPair{}, err
+	}
+	for key, value := range encrypted.Metadata {
+		if !sealedMetadata(key) {
+			continue
+		}
+		if encrypted.Metadata[key], err = e.seal(metadataData(urlPair.ShortSuffix, key), value); err != nil {
+			return model.URLPair{}, err
+		}
+	}
+	return encrypted, nil
+}
+
+// openPair decrypts the base URL and the sealed metadata of a stored link in
+// place.
+func (e *EncryptedURLStore) openPair(urlPair *model.URLPair) error {
+	baseURL, err := e.open(urlPair.ShortSuffix, urlPair.BaseURL)
+	if err != nil {
+		return err
+	}
+	urlPair.BaseURL = baseURL
+	for key, value := range urlPair.Metadata {
+		if !sealedMetadata(key) {
+			continue
+		}
+		if urlPair.Metadata[key], err = e.open(metadataData(urlPair.ShortSuffix, key), value); err != nil {
+			return fmt.Errorf("%v: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// sealedWithPrimary reports whether the base URL and the sealed metadata of
+// a stored link are all sealed with the primary key.
+func (e *EncryptedURLStore) sealedWithPrimary(urlPair model.URLPair) bool {
+	if keyID, sealed := KeyID(urlPair.BaseURL); !sealed || keyID != e.keyring.primary {
+		return false
+	}
+	for key, value := range urlPair.Metadata {
+		if keyID, sealed := KeyID(value); sealedMetadata(key) && (!sealed || keyID != e.keyring.primary) {
+			return false
+		}
+	}
+	return true
+}
+
+// Load answers not found when the base URL can't be decrypted, which is
+// logged since it means a key is missing from the keyring.
+func (e *EncryptedURLStore) Load(shortSuffix string) (string, bool) {
+	value, found := e.store.Load(shortSuffix)
+	if !found {
+		return "", false
+	}
+	baseURL, err := e.open(shortSuffix, value)
+	if err != nil {
+		log.Printf("unable to decrypt link %v: %v", shortSuffix, err)
+		return "", false
+	}
+	return baseURL, true
+}
+
+// LoadURLPair decrypts the base URL, the destinations and the variants of
+// the link.
+func (e *EncryptedURLStore) LoadURLPair(shortSuffix string) (model.URLPair, bool) {
+	urlPair, err := e.LookupURLPair(shortSuffix)
+	return urlPair, err == nil
+}
+
+// LookupURLPair answers store.ErrNotFound for a link that can't be
+// decrypted, like Load.
+func (e *EncryptedURLStore) LookupURLPair(shortSuffix string) (model.URLPair, error) {
+	urlPair, err := store.LookupURLPair(e.store, shortSuffix)
+	if err != nil {
+		return model.URLPair{}, err
+	}
+	urlPair.ShortSuffix = shortSuffix
+	if err := e.openPair(&urlPair); err != nil {
+		log.Printf("unable to decrypt link %v: %v", shortSuffix, err)
+		return model.URLPair{}, store.ErrNotFound
+	}
+	return urlPair, nil
+}
+
+func (e *EncryptedURLStore) ClaimSuffix(shortSuffix string) (bool, error) {
+	return store.ClaimSuffix(e.store, shortSuffix)
+}
+
+func (e *EncryptedURLStore) Delete(shortSuffix string) error {
+	deleter, ok := e.store.(store.URLDeleter)
+	if !ok {
+		return fmt.Errorf("encrypted store does not support deleting links")
+	}
+	return deleter.Delete(shortSuffix)
+}
+
+// Scan decrypts the links of a page of the underlying store.
+func (e *EncryptedURLStore) Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error) {
+	scanner, ok := e.store.(store.URLScanner)
+	if !ok {
+		return nil, "", fmt.Errorf("encrypted store does not support scanning links")
+	}
+	pairs, next, err := scanner.Scan(ctx, cursor, count)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range pairs {
+		if err := e.openPair(&pairs[i]); err != nil {
+			return nil, "", fmt.Errorf("error when decrypting link %v, %v", pairs[i].ShortSuffix, err)
+		}
+	}
+	return pairs, next, nil
+}
+
+// Disable returns the tombstone decrypted, the stored one stays sealed.
+func (e *EncryptedURLStore) Disable(shortSuffix, reason string) (model.Tombstone, error) {
+	disabler, ok := e.store.(store.LinkDisabler)
+	if !ok {
+		return model.Tombstone{}, fmt.Errorf("encrypted store does not support disabling links")
+	}
+	tombstone, err := disabler.Disable(shortSuffix, reason)
+	if err != nil {
+		return model.Tombstone{}, err
+	}
+	urlPair := model.URLPair{ShortSuffix: shortSuffix, BaseURL: tombstone.BaseURL, Metadata: maps.Clone(tombstone.Metadata)}
+	if err := e.openPair(&urlPair); err != nil {
+		return model.Tombstone{}, fmt.Errorf("error when decrypting link %v, %v", shortSuffix, err)
+	}
+	tombstone.BaseURL, tombstone.Metadata = urlPair.BaseURL, urlPair.Metadata
+	return tombstone, nil
+}
+
+func (e *EncryptedURLStore) Restore(shortSuffix string) error {
+	disabler, ok := e.store.(store.LinkDisabler)
+	if !ok {
+		return fmt.Errorf("encrypted store does not support restoring links")
+	}
+	return disabler.Restore(shortSuffix)
+}
+
+func (e *EncryptedURLStore) Tombstone(shortSuffix string) (model.Tombstone, bool) {
+	disabler, ok := e.store.(store.LinkDisabler)
+	if !ok {
+		return model.Tombstone{}, false
+	}
+	tombstone, found := disabler.Tombstone(shortSuffix)
+	if !found {
+		return model.Tombstone{}, false
+	}
+	urlPair := model.URLPair{ShortSuffix: shortSuffix, BaseURL: tombstone.BaseURL, Metadata: maps.Clone(tombstone.Metadata)}
+	if err := e.openPair(&urlPair); err != nil {
+		log.Printf("unable to decrypt tombstone %v: %v", shortSuffix, err)
+		return model.Tombstone{ShortSuffix: shortSuffix, Reason: tombstone.Reason, DisabledAt: tombstone.DisabledAt}, true
+	}
+	tombstone.BaseURL, tombstone.Metadata = urlPair.BaseURL, urlPair.Metadata
+	return tombstone, true
+}
+
+func (e *EncryptedURLStore) CountClick(shortSuffix, variant string) error {
+	counter, ok := e.store.(store.ClickCounter)
+	if !ok {
+		return fmt.Errorf("encrypted store does not support counting clicks")
+	}
+	return counter.CountClick(shortSuffix, variant)
+}
+
+func (e *EncryptedURLStore) Clicks(shortSuffix string) (uint64, error) {
+	counter, ok := e.store.(store.ClickCounter)
+	if !ok {
+		return 0, fmt.Errorf("encrypted store does not support counting clicks")
+	}
+	return counter.Clicks(shortSuffix)
+}
+
+func (e *EncryptedURLStore) VariantClicks(shortSuffix string) (map[string]uint64, error) {
+	counter, ok := e.store.(store.ClickCounter)
+	if !ok {
+		return nil, fmt.Errorf("encrypted store does not support counting clicks")
+	}
+	return counter.VariantClicks(shortSuffix)
+}
+
+type RotationResult struct {
+	Scanned     int `json:"scanned"`
+	Reencrypted int `json:"reencrypted"`
+	Unchanged   int `json:"unchanged"`
+	// Changed counts the links saved, deleted or disabled while they were
+	// re-encrypted, which are left as they were written.
+	Changed int `json:"changed"`
+}
+
+// tombstoneLister is implemented by stores that can list their disabled
+// links.
+type tombstoneLister interface {
+	ForEachTombstone(ctx context.Context, fn func(shortSuffix string)) error
+}
+
+// Reencrypt seals every link whose base URL, destinations or variants aren't
+// sealed with the primary key again, including links stored in plaintext.
+// Tombstones are left as they are. Once it has finished the other keys can be
+// dropped from the keyring, except those TombstoneKeys reports as long as
+// the disabled links sealed with them may be restored.
+func (e *EncryptedURLStore) Reencrypt(ctx context.Context, batchSize int) (RotationResult, error) {
+	var result RotationResult
+	scanner, ok := e.store.(store.URLScanner)
+	if !ok {
+		return result, fmt.Errorf("encrypted store does not support scanning links")
+	}
+	swapper, ok := e.store.(store.URLSwapper)
+	if !ok {
+		return result, fmt.Errorf("encrypted store does not support swapping links")
+	}
+
+	cursor := ""
+	for {
+		pairs, next, err := scanner.Scan(ctx, cursor, batchSize)
+		if err != nil {
+			return result, fmt.Errorf("error when scanning links, %v", err)
+		}
+		for _, pair := range pairs {
+			result.Scanned++
+			if e.sealedWithPrimary(pair) {
+				result.Unchanged++
+				continue
+			}
+			stored := pair
+			stored.Metadata = maps.Clone(pair.Metadata)
+			if err := e.openPair(&pair); err != nil {
+				return result, fmt.Errorf("error when decrypting link %v, %v", pair.ShortSuffix, err)
+			}
+			sealed, err := e.sealPair(&pair)
+			if err != nil {
+				return result, fmt.Errorf("error when encrypting link %v, %v", pair.ShortSuffix, err)
+			}
+			// only written over the value that was scanned, a link removed
+			// meanwhile would come back otherwise
+			swapped, err := swapper.Swap(&stored, &sealed)
+			if err != nil {
+				return result, fmt.Errorf("error when saving link %v, %v", pair.ShortSuffix, err)
+			}
+			if !swapped {
+				result.Changed++
+				continue
+			}
+			result.Reencrypted++
+		}
+		if next == "" {
+			return result, nil
+		}
+		cursor = next
+	}
+}
+
+// TombstoneKeys returns the sorted IDs of the keys other than the primary
+// that disabled links are sealed with, none for stores that can't list them.
+func (e *EncryptedURLStore) TombstoneKeys(ctx context.Context) ([]string, error) {
+	lister, listable := e.store.(tombstoneLister)
+	disabler, ok := e.store.(store.LinkDisabler)
+	if !listable || !ok {
+		return nil, nil
+	}
+
+	keyIDs := map[string]bool{}
+	add := func(value string) {
+		if keyID, sealed := KeyID(value); sealed && keyID != e.keyring.primary {
+			keyIDs[keyID] = true
+		}
+	}
+	err := lister.ForEachTombstone(ctx, func(shortSuffix string) {
+		tombstone, found := disabler.Tombstone(shortSuffix)
+		if !found {
+			return
+		}
+		add(tombstone.BaseURL)
+		for key, value := range tombstone.Metadata {
+			if sealedMetadata(key) {
+				add(value)
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error when listing disabled links, %v", err)
+	}
+	return slices.Sorted(maps.Keys(keyIDs)), nil
+}
+
+// KeyID returns the ID of the key a stored value was sealed with, and false
+// for a value stored in plaintext.
+func KeyID(value string) (string, bool) {
+	rest, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return "", false
+	}
+	keyID, _, _ := strings.Cut(rest, ":")
+	return keyID, true
+}
+
+// seal encrypts plaintext, authenticated with additionalData: the short
+// suffix for base URLs.
+func (e *EncryptedURLStore) seal(additionalData, plaintext string) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("error when generating data key, %v", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	keyID := e.keyring.primary
+	wrapped, err := sealWith(e.keyring.keys[keyID], dataKey, []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealWith(data, []byte(plaintext), []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + keyID + ":" + encoding.EncodeToString(wrapped) + ":" + encoding.EncodeToString(ciphertext), nil
+}
+
+func (e *EncryptedURLStore) open(additionalData, value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	parts := strings.Split(rest, ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed sealed value")
+	}
+	keyID := parts[0]
+	key, ok := e.keyring.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("unknown key id '%v'", keyID)
+	}
+	wrapped, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed data key, %v", err)
+	}
+	ciphertext, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext, %v", err)
+	}
+
+	dataKey, err := openWith(key, wrapped, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("error when unwrapping data key, %v", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openWith(data, ciphertext, []byte(additionalData))
+	if err != nil {
+		return "", fmt.Errorf("error when decrypting value, %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// sealWith returns the nonce followed by the ciphertext.
+func sealWith(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error when generating nonce, %v", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openWith(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
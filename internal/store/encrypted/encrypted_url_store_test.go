@@ -0,0 +1,237 @@
+package encrypted_store
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+const secretURL = "https://example.com/reset?token=s3cr3t"
+
+func newKeyring(t testing.TB, primary string, ids ...string) *Keyring {
+	t.Helper()
+	keys := map[string][]byte{}
+	for _, id := range ids {
+		key := sha256.Sum256([]byte(id))
+		keys[id] = key[:]
+	}
+	keyring, err := NewKeyring(primary, keys)
+	testutil.AssertNoError(t, err)
+	return keyring
+}
+
+func TestEncryptedURLStoreConformance(t *testing.T) {
+	testutil.RunURLStoreConformance(t, func(t *testing.T) store.URLStore {
+		return NewEncryptedURLStore(memoryStore.NewInMemoryURLStore(), newKeyring(t, "a", "a"))
+	})
+}
+
+func TestEncryptedURLStore(t *testing.T) {
+	t.Run("base URLs are encrypted at rest", func(t *testing.T) {
+		backend := memoryStore.NewInMemoryURLStore()
+		store := NewEncryptedURLStore(backend, newKeyring(t, "a", "a"))
+		pair := &model.URLPair{ShortSuffix: "0000001", BaseURL: secretURL}
+		testutil.AssertNoError(t, store.Save(pair))
+		testutil.AssertEqual(t, pair.BaseURL, secretURL)
+
+		stored, _ := backend.Load("0000001")
+		if strings.Contains(stored, "s3cr3t") {
+			t.Fatalf("expected the base URL to be encrypted, got %v", stored)
+		}
+		keyID, sealed := KeyID(stored)
+		testutil.AssertEqual(t, sealed, true)
+		testutil.AssertEqual(t, keyID, "a")
+
+		baseURL, found := store.Load("0000001")
+		testutil.AssertEqual(t, found, true)
+		testutil.AssertEqual(t, baseURL, secretURL)
+	})
+
+	t.Run("platform destinations are encrypted at rest", func(t *testing.T) {
+		backend := memoryStore.NewInMemoryURLStore()
+		store := NewEncryptedURLStore(backend, newKeyring(t, "a", "a"))
+		iosKey := model.MetadataDestinationPrefix + model.PlatformIOS
+		metadata := map[string]string{iosKey: secretURL, model.MetadataForwardQuery: "true"}
+		testutil.AssertNoError(t, store.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "https://example.com", Metadata: metadata}))
+		testutil.AssertEqual(t, metadata[iosKey], secretURL)
+
+		stored, _ := backend.LoadURLPair("0000001")
+		if strings.Contains(stored.Metadata[iosKey], "s3cr3t") {
+			t.Fatalf("expected the destination to be encrypted, got %v", stored.Metadata[iosKey])
+		}
+		testutil.AssertEqual(t, stored.Metadata[model.MetadataForwardQuery], "true")
+
+		urlPair, found := store.LoadURLPair("0000001")
+		testutil.AssertEqual(t, found, true)
+		testutil.AssertEqual(t, urlPair.Metadata[iosKey], secretURL)
+
+		// a destination moved under another platform doesn't open
+		stored.Metadata[model.MetadataDestinationPrefix+model.PlatformAndroid] = stored.Metadata[iosKey]
+		testutil.AssertNoError(t, backend.Save(&stored))
+		_, found = store.LoadURLPair("0000001")
+		testutil.AssertEqual(t, found, false)
+	})
+
+	t.Run("variants are encrypted at rest", func(t *testing.T) {
+		backend := memoryStore.NewInMemoryURLStore()
+		store := NewEncryptedURLStore(backend, newKeyring(t, "a", "a"))
+		variants, err := model.EncodeVariants([]model.Variant{{Name: "a", URL: secretURL, Weight: 1}})
+		testutil.AssertNoError(t, err)
+		testutil.AssertNoError(t, store.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "https://example.com", Metadata: map[string]string{model.MetadataVariants: variants}}))
+
+		stored, _ := backend.LoadURLPair("0000001")
+		if strings.Contains(stored.Metadata[model.MetadataVariants], "s3cr3t") {
+			t.Fatalf("expected the variants to be encrypted, got %v", stored.Metadata[model.MetadataVariants])
+		}
+		urlPair, found := store.LoadURLPair("0000001")
+		testutil.AssertEqual(t, found, true)
+		testutil.AssertEqual(t, urlPair.Variants()[0].URL, secretURL)
+	})
+
+	t.Run("a sealed value doesn't open under another suffix", func(t *testing.T) {
+		backend := memoryStore.NewInMemoryURLStore()
+		store := NewEncryptedURLStore(backend, newKeyring(t, "a", "a"))
+		testutil.AssertNoError(t, store.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: secretURL}))
+
+		stored, _ := backend.Load("0000001")
+		testutil.AssertNoError(t, backend.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: stored}))
+		_, found := store.Load("0000002")
+		testutil.AssertEqual(t, found, false)
+	})
+
+	t.Run("links sealed with a key missing from the keyring are not found", func(t *testing.T) {
+		backend := memoryStore.NewInMemoryURLStore()
+		testutil.AssertNoError(t, NewEncryptedURLStore(backend, newKeyring(t, "a", "a")).Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: secretURL}))
+
+		_, found := NewEncryptedURLStore(backend, newKeyring(t, "b", "b")).Load("0000001")
+		testutil.AssertEqual(t, found, false)
+	})
+
+	t.Run("plaintext links are returned as they are", func(t *testing.T) {
+		backend := memoryStore.NewInMemoryURLStore()
+		testutil.AssertNoError(t, backend.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com"}))
+
+		baseURL, found := NewEncryptedURLStore(backend, newKeyring(t, "a", "a")).Load("0000001")
+		testutil.AssertEqual(t, found, true)
+		testutil.AssertEqual(t, baseURL, "example.com")
+	})
+
+	t.Run("reencrypt moves every link to the primary key", func(t *testing.T) {
+		backend := memoryStore.NewInMemoryURLStore()
+		old := NewEncryptedURLStore(backend, newKeyring(t, "a", "a"))
+		testutil.AssertNoError(t, old.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: secretURL, Metadata: map[string]string{"n": "1"}}))
+		testutil.AssertNoError(t, backend.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: "example.com"}))
+
+		rotated := NewEncryptedURLStore(backend, newKeyring(t, "b", "a", "b"))
+		// sealed base URL, plaintext destination
+		sealedURL, err := rotated.seal("0000004", "example.net")
+		testutil.AssertNoError(t, err)
+		testutil.AssertNoError(t, backend.Save(&model.URLPair{ShortSuffix: "0000004", BaseURL: sealedURL, Metadata: map[string]string{
+			model.MetadataDestinationPrefix + model.PlatformIOS: secretURL,
+		}}))
+		testutil.AssertNoError(t, rotated.Save(&model.URLPair{ShortSuffix: "0000003", BaseURL: "example.org"}))
+		result, err := rotated.Reencrypt(context.Background(), 2)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result, RotationResult{Scanned: 4, Reencrypted: 3, Unchanged: 1})
+
+		pairs, _, err := backend.Scan(context.Background(), "", 10)
+		testutil.AssertNoError(t, err)
+		for _, pair := range pairs {
+			keyID, sealed := KeyID(pair.BaseURL)
+			testutil.AssertEqual(t, sealed, true)
+			testutil.AssertEqual(t, keyID, "b")
+		}
+		testutil.AssertEqual(t, pairs[0].Metadata["n"], "1")
+		keyID, sealed := KeyID(pairs[3].Metadata[model.MetadataDestinationPrefix+model.PlatformIOS])
+		testutil.AssertEqual(t, sealed, true)
+		testutil.AssertEqual(t, keyID, "b")
+
+		// the old key is no longer needed
+		store := NewEncryptedURLStore(backend, newKeyring(t, "b", "b"))
+		baseURL, found := store.Load("0000001")
+		testutil.AssertEqual(t, found, true)
+		testutil.AssertEqual(t, baseURL, secretURL)
+	})
+
+	t.Run("reports the keys disabled links still need", func(t *testing.T) {
+		backend := memoryStore.NewInMemoryURLStore()
+		old := NewEncryptedURLStore(backend, newKeyring(t, "a", "a"))
+		testutil.AssertNoError(t, old.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: secretURL}))
+		testutil.AssertNoError(t, old.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: secretURL}))
+		_, err := old.Disable("0000001", model.DisabledForAbuse)
+		testutil.AssertNoError(t, err)
+
+		rotated := NewEncryptedURLStore(backend, newKeyring(t, "b", "a", "b"))
+		result, err := rotated.Reencrypt(context.Background(), 10)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result.Reencrypted, 1)
+		keyIDs, err := rotated.TombstoneKeys(context.Background())
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, strings.Join(keyIDs, ","), "a")
+	})
+
+	t.Run("reencrypt leaves links disabled during the run alone", func(t *testing.T) {
+		backend := &DisablingURLStore{InMemoryURLStore: memoryStore.NewInMemoryURLStore(), disable: "0000001"}
+		old := NewEncryptedURLStore(backend, newKeyring(t, "a", "a"))
+		testutil.AssertNoError(t, old.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: secretURL}))
+		testutil.AssertNoError(t, old.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: secretURL}))
+
+		result, err := NewEncryptedURLStore(backend, newKeyring(t, "b", "a", "b")).Reencrypt(context.Background(), 10)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result, RotationResult{Scanned: 2, Reencrypted: 1, Changed: 1})
+
+		_, found := backend.Load("0000001")
+		testutil.AssertEqual(t, found, false)
+		_, found = backend.Tombstone("0000001")
+		testutil.AssertEqual(t, found, true)
+	})
+}
+
+// DisablingURLStore disables a link right after a scan returned it, as an
+// admin could while a reencrypt runs.
+type DisablingURLStore struct {
+	*memoryStore.InMemoryURLStore
+	disable string
+}
+
+func (d *DisablingURLStore) Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error) {
+	pairs, next, err := d.InMemoryURLStore.Scan(ctx, cursor, count)
+	if err == nil && d.disable != "" {
+		_, err = d.InMemoryURLStore.Disable(d.disable, model.DisabledForAbuse)
+		d.disable = ""
+	}
+	return pairs, next, err
+}
+
+func TestKeyring(t *testing.T) {
+	t.Run("parses id:base64key pairs", func(t *testing.T) {
+		keys, err := ParseKeys("2024:AAAAAAAAAAAAAAAAAAAAAA==, 2025:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+		testutil.AssertNoError(t, err)
+		keyring, err := NewKeyring("2025", keys)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, strings.Join(keyring.IDs(), ","), "2024,2025")
+
+		keyring, err = ParseKeyring("2025:AAAAAAAAAAAAAAAAAAAAAA==,2024:AAAAAAAAAAAAAAAAAAAAAA==", "")
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, keyring.Primary(), "2025")
+	})
+
+	t.Run("rejects bad keys", func(t *testing.T) {
+		for _, spec := range []string{"nokey", "a:not base64", "a:AAAA,a:AAAA"} {
+			_, err := ParseKeys(spec)
+			testutil.AssertError(t, err)
+		}
+		_, err := NewKeyring("a", map[string][]byte{"a": []byte("short")})
+		testutil.AssertError(t, err)
+		_, err = NewKeyring("a", map[string][]byte{"a:b": make([]byte, 32)})
+		testutil.AssertError(t, err)
+		_, err = NewKeyring("missing", map[string][]byte{"a": make([]byte, 32)})
+		testutil.AssertError(t, err)
+	})
+}
@@ -0,0 +1,97 @@
+package encrypted_store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// keyIDPattern keeps key IDs free of the separator used in sealed values.
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Keyring holds the key encryption keys by ID. New links are sealed with the
+// primary key, the others are kept to open links sealed before a rotation.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring takes AES-128, AES-192 or AES-256 keys by ID, primary must be
+// one of them.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	keyring := &Keyring{primary: primary, keys: map[string]cipher.AEAD{}}
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid key id '%v', only letters, digits, - and _ are allowed", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%v', %v", id, err)
+		}
+		keyring.keys[id] = aead
+	}
+	if _, ok := keyring.keys[primary]; !ok {
+		return nil, fmt.Errorf("invalid primary key id '%v': not in the keyring", primary)
+	}
+	return keyring, nil
+}
+
+// ParseKeys reads keys written as id:base64key pairs separated by commas,
+// e.g. "2024:q1Z...=,2025:Xb3...=".
+func ParseKeys(spec string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key '%v', expected id:base64key", entry)
+		}
+		if _, duplicate := keys[id]; duplicate {
+			return nil, fmt.Errorf("invalid keys: id '%v' appears twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("error when decoding key '%v', %v", id, err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// ParseKeyring builds a keyring from keys as read by ParseKeys. An empty
+// primary picks the first key listed, so prepending a new key rotates to it.
+func ParseKeyring(spec, primary string) (*Keyring, error) {
+	keys, err := ParseKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+	if primary == "" {
+		primary, _, _ = strings.Cut(strings.TrimSpace(spec), ":")
+	}
+	return NewKeyring(primary, keys)
+}
+
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// IDs returns every key ID in the keyring, sorted.
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,14 @@
+// Package store holds what every URL store backend has in common.
+package store
+
+import "errors"
+
+var (
+	// ErrNotFound means the store answered and has no link for the suffix.
+	ErrNotFound = errors.New("short link not found")
+	// ErrUnavailable means the store could not be reached or gave up, the
+	// link may or may not exist.
+	ErrUnavailable = errors.New("url store unavailable")
+	// ErrExists means the suffix already holds a link.
+	ErrExists = errors.New("short link already exists")
+)
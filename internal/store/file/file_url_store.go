@@ -0,0 +1,149 @@
+// Package file_store keeps links in memory and journals every change to a
+// JSON lines file, which makes it a portable backup and snapshot format.
+package file_store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+)
+
+// record is one line of the file. Later lines win over earlier ones for the
+// same suffix.
+type record struct {
+	ShortSuffix string            `json:"shortSuffix"`
+	BaseURL     string            `json:"baseURL,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Deleted     bool              `json:"deleted,omitempty"`
+}
+
+// FileURLStore serves reads from memory and appends writes to its file.
+// Writes are buffered, call Sync to make them durable and Close when done.
+type FileURLStore struct {
+	links *memoryStore.InMemoryURLStore
+	file  *os.File
+	w     *bufio.Writer
+	mu    sync.Mutex
+}
+
+// OpenFileURLStore replays the file at path, creating it if needed.
+func OpenFileURLStore(path string) (*FileURLStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error when opening link file, %v", err)
+	}
+
+	links := memoryStore.NewInMemoryURLStore()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error when reading line %d of %s, %v", line, path, err)
+		}
+		if r.Deleted {
+			links.Delete(r.ShortSuffix)
+		} else {
+			links.Save(&model.URLPair{ShortSuffix: r.ShortSuffix, BaseURL: r.BaseURL, Metadata: r.Metadata})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error when reading %s, %v", path, err)
+	}
+
+	return &FileURLStore{links: links, file: file, w: bufio.NewWriter(file)}, nil
+}
+
+func (f *FileURLStore) Save(urlPair *model.URLPair) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.append(record{ShortSuffix: urlPair.ShortSuffix, BaseURL: urlPair.BaseURL, Metadata: urlPair.Metadata}); err != nil {
+		return err
+	}
+	return f.links.Save(urlPair)
+}
+
+func (f *FileURLStore) Swap(old, next *model.URLPair) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if current, found := f.links.LoadURLPair(old.ShortSuffix); !found || !current.SameLink(*old) {
+		return false, nil
+	}
+	if err := f.append(record{ShortSuffix: old.ShortSuffix, BaseURL: next.BaseURL, Metadata: next.Metadata}); err != nil {
+		return false, err
+	}
+	return true, f.links.Save(&model.URLPair{ShortSuffix: old.ShortSuffix, BaseURL: next.BaseURL, Metadata: next.Metadata})
+}
+
+func (f *FileURLStore) Load(shortSuffix string) (string, bool) {
+	return f.links.Load(shortSuffix)
+}
+
+func (f *FileURLStore) Lookup(shortSuffix string) (string, error) {
+	return f.links.Lookup(shortSuffix)
+}
+
+func (f *FileURLStore) LoadURLPair(shortSuffix string) (model.URLPair, bool) {
+	return f.links.LoadURLPair(shortSuffix)
+}
+
+func (f *FileURLStore) LookupURLPair(shortSuffix string) (model.URLPair, error) {
+	return f.links.LookupURLPair(shortSuffix)
+}
+
+func (f *FileURLStore) Delete(shortSuffix string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, found := f.links.Load(shortSuffix); !found {
+		return nil
+	}
+	if err := f.append(record{ShortSuffix: shortSuffix, Deleted: true}); err != nil {
+		return err
+	}
+	return f.links.Delete(shortSuffix)
+}
+
+// Scan pages through the links in suffix order, see store.URLScanner.
+func (f *FileURLStore) Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error) {
+	return f.links.Scan(ctx, cursor, count)
+}
+
+// Sync writes buffered changes to disk.
+func (f *FileURLStore) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.w.Flush(); err != nil {
+		return fmt.Errorf("error when writing link file, %v", err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("error when syncing link file, %v", err)
+	}
+	return nil
+}
+
+func (f *FileURLStore) Close() error {
+	if err := f.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+func (f *FileURLStore) append(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("error when encoding link, %v", err)
+	}
+	if _, err := f.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error when writing link file, %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package file_store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+func TestFileURLStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.jsonl")
+
+	t.Run("changes survive reopening", func(t *testing.T) {
+		store, err := OpenFileURLStore(path)
+		testutil.AssertNoError(t, err)
+		testutil.AssertNoError(t, store.Save(&model.URLPair{ShortSuffix: "abc", BaseURL: "example.com", Metadata: map[string]string{"owner": "ops"}}))
+		testutil.AssertNoError(t, store.Save(&model.URLPair{ShortSuffix: "def", BaseURL: "old.com"}))
+		testutil.AssertNoError(t, store.Save(&model.URLPair{ShortSuffix: "def", BaseURL: "new.com"}))
+		testutil.AssertNoError(t, store.Save(&model.URLPair{ShortSuffix: "ghi", BaseURL: "gone.com"}))
+		testutil.AssertNoError(t, store.Delete("ghi"))
+		testutil.AssertNoError(t, store.Close())
+
+		store, err = OpenFileURLStore(path)
+		testutil.AssertNoError(t, err)
+		defer store.Close()
+
+		pairs, next, err := store.Scan(context.Background(), "", 10)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, next, "")
+		testutil.AssertEqual(t, len(pairs), 2)
+		testutil.AssertEqual(t, pairs[0].ShortSuffix, "abc")
+		testutil.AssertEqual(t, pairs[0].BaseURL, "example.com")
+		testutil.AssertEqual(t, pairs[0].Metadata["owner"], "ops")
+		testutil.AssertEqual(t, pairs[1].ShortSuffix, "def")
+		testutil.AssertEqual(t, pairs[1].BaseURL, "new.com")
+
+		_, found := store.Load("ghi")
+		testutil.AssertEqual(t, found, false)
+	})
+
+	t.Run("scans in pages", func(t *testing.T) {
+		store, err := OpenFileURLStore(path)
+		testutil.AssertNoError(t, err)
+		defer store.Close()
+
+		pairs, next, err := store.Scan(context.Background(), "", 1)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, len(pairs), 1)
+		testutil.AssertEqual(t, next, "abc")
+
+		pairs, next, err = store.Scan(context.Background(), next, 1)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, pairs[0].ShortSuffix, "def")
+		testutil.AssertEqual(t, next, "")
+	})
+
+	t.Run("rejects a corrupt file", func(t *testing.T) {
+		corrupt := filepath.Join(t.TempDir(), "corrupt.jsonl")
+		testutil.AssertNoError(t, os.WriteFile(corrupt, []byte("{\"shortSuffix\":\"abc\"}\nnot json\n"), 0o644))
+
+		_, err := OpenFileURLStore(corrupt)
+		testutil.AssertError(t, err)
+	})
+}
+
+func TestFileURLStoreConformance(t *testing.T) {
+	testutil.RunURLStoreConformance(t, func(t *testing.T) store.URLStore {
+		store, err := OpenFileURLStore(filepath.Join(t.TempDir(), "links.jsonl"))
+		testutil.AssertNoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
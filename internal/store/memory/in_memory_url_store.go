@@ -1,28 +1,225 @@
 package memory_store
 
-import "sync"
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+)
 
 func NewInMemoryURLStore() *InMemoryURLStore {
 	return &InMemoryURLStore{
-		map[string]string{},
+		map[string]model.URLPair{},
+		map[string]model.Tombstone{},
+		map[string]uint64{},
+		map[string]map[string]uint64{},
+		nil,
+		true,
 		sync.Mutex{},
 	}
 }
 
 type InMemoryURLStore struct {
-	store map[string]string
-	mu    sync.Mutex
+	store      map[string]model.URLPair
+	tombstones map[string]model.Tombstone
+	clicks     map[string]uint64
+	variants   map[string]map[string]uint64
+	suffixes   []string // sorted suffixes of store for Scan, rebuilt when stale
+	stale      bool
+	mu         sync.Mutex
 }
 
 func (i *InMemoryURLStore) Load(shortLink string) (string, bool) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	baseURL, found := i.store[shortLink]
-	return baseURL, found
+	urlPair, found := i.store[shortLink]
+	if !found || urlPair.Expired(time.Now()) {
+		return "", false
+	}
+	return urlPair.BaseURL, true
+}
+
+func (i *InMemoryURLStore) Lookup(shortLink string) (string, error) {
+	baseURL, found := i.Load(shortLink)
+	if !found {
+		return "", store.ErrNotFound
+	}
+	return baseURL, nil
+}
+
+// LoadURLPair returns a copy of the link, metadata included.
+func (i *InMemoryURLStore) LoadURLPair(shortLink string) (model.URLPair, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	urlPair, found := i.store[shortLink]
+	if !found || urlPair.Expired(time.Now()) {
+		return model.URLPair{}, false
+	}
+	urlPair.Metadata = maps.Clone(urlPair.Metadata)
+	return urlPair, true
+}
+
+func (i *InMemoryURLStore) LookupURLPair(shortLink string) (model.URLPair, error) {
+	urlPair, found := i.LoadURLPair(shortLink)
+	if !found {
+		return model.URLPair{}, store.ErrNotFound
+	}
+	return urlPair, nil
+}
+
+func (i *InMemoryURLStore) CountClick(shortLink, variant string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.clicks[shortLink]++
+	if variant != "" {
+		if i.variants[shortLink] == nil {
+			i.variants[shortLink] = map[string]uint64{}
+		}
+		i.variants[shortLink][variant]++
+	}
+	return nil
+}
+
+func (i *InMemoryURLStore) Clicks(shortLink string) (uint64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.clicks[shortLink], nil
+}
+
+func (i *InMemoryURLStore) VariantClicks(shortLink string) (map[string]uint64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return maps.Clone(i.variants[shortLink]), nil
+}
+
+func (i *InMemoryURLStore) Save(urlPair *model.URLPair) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if urlPair.Expired(time.Now()) {
+		i.delete(urlPair.ShortSuffix)
+		return nil
+	}
+	if _, found := i.store[urlPair.ShortSuffix]; !found {
+		i.stale = true
+	}
+	i.store[urlPair.ShortSuffix] = model.URLPair{ShortSuffix: urlPair.ShortSuffix, BaseURL: urlPair.BaseURL, Metadata: maps.Clone(urlPair.Metadata)}
+	return nil
+}
+
+func (i *InMemoryURLStore) Swap(old, next *model.URLPair) (bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	current, found := i.store[old.ShortSuffix]
+	if !found || current.Expired(time.Now()) || !current.SameLink(*old) {
+		return false, nil
+	}
+	i.store[old.ShortSuffix] = model.URLPair{ShortSuffix: old.ShortSuffix, BaseURL: next.BaseURL, Metadata: maps.Clone(next.Metadata)}
+	return true, nil
+}
+
+// ForEachTombstone calls fn with the suffix of every disabled link, in
+// suffix order.
+func (i *InMemoryURLStore) ForEachTombstone(ctx context.Context, fn func(shortSuffix string)) error {
+	i.mu.Lock()
+	suffixes := slices.Sorted(maps.Keys(i.tombstones))
+	i.mu.Unlock()
+	for _, shortSuffix := range suffixes {
+		fn(shortSuffix)
+	}
+	return nil
+}
+
+func (i *InMemoryURLStore) Delete(shortLink string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.delete(shortLink)
+	return nil
+}
+
+// delete removes a link, callers hold i.mu.
+func (i *InMemoryURLStore) delete(shortLink string) {
+	if _, found := i.store[shortLink]; found {
+		delete(i.store, shortLink)
+		i.stale = true
+	}
+}
+
+// Scan returns up to count links in suffix order, the cursor is the last
+// suffix of the previous page. The suffixes are only sorted again after links
+// were added or removed, not for every page.
+func (i *InMemoryURLStore) Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.stale {
+		i.suffixes = slices.Sorted(maps.Keys(i.store))
+		i.stale = false
+	}
+	if count < 1 {
+		count = 1
+	}
+
+	now := time.Now()
+	start := sort.SearchStrings(i.suffixes, cursor)
+	pairs := make([]model.URLPair, 0, min(count, len(i.suffixes)-start))
+	for _, shortSuffix := range i.suffixes[start:] {
+		urlPair := i.store[shortSuffix]
+		if shortSuffix == cursor || urlPair.Expired(now) {
+			continue
+		}
+		if len(pairs) == count {
+			return pairs, pairs[count-1].ShortSuffix, nil
+		}
+		pairs = append(pairs, urlPair)
+	}
+	return pairs, "", nil
+}
+
+func (i *InMemoryURLStore) Disable(shortLink, reason string) (model.Tombstone, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	urlPair, found := i.store[shortLink]
+	if !found || urlPair.Expired(time.Now()) {
+		return model.Tombstone{}, store.ErrNotFound
+	}
+	tombstone := model.Tombstone{
+		ShortSuffix: shortLink,
+		BaseURL:     urlPair.BaseURL,
+		Metadata:    urlPair.Metadata,
+		Reason:      reason,
+		DisabledAt:  time.Now().UTC(),
+	}
+	i.tombstones[shortLink] = tombstone
+	i.delete(shortLink)
+	return tombstone, nil
+}
+
+func (i *InMemoryURLStore) Restore(shortLink string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	tombstone, found := i.tombstones[shortLink]
+	if !found {
+		return store.ErrNotFound
+	}
+	if urlPair, taken := i.store[shortLink]; taken && !urlPair.Expired(time.Now()) {
+		return store.ErrExists
+	}
+	if _, found := i.store[shortLink]; !found {
+		i.stale = true
+	}
+	i.store[shortLink] = tombstone.URLPair()
+	delete(i.tombstones, shortLink)
+	return nil
 }
 
-func (i *InMemoryURLStore) Save(shortLink, baseURL string) {
+func (i *InMemoryURLStore) Tombstone(shortLink string) (model.Tombstone, bool) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	i.store[shortLink] = baseURL
+	tombstone, found := i.tombstones[shortLink]
+	return tombstone, found
 }
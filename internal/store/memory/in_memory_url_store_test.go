@@ -0,0 +1,15 @@
+package memory_store_test
+
+import (
+	"testing"
+
+	"github.com/0xKev/url-shortener/internal/store"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+func TestInMemoryURLStoreConformance(t *testing.T) {
+	testutil.RunURLStoreConformance(t, func(t *testing.T) store.URLStore {
+		return memoryStore.NewInMemoryURLStore()
+	})
+}
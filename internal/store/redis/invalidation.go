@@ -0,0 +1,185 @@
+package redis_store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	invalidationChannel    = "invalidate"
+	defaultHealthCheck     = 15 * time.Second
+	defaultReconnectDelay  = 500 * time.Millisecond
+	maxReconnectDelay      = 30 * time.Second
+	invalidationPublishTTL = 5 * time.Second
+)
+
+// Evictor is the part of a cache the invalidation bus drives.
+type Evictor interface {
+	Invalidate(shortSuffix string)
+	Purge()
+}
+
+type invalidationMessage struct {
+	Origin      string `json:"origin"`
+	ShortSuffix string `json:"shortSuffix,omitempty"`
+	Purge       bool   `json:"purge,omitempty"`
+}
+
+// InvalidationBus fans cache evictions out to every replica over Redis
+// pub/sub. Pub/sub is fire-and-forget, so whenever the subscription is
+// (re)established the local cache is purged: anything published while we
+// were disconnected is lost and the cache can't be trusted.
+type InvalidationBus struct {
+	client      redis.UniversalClient
+	channel     string
+	origin      string
+	healthCheck time.Duration
+}
+
+// InvalidationBus returns a bus on the store's connection, publishing on a
+// channel inside the store's keyspace.
+func (r *RedisURLStore) InvalidationBus() *InvalidationBus {
+	return &InvalidationBus{
+		client:      r.client,
+		channel:     r.keys.Aux(invalidationChannel),
+		origin:      newOrigin(),
+		healthCheck: defaultHealthCheck,
+	}
+}
+
+func newOrigin() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+func (b *InvalidationBus) Channel() string {
+	return b.channel
+}
+
+// Publish tells every other subscriber to drop shortSuffix from its cache.
+func (b *InvalidationBus) Publish(shortSuffix string) error {
+	return b.publish(invalidationMessage{Origin: b.origin, ShortSuffix: shortSuffix})
+}
+
+// PublishPurge tells every other subscriber to empty its cache.
+func (b *InvalidationBus) PublishPurge() error {
+	return b.publish(invalidationMessage{Origin: b.origin, Purge: true})
+}
+
+func (b *InvalidationBus) publish(msg invalidationMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), invalidationPublishTTL)
+	defer cancel()
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error when encoding invalidation message, %v", err)
+	}
+	if err := b.client.Publish(ctx, b.channel, payload).Err(); err != nil {
+		return fmt.Errorf("error when publishing invalidation to redis, %v", err)
+	}
+	return nil
+}
+
+// Run subscribes and applies invalidations to every evictor until ctx is
+// cancelled, reconnecting with backoff whenever the subscription drops.
+func (b *InvalidationBus) Run(ctx context.Context, evictors ...Evictor) error {
+	evictor := evictorGroup(evictors)
+	delay := defaultReconnectDelay
+	for {
+		err := b.subscribe(ctx, evictor, func() { delay = defaultReconnectDelay })
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("cache invalidation subscription lost, reconnecting in %v: %v", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// subscribe runs one subscription until it fails. onSubscribed is called once
+// the subscription is confirmed.
+func (b *InvalidationBus) subscribe(ctx context.Context, evictor Evictor, onSubscribed func()) error {
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	defer pubsub.Close()
+
+	stop := context.AfterFunc(ctx, func() { pubsub.Close() })
+	defer stop()
+
+	if _, err := pubsub.ReceiveTimeout(ctx, b.healthCheck); err != nil {
+		return fmt.Errorf("error when subscribing to %v, %v", b.channel, err)
+	}
+	evictor.Purge()
+	onSubscribed()
+
+	awaitingPong := false
+	for {
+		received, err := pubsub.ReceiveTimeout(ctx, b.healthCheck)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && !awaitingPong {
+				if err := pubsub.Ping(ctx); err != nil {
+					return fmt.Errorf("health check failed, %v", err)
+				}
+				awaitingPong = true
+				continue
+			}
+			return err
+		}
+		awaitingPong = false
+
+		msg, ok := received.(*redis.Message)
+		if !ok {
+			continue
+		}
+		b.apply(msg.Payload, evictor)
+	}
+}
+
+type evictorGroup []Evictor
+
+func (g evictorGroup) Invalidate(shortSuffix string) {
+	for _, e := range g {
+		e.Invalidate(shortSuffix)
+	}
+}
+
+func (g evictorGroup) Purge() {
+	for _, e := range g {
+		e.Purge()
+	}
+}
+
+func (b *InvalidationBus) apply(payload string, evictor Evictor) {
+	var msg invalidationMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		// can't tell what was meant, so assume the worst
+		log.Printf("malformed cache invalidation %q, purging cache: %v", payload, err)
+		evictor.Purge()
+		return
+	}
+	if msg.Origin == b.origin {
+		return
+	}
+	if msg.Purge {
+		evictor.Purge()
+		return
+	}
+	evictor.Invalidate(msg.ShortSuffix)
+}
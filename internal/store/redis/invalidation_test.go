@@ -0,0 +1,77 @@
+package redis_store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+type SpyEvictor struct {
+	invalidated chan string
+	purged      chan struct{}
+}
+
+func newSpyEvictor() *SpyEvictor {
+	return &SpyEvictor{invalidated: make(chan string, 10), purged: make(chan struct{}, 10)}
+}
+
+func (s *SpyEvictor) Invalidate(shortSuffix string) {
+	s.invalidated <- shortSuffix
+}
+
+func (s *SpyEvictor) Purge() {
+	s.purged <- struct{}{}
+}
+
+func awaitSignal[T any](t testing.TB, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %v", what)
+	}
+	var zero T
+	return zero
+}
+
+func TestInvalidationBus(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	urlStore := RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+	publisher := urlStore.InvalidationBus()
+	subscriber := urlStore.InvalidationBus()
+	evictor := newSpyEvictor()
+
+	runCtx, stop := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- subscriber.Run(runCtx, evictor) }()
+
+	awaitSignal(t, evictor.purged, "purge on subscribe")
+
+	t.Run("other replicas' invalidations evict the suffix", func(t *testing.T) {
+		testutil.AssertNoError(t, publisher.Publish(shortSuffix))
+		got := awaitSignal(t, evictor.invalidated, "invalidation")
+		testutil.AssertEqual(t, got, shortSuffix)
+	})
+
+	t.Run("purge messages empty the cache", func(t *testing.T) {
+		testutil.AssertNoError(t, publisher.PublishPurge())
+		awaitSignal(t, evictor.purged, "purge")
+	})
+
+	t.Run("own invalidations are ignored", func(t *testing.T) {
+		testutil.AssertNoError(t, subscriber.Publish("0000009"))
+		testutil.AssertNoError(t, publisher.Publish("0000010"))
+		got := awaitSignal(t, evictor.invalidated, "invalidation")
+		testutil.AssertEqual(t, got, "0000010")
+	})
+
+	stop()
+	awaitSignal(t, done, "subscriber to stop")
+}
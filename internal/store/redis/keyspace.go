@@ -0,0 +1,123 @@
+package redis_store
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultKeyPrefix = "urlshortener"
+
+	keySeparator     = ":"
+	linkKeyType      = "link"
+	tombstoneKeyType = "tombstone"
+	claimKeyType     = "claim"
+	counterType      = "counter"
+	auxKeyType       = "aux"
+	clicksCounter    = "clicks"
+	variantsCounter  = "variants"
+
+	scanCount = 500
+)
+
+// Keyspace builds every key the store writes so that links, counters and
+// auxiliary data live under a single prefix and can share a Redis DB with
+// other applications or tenants.
+type Keyspace struct {
+	prefix string
+}
+
+func NewKeyspace(prefix string) (Keyspace, error) {
+	if err := validateKeyPrefix(prefix); err != nil {
+		return Keyspace{}, err
+	}
+	return Keyspace{prefix: prefix}, nil
+}
+
+func validateKeyPrefix(prefix string) error {
+	if prefix == "" {
+		return fmt.Errorf("invalid key prefix: prefix is empty")
+	}
+	if strings.ContainsAny(prefix, "*?[] \t\n") {
+		return fmt.Errorf("invalid key prefix '%v': contains glob or whitespace characters", prefix)
+	}
+	if strings.HasSuffix(prefix, keySeparator) {
+		return fmt.Errorf("invalid key prefix '%v': trailing '%v' is added automatically", prefix, keySeparator)
+	}
+	return nil
+}
+
+func (k Keyspace) Prefix() string {
+	return k.prefix
+}
+
+func (k Keyspace) Link(shortSuffix string) string {
+	return k.key(linkKeyType, shortSuffix)
+}
+
+// Tombstone holds what remains of a disabled link.
+func (k Keyspace) Tombstone(shortSuffix string) string {
+	return k.key(tombstoneKeyType, shortSuffix)
+}
+
+// Claim reserves a suffix for a link about to be saved.
+func (k Keyspace) Claim(shortSuffix string) string {
+	return k.key(claimKeyType, shortSuffix)
+}
+
+func (k Keyspace) Counter(name string) string {
+	return k.key(counterType, name)
+}
+
+// Clicks counts the redirects of a link.
+func (k Keyspace) Clicks(shortSuffix string) string {
+	return k.key(counterType, clicksCounter, shortSuffix)
+}
+
+func (k Keyspace) ClicksPattern() string {
+	return k.Clicks("*")
+}
+
+// VariantClicks is a hash counting the redirects to each variant of a link.
+func (k Keyspace) VariantClicks(shortSuffix string) string {
+	return k.key(counterType, variantsCounter, shortSuffix)
+}
+
+func (k Keyspace) VariantClicksPattern() string {
+	return k.VariantClicks("*")
+}
+
+// Aux is for any key that is neither a link nor a counter, e.g. Aux("stats", "clicks").
+func (k Keyspace) Aux(parts ...string) string {
+	return k.key(append([]string{auxKeyType}, parts...)...)
+}
+
+func (k Keyspace) LinkPattern() string {
+	return k.Link("*")
+}
+
+func (k Keyspace) TombstonePattern() string {
+	return k.Tombstone("*")
+}
+
+// ShortSuffix reverses Link, reporting false for keys outside the link namespace.
+func (k Keyspace) ShortSuffix(key string) (string, bool) {
+	return strings.CutPrefix(key, k.Link(""))
+}
+
+// TombstoneSuffix reverses Tombstone, reporting false for any other key.
+func (k Keyspace) TombstoneSuffix(key string) (string, bool) {
+	return strings.CutPrefix(key, k.Tombstone(""))
+}
+
+// owns reports whether key was written under this keyspace's prefix.
+func (k Keyspace) owns(key string) bool {
+	return k.prefix != "" && strings.HasPrefix(key, k.prefix+keySeparator)
+}
+
+func (k Keyspace) key(parts ...string) string {
+	if k.prefix != "" {
+		parts = append([]string{k.prefix}, parts...)
+	}
+	return strings.Join(parts, keySeparator)
+}
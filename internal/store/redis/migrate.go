@@ -0,0 +1,60 @@
+package redis_store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type MigrationResult struct {
+	Migrated int
+	Skipped  []string // unprefixed keys whose link key already exists
+}
+
+// MigrateUnprefixedKeys moves short links written before key namespacing
+// (stored as bare suffixes at the top level of the DB) under the store's
+// link namespace. Keys containing the separator or holding non-string values
+// are assumed to belong to something else and are left alone. Existing link
+// keys are never overwritten, so running the migration twice is harmless.
+//
+// RENAMENX can't move keys between cluster slots, so migrate before moving
+// to Redis Cluster.
+func (r *RedisURLStore) MigrateUnprefixedKeys(ctx context.Context) (MigrationResult, error) {
+	var result MigrationResult
+
+	if _, ok := r.client.(*redis.ClusterClient); ok {
+		return result, fmt.Errorf("migrating unprefixed keys is not supported on redis cluster")
+	}
+
+	err := r.scan(ctx, "*", func(key string) error {
+		if r.keys.owns(key) || strings.Contains(key, keySeparator) {
+			return nil
+		}
+
+		keyType, err := r.client.Type(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("error when migrating key '%v', %v", key, err)
+		}
+		if keyType != "string" {
+			return nil
+		}
+
+		renamed, err := r.client.RenameNX(ctx, key, r.keys.Link(key)).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("error when migrating key '%v', %v", key, err)
+		}
+		if !renamed {
+			result.Skipped = append(result.Skipped, key)
+			return nil
+		}
+		result.Migrated++
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("error when scanning redis for unprefixed keys, %v", err)
+	}
+
+	return result, nil
+}
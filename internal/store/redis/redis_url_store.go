@@ -3,26 +3,50 @@ package redis_store
 // TODO: Look into implementing persistent Redis storage as an optional feature
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
 	"github.com/redis/go-redis/v9"
 )
 
+// claimTTL is how long a claimed suffix stays reserved for its link.
+const claimTTL = time.Hour
+
 type RedisURLStore struct {
-	client *redis.Client
+	client redis.UniversalClient
+	keys   Keyspace
 }
 
 func NewRedisURLStore(config *redis.Options) (*RedisURLStore, error) {
 	if config == nil {
 		return nil, fmt.Errorf("invalid config")
 	}
-	client := redis.NewClient(config)
-	if err := validateRedisConfig(*client); err != nil {
+	return newRedisURLStore(redis.NewClient(config))
+}
+
+// NewUniversalRedisURLStore connects to a single node, a Sentinel managed
+// master (config.MasterName set) or a cluster (several config.Addrs).
+func NewUniversalRedisURLStore(config *redis.UniversalOptions) (*RedisURLStore, error) {
+	if config == nil || len(config.Addrs) == 0 {
+		return nil, fmt.Errorf("invalid config")
+	}
+	return newRedisURLStore(redis.NewUniversalClient(config))
+}
+
+func newRedisURLStore(client redis.UniversalClient) (*RedisURLStore, error) {
+	if err := validateRedisConfig(client); err != nil {
+		client.Close()
 		return nil, err
 	}
-	return &RedisURLStore{client: client}, nil
+	return &RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}, nil
 }
 
 func NewRedisConfig(addr, password string, db int) *redis.Options {
@@ -33,7 +57,59 @@ func NewRedisConfig(addr, password string, db int) *redis.Options {
 	}
 }
 
-func validateRedisConfig(client redis.Client) error {
+// NewFailoverConfig is for a master monitored by Redis Sentinel. Sentinels
+// are assumed to share the master's credentials.
+func NewFailoverConfig(masterName string, sentinelAddrs []string, username, password string, db int) *redis.UniversalOptions {
+	return &redis.UniversalOptions{
+		MasterName:       masterName,
+		Addrs:            sentinelAddrs,
+		Username:         username,
+		Password:         password,
+		SentinelUsername: username,
+		SentinelPassword: password,
+		DB:               db,
+	}
+}
+
+// NewClusterConfig is for a Redis Cluster, addrs seeds the cluster topology.
+// Clusters only have DB 0.
+func NewClusterConfig(addrs []string, username, password string) *redis.UniversalOptions {
+	return &redis.UniversalOptions{
+		Addrs:    addrs,
+		Username: username,
+		Password: password,
+	}
+}
+
+// NewTLSConfig verifies the server against caFile (or the system roots when
+// empty) and presents certFile/keyFile as client certificate when given.
+func NewTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read redis CA file %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid redis CA file '%v': no certificates found", caFile)
+		}
+		config.RootCAs = roots
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load redis client certificate %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func validateRedisConfig(client redis.UniversalClient) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -44,25 +120,317 @@ func validateRedisConfig(client redis.Client) error {
 	return nil
 }
 
+func (r *RedisURLStore) SetKeyPrefix(prefix string) error {
+	keys, err := NewKeyspace(prefix)
+	if err != nil {
+		return err
+	}
+	r.keys = keys
+	return nil
+}
+
+func (r *RedisURLStore) Keyspace() Keyspace {
+	return r.keys
+}
+
+func (r *RedisURLStore) Close() error {
+	return r.client.Close()
+}
+
 func (r *RedisURLStore) Save(urlPair *model.URLPair) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := r.client.Set(ctx, urlPair.ShortSuffix, urlPair.BaseURL, 0).Err()
+	value, err := encodeLink(urlPair)
 	if err != nil {
-		return fmt.Errorf("error when saving short link to redis, %v", err)
+		return err
+	}
+	// redis expires the key itself, an already expired link is removed
+	var ttl time.Duration
+	if expiresAt, ok := urlPair.ExpiresAt(); ok {
+		if ttl = time.Until(expiresAt); ttl <= 0 {
+			return r.Delete(urlPair.ShortSuffix)
+		}
+	}
+	err = r.client.Set(ctx, r.keys.Link(urlPair.ShortSuffix), value, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("%w: error when saving short link to redis, %v", store.ErrUnavailable, err)
 	}
 
 	return nil
 }
 
-func (r *RedisURLStore) Load(shortSuffix string) (string, bool) {
+// Swap watches the link so that nothing written between the comparison and
+// the save is overwritten, see store.URLSwapper.
+func (r *RedisURLStore) Swap(old, next *model.URLPair) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	val, err := r.client.Get(ctx, shortSuffix).Result()
+
+	expected, err := encodeLink(old)
+	if err != nil {
+		return false, err
+	}
+	value, err := encodeLink(next)
+	if err != nil {
+		return false, err
+	}
+	key := r.keys.Link(old.ShortSuffix)
+	swapped := false
+	err = r.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil || err == nil && current != expected {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, value, redis.KeepTTL)
+			return nil
+		})
+		swapped = err == nil
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: error when swapping short link in redis, %v", store.ErrUnavailable, err)
+	}
+	return swapped, nil
+}
+
+func (r *RedisURLStore) Load(shortSuffix string) (string, bool) {
+	val, err := r.Lookup(shortSuffix)
 	if err != nil {
 		return "", false
 	}
 
 	return val, true
 }
+
+// Lookup is Load with the reason for a miss: store.ErrNotFound or
+// store.ErrUnavailable.
+func (r *RedisURLStore) Lookup(shortSuffix string) (string, error) {
+	link, err := r.LookupURLPair(shortSuffix)
+	if err != nil {
+		return "", err
+	}
+	return link.BaseURL, nil
+}
+
+func (r *RedisURLStore) LoadURLPair(shortSuffix string) (model.URLPair, bool) {
+	link, err := r.LookupURLPair(shortSuffix)
+	return link, err == nil
+}
+
+// LookupURLPair is Lookup returning the metadata of the link too.
+func (r *RedisURLStore) LookupURLPair(shortSuffix string) (model.URLPair, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	val, err := r.client.Get(ctx, r.keys.Link(shortSuffix)).Result()
+	if errors.Is(err, redis.Nil) {
+		return model.URLPair{}, store.ErrNotFound
+	}
+	if err != nil {
+		return model.URLPair{}, fmt.Errorf("%w: error when loading short link from redis, %v", store.ErrUnavailable, err)
+	}
+	link, err := decodeLink(val)
+	if err != nil {
+		return model.URLPair{}, err
+	}
+	link.ShortSuffix = shortSuffix
+
+	return link, nil
+}
+
+func (r *RedisURLStore) CountClick(shortSuffix, variant string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, r.keys.Clicks(shortSuffix))
+		if variant != "" {
+			pipe.HIncrBy(ctx, r.keys.VariantClicks(shortSuffix), variant, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: error when counting click in redis, %v", store.ErrUnavailable, err)
+	}
+	return nil
+}
+
+func (r *RedisURLStore) Clicks(shortSuffix string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	clicks, err := r.client.Get(ctx, r.keys.Clicks(shortSuffix)).Uint64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: error when loading clicks from redis, %v", store.ErrUnavailable, err)
+	}
+	return clicks, nil
+}
+
+func (r *RedisURLStore) VariantClicks(shortSuffix string) (map[string]uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	values, err := r.client.HGetAll(ctx, r.keys.VariantClicks(shortSuffix)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: error when loading clicks from redis, %v", store.ErrUnavailable, err)
+	}
+	clicks := make(map[string]uint64, len(values))
+	for variant, value := range values {
+		if clicks[variant], err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid clicks '%v' of variant %v of link %v", value, variant, shortSuffix)
+		}
+	}
+	return clicks, nil
+}
+
+// ClaimSuffix sets a claim key that expires once the link has long been
+// saved, the link key keeps the suffix taken from then on.
+func (r *RedisURLStore) ClaimSuffix(shortSuffix string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	exists, err := r.client.Exists(ctx, r.keys.Link(shortSuffix), r.keys.Tombstone(shortSuffix)).Result()
+	if err != nil {
+		return false, fmt.Errorf("%w: error when claiming short suffix in redis, %v", store.ErrUnavailable, err)
+	}
+	if exists > 0 {
+		return false, nil
+	}
+	claimed, err := r.client.SetNX(ctx, r.keys.Claim(shortSuffix), "1", claimTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("%w: error when claiming short suffix in redis, %v", store.ErrUnavailable, err)
+	}
+	return claimed, nil
+}
+
+func (r *RedisURLStore) Delete(shortSuffix string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.client.Del(ctx, r.keys.Link(shortSuffix)).Err(); err != nil {
+		return fmt.Errorf("%w: error when deleting short link from redis, %v", store.ErrUnavailable, err)
+	}
+
+	return nil
+}
+
+// ForEachSuffix calls fn with the suffix of every stored link. Links saved
+// while the scan runs may or may not be included.
+func (r *RedisURLStore) ForEachSuffix(ctx context.Context, fn func(shortSuffix string)) error {
+	err := r.scan(ctx, r.keys.LinkPattern(), func(key string) error {
+		if shortSuffix, ok := r.keys.ShortSuffix(key); ok {
+			fn(shortSuffix)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error when scanning redis for short links, %v", err)
+	}
+	return nil
+}
+
+// ForEachTombstone calls fn with the suffix of every disabled link.
+func (r *RedisURLStore) ForEachTombstone(ctx context.Context, fn func(shortSuffix string)) error {
+	err := r.scan(ctx, r.keys.TombstonePattern(), func(key string) error {
+		if shortSuffix, ok := r.keys.TombstoneSuffix(key); ok {
+			fn(shortSuffix)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error when scanning redis for disabled links, %v", err)
+	}
+	return nil
+}
+
+// Scan returns a page of roughly count links starting at cursor, see
+// store.URLScanner. The cursor is the one of redis SCAN, so it stays valid
+// while keys are added or removed. A cluster has one cursor per node and
+// can't be scanned this way.
+func (r *RedisURLStore) Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error) {
+	if _, ok := r.client.(*redis.ClusterClient); ok {
+		return nil, "", errors.New("error when scanning redis, paged scans are not supported on a cluster")
+	}
+
+	var position uint64
+	if cursor != "" {
+		var err error
+		if position, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", fmt.Errorf("error when scanning redis, invalid cursor %q", cursor)
+		}
+	}
+
+	keys, position, err := r.client.Scan(ctx, position, r.keys.LinkPattern(), int64(count)).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: error when scanning redis for short links, %v", store.ErrUnavailable, err)
+	}
+	next := ""
+	if position != 0 {
+		next = strconv.FormatUint(position, 10)
+	}
+	if len(keys) == 0 {
+		return nil, next, nil
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: error when loading scanned short links from redis, %v", store.ErrUnavailable, err)
+	}
+	pairs := make([]model.URLPair, 0, len(keys))
+	for i, key := range keys {
+		value, ok := values[i].(string)
+		if !ok {
+			continue // expired or deleted since SCAN returned it
+		}
+		shortSuffix, ok := r.keys.ShortSuffix(key)
+		if !ok {
+			continue
+		}
+		link, err := decodeLink(value)
+		if err != nil {
+			return nil, "", fmt.Errorf("%v for %s", err, shortSuffix)
+		}
+		link.ShortSuffix = shortSuffix
+		pairs = append(pairs, link)
+	}
+	return pairs, next, nil
+}
+
+// scan calls fn for every key matching pattern. A cluster is scanned master
+// by master, since SCAN only covers the node it runs on.
+func (r *RedisURLStore) scan(ctx context.Context, pattern string, fn func(key string) error) error {
+	scanNode := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, pattern, scanCount).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(ctx, r.client)
+	}
+	// masters are scanned concurrently, fn isn't expected to be safe for that
+	var mu sync.Mutex
+	unsafeFn := fn
+	fn = func(key string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return unsafeFn(key)
+	}
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		return scanNode(ctx, node)
+	})
+}
@@ -2,10 +2,12 @@ package redis_store
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
 	"github.com/0xKev/url-shortener/internal/testutil"
 	"github.com/redis/go-redis/v9"
 )
@@ -45,9 +47,9 @@ func retrieveString(t testing.TB, ctx context.Context, client *redis.Client, sho
 	}
 }
 
-func setupClient() (*redis.Client, context.Context, context.CancelFunc) {
+func setupClient(t testing.TB) (*redis.Client, context.Context, context.CancelFunc) {
 	client := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
+		Addr:     testutil.RedisAddr(t),
 		Password: "",
 		DB:       9, // use only DB 9 for tests
 	})
@@ -68,7 +70,7 @@ func setupConfigurableClient(config *redis.Options) (*redis.Client, context.Cont
 
 func TestSavingAndRetrievingFromRedis(t *testing.T) {
 	// IMPORTANT - ENSURE DB IS MEANT ONLY FOR TESTING BECAUSE FLUSHALL RUNS EVERYTIME
-	client, ctx, cancel := setupClient()
+	client, ctx, cancel := setupClient(t)
 
 	defer client.Close()
 
@@ -81,18 +83,18 @@ func TestSavingAndRetrievingFromRedis(t *testing.T) {
 }
 
 func TestRedisURLStoreImplementation(t *testing.T) {
-	client, ctx, cancel := setupClient()
+	client, ctx, cancel := setupClient(t)
 	defer cancel()
 	defer client.Close()
 	client.FlushAll(ctx)
 
-	urlStore := RedisURLStore{client: client}
+	urlStore := RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
 
 	err := urlStore.Save(&model.URLPair{BaseURL: baseURL, ShortSuffix: shortSuffix})
 	if err != nil {
 		t.Fatalf("Save method error, %v", err)
 	}
-	retrieveString(t, ctx, urlStore.client, shortSuffix, baseURL)
+	retrieveString(t, ctx, client, DefaultKeyPrefix+":link:"+shortSuffix, baseURL)
 
 	val, found := urlStore.Load(shortSuffix)
 
@@ -108,7 +110,7 @@ func TestRedisURLStoreImplementation(t *testing.T) {
 func TestRedisStoreConfig(t *testing.T) {
 	t.Run("create redis store with pre set config", func(t *testing.T) {
 		config := &redis.Options{
-			Addr:     "localhost:6379",
+			Addr:     testutil.RedisAddr(t),
 			Password: "",
 			DB:       9, // use only DB 9 for tests
 		}
@@ -125,9 +127,283 @@ func TestRedisStoreConfig(t *testing.T) {
 	})
 
 	t.Run("with new custom config", func(t *testing.T) {
-		config := NewRedisConfig("localhost:6379", "", 9)
+		config := NewRedisConfig(testutil.RedisAddr(t), "", 9)
 
 		_, err := NewRedisURLStore(config)
 		testutil.AssertNoError(t, err)
 	})
+
+	t.Run("with universal config for a single node", func(t *testing.T) {
+		config := &redis.UniversalOptions{Addrs: []string{testutil.RedisAddr(t)}, DB: 9}
+
+		urlStore, err := NewUniversalRedisURLStore(config)
+		testutil.AssertNoError(t, err)
+		if _, ok := urlStore.client.(*redis.Client); !ok {
+			t.Errorf("expected a single node client but got %T", urlStore.client)
+		}
+	})
+
+	t.Run("expect error when creating universal redis store without addresses", func(t *testing.T) {
+		_, err := NewUniversalRedisURLStore(nil)
+		testutil.AssertError(t, err)
+
+		_, err = NewUniversalRedisURLStore(&redis.UniversalOptions{})
+		testutil.AssertError(t, err)
+	})
+
+	t.Run("failover and cluster configs pick the matching client", func(t *testing.T) {
+		failover := NewFailoverConfig("mymaster", []string{"sentinel-1:26379", "sentinel-2:26379"}, "app", "secret", 9)
+		if _, ok := redis.NewUniversalClient(failover).(*redis.Client); !ok {
+			t.Error("expected a failover client for a sentinel config")
+		}
+		testutil.AssertEqual(t, failover.SentinelPassword, "secret")
+
+		cluster := NewClusterConfig([]string{"node-1:6379", "node-2:6379"}, "app", "secret")
+		if _, ok := redis.NewUniversalClient(cluster).(*redis.ClusterClient); !ok {
+			t.Error("expected a cluster client for a cluster config")
+		}
+	})
+
+	t.Run("tls config", func(t *testing.T) {
+		config, err := NewTLSConfig("", "", "", "redis.internal")
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, config.ServerName, "redis.internal")
+
+		_, err = NewTLSConfig("does-not-exist.pem", "", "", "")
+		testutil.AssertError(t, err)
+
+		_, err = NewTLSConfig("", "does-not-exist.crt", "does-not-exist.key", "")
+		testutil.AssertError(t, err)
+	})
+}
+
+func TestRedisKeyNamespacing(t *testing.T) {
+	t.Run("builds link, counter and aux keys under the prefix", func(t *testing.T) {
+		keys, err := NewKeyspace("tenant-a")
+		testutil.AssertNoError(t, err)
+
+		testutil.AssertEqual(t, keys.Link(shortSuffix), "tenant-a:link:"+shortSuffix)
+		testutil.AssertEqual(t, keys.Counter("shortener"), "tenant-a:counter:shortener")
+		testutil.AssertEqual(t, keys.Aux("stats", "clicks"), "tenant-a:aux:stats:clicks")
+		testutil.AssertEqual(t, keys.LinkPattern(), "tenant-a:link:*")
+
+		suffix, ok := keys.ShortSuffix(keys.Link(shortSuffix))
+		testutil.AssertEqual(t, ok, true)
+		testutil.AssertEqual(t, suffix, shortSuffix)
+
+		_, ok = keys.ShortSuffix(keys.Counter("shortener"))
+		testutil.AssertEqual(t, ok, false)
+	})
+
+	t.Run("rejects invalid prefixes", func(t *testing.T) {
+		for _, prefix := range []string{"", "with space", "glob*", "trailing:"} {
+			_, err := NewKeyspace(prefix)
+			testutil.AssertError(t, err)
+		}
+	})
+
+	t.Run("stores with different prefixes do not see each other's links", func(t *testing.T) {
+		client, ctx, cancel := setupClient(t)
+		defer cancel()
+		defer client.Close()
+		client.FlushAll(ctx)
+
+		storeA := RedisURLStore{client: client, keys: Keyspace{prefix: "a"}}
+		storeB := RedisURLStore{client: client, keys: Keyspace{prefix: "b"}}
+
+		testutil.AssertNoError(t, storeA.Save(&model.URLPair{ShortSuffix: shortSuffix, BaseURL: baseURL}))
+
+		_, found := storeB.Load(shortSuffix)
+		testutil.AssertEqual(t, found, false)
+
+		got, found := storeA.Load(shortSuffix)
+		testutil.AssertEqual(t, found, true)
+		testutil.AssertEqual(t, got, baseURL)
+	})
+}
+
+func TestMigrateUnprefixedKeys(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	urlStore := RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+
+	storeString(t, ctx, client, "0000001", "google.com")
+	storeString(t, ctx, client, "0000002", "github.com")
+	storeString(t, ctx, client, "other-app:session", "keep me")
+	client.HSet(ctx, "somehash", "field", "value")
+	// already migrated by a previous run, must not be overwritten
+	storeString(t, ctx, client, "0000003", "stale.com")
+	storeString(t, ctx, client, urlStore.keys.Link("0000003"), "youtube.com")
+
+	result, err := urlStore.MigrateUnprefixedKeys(ctx)
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, result.Migrated, 2)
+	testutil.AssertEqual(t, len(result.Skipped), 1)
+
+	for suffix, want := range map[string]string{"0000001": "google.com", "0000002": "github.com", "0000003": "youtube.com"} {
+		got, found := urlStore.Load(suffix)
+		testutil.AssertEqual(t, found, true)
+		testutil.AssertEqual(t, got, want)
+	}
+	retrieveString(t, ctx, client, "other-app:session", "keep me")
+
+	result, err = urlStore.MigrateUnprefixedKeys(ctx)
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, result.Migrated, 0)
+}
+
+func TestRedisURLStoreDelete(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	urlStore := RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+
+	testutil.AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: shortSuffix, BaseURL: baseURL}))
+	testutil.AssertNoError(t, urlStore.Delete(shortSuffix))
+
+	_, found := urlStore.Load(shortSuffix)
+	testutil.AssertEqual(t, found, false)
+
+	testutil.AssertNoError(t, urlStore.Delete(shortSuffix))
+}
+
+func TestRedisURLStoreClaimSuffix(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	urlStore := RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+	testutil.AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: shortSuffix, BaseURL: baseURL}))
+
+	claimed, err := urlStore.ClaimSuffix("0000001")
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, claimed, true)
+
+	claimed, err = urlStore.ClaimSuffix("0000001")
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, claimed, false)
+
+	claimed, err = urlStore.ClaimSuffix(shortSuffix)
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, claimed, false)
+}
+
+func TestRedisURLStoreForEachSuffix(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	urlStore := RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+	want := map[string]bool{"0000001": true, "0000002": true, "0000003": true}
+	for suffix := range want {
+		testutil.AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: suffix, BaseURL: baseURL}))
+	}
+	storeString(t, ctx, client, urlStore.keys.Counter("shortener"), "500")
+
+	got := map[string]bool{}
+	err := urlStore.ForEachSuffix(ctx, func(shortSuffix string) { got[shortSuffix] = true })
+	testutil.AssertNoError(t, err)
+
+	testutil.AssertEqual(t, len(got), len(want))
+	for suffix := range want {
+		testutil.AssertEqual(t, got[suffix], true)
+	}
+}
+
+func TestRedisURLStoreForEachTombstone(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	urlStore := RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+	testutil.AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: baseURL}))
+	testutil.AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: baseURL}))
+	_, err := urlStore.Disable("0000002", model.DisabledForAbuse)
+	testutil.AssertNoError(t, err)
+
+	var got []string
+	err = urlStore.ForEachTombstone(ctx, func(shortSuffix string) { got = append(got, shortSuffix) })
+	testutil.AssertNoError(t, err)
+
+	testutil.AssertEqual(t, len(got), 1)
+	testutil.AssertEqual(t, got[0], "0000002")
+}
+
+func TestRedisURLStoreScan(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	urlStore := RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+	for i := 0; i < 50; i++ {
+		suffix := fmt.Sprintf("%07d", i)
+		testutil.AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: suffix, BaseURL: baseURL + "/" + suffix}))
+	}
+	storeString(t, ctx, client, urlStore.keys.Counter("shortener"), "500")
+
+	got := map[string]string{}
+	cursor := ""
+	for {
+		pairs, next, err := urlStore.Scan(ctx, cursor, 10)
+		testutil.AssertNoError(t, err)
+		for _, pair := range pairs {
+			got[pair.ShortSuffix] = pair.BaseURL
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	testutil.AssertEqual(t, len(got), 50)
+	testutil.AssertEqual(t, got["0000042"], baseURL+"/0000042")
+
+	_, _, err := urlStore.Scan(ctx, "not a cursor", 10)
+	testutil.AssertError(t, err)
+}
+
+func TestRedisURLStoreMetadata(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	urlStore := RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+	testutil.AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: shortSuffix, BaseURL: baseURL, Metadata: map[string]string{"owner": "ops"}}))
+	storeString(t, ctx, client, urlStore.keys.Link("plain"), baseURL)
+
+	got, found := urlStore.Load(shortSuffix)
+	testutil.AssertEqual(t, found, true)
+	testutil.AssertEqual(t, got, baseURL)
+
+	pairs, _, err := urlStore.Scan(ctx, "", 10)
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, len(pairs), 2)
+	for _, pair := range pairs {
+		testutil.AssertEqual(t, pair.BaseURL, baseURL)
+		if pair.ShortSuffix == shortSuffix {
+			testutil.AssertEqual(t, pair.Metadata["owner"], "ops")
+		} else {
+			testutil.AssertEqual(t, len(pair.Metadata), 0)
+		}
+	}
+}
+
+func TestRedisURLStoreConformance(t *testing.T) {
+	testutil.RunURLStoreConformance(t, func(t *testing.T) store.URLStore {
+		client, ctx, cancel := setupClient(t)
+		defer cancel()
+		t.Cleanup(func() { client.Close() })
+		client.FlushAll(ctx)
+		return &RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+	})
 }
@@ -0,0 +1,567 @@
+package redis_store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	"github.com/cespare/xxhash/v2"
+	"github.com/dgryski/go-rendezvous"
+	"github.com/redis/go-redis/v9"
+)
+
+type Shard struct {
+	Name  string
+	Store *RedisURLStore
+}
+
+// ShardedURLStore spreads links over independent Redis instances, picking the
+// shard for a suffix with rendezvous hashing so adding a shard only moves the
+// links the new shard wins.
+//
+// Adding a shard to a live deployment takes three steps: restart the servers
+// with the new shard list and SetPreviousShards naming the old list, run
+// Rebalance (see cmd/urlShortenerRebalance), then restart without
+// SetPreviousShards. In between, lookups that miss on the new owner fall back
+// to the owner under the previous shard list.
+type ShardedURLStore struct {
+	mu       sync.RWMutex
+	shards   map[string]*RedisURLStore
+	names    []string
+	ring     *rendezvous.Rendezvous
+	previous *rendezvous.Rendezvous
+}
+
+type RebalanceResult struct {
+	Scanned int
+	Moved   int
+	// Superseded counts links that had already been rewritten on their new
+	// shard, so the old copy was dropped instead of moved.
+	Superseded int
+}
+
+func NewShardedURLStore(shards []Shard) (*ShardedURLStore, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("invalid shards: at least one shard is required")
+	}
+
+	s := &ShardedURLStore{shards: map[string]*RedisURLStore{}}
+	for _, shard := range shards {
+		if err := s.addShard(shard); err != nil {
+			return nil, err
+		}
+	}
+	s.ring = newRing(s.names)
+	return s, nil
+}
+
+func newRing(names []string) *rendezvous.Rendezvous {
+	return rendezvous.New(names, xxhash.Sum64String)
+}
+
+func (s *ShardedURLStore) addShard(shard Shard) error {
+	if shard.Name == "" || shard.Store == nil {
+		return fmt.Errorf("invalid shard: name and store are required")
+	}
+	if _, exists := s.shards[shard.Name]; exists {
+		return fmt.Errorf("invalid shard: duplicate shard name '%v'", shard.Name)
+	}
+	s.shards[shard.Name] = shard.Store
+	s.names = append(s.names, shard.Name)
+	return nil
+}
+
+// SetPreviousShards names the shards links were spread over before the most
+// recent shard was added, so links not yet rebalanced are still found.
+func (s *ShardedURLStore) SetPreviousShards(names []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range names {
+		if _, ok := s.shards[name]; !ok {
+			return fmt.Errorf("invalid previous shards: unknown shard '%v'", name)
+		}
+	}
+	s.previous = newRing(names)
+	return nil
+}
+
+// AddShard brings a new, empty shard into the ring. New writes go to their new
+// owner straight away, older links are found through the previous shard list
+// until Rebalance has moved them.
+func (s *ShardedURLStore) AddShard(shard Shard) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := slices.Clone(s.names)
+	if err := s.addShard(shard); err != nil {
+		return err
+	}
+	if s.previous == nil {
+		s.previous = newRing(previous)
+	}
+	s.ring = newRing(s.names)
+	return nil
+}
+
+func (s *ShardedURLStore) Shards() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.names)
+}
+
+// owners returns the shard that owns shortSuffix and, while rebalancing, the
+// shard that owned it before if that differs.
+func (s *ShardedURLStore) owners(shortSuffix string) (*RedisURLStore, *RedisURLStore) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	owner := s.shards[s.ring.Lookup(shortSuffix)]
+	if s.previous == nil {
+		return owner, nil
+	}
+	if previous := s.shards[s.previous.Lookup(shortSuffix)]; previous != owner {
+		return owner, previous
+	}
+	return owner, nil
+}
+
+func (s *ShardedURLStore) Save(urlPair *model.URLPair) error {
+	owner, _ := s.owners(urlPair.ShortSuffix)
+	return owner.Save(urlPair)
+}
+
+// Swap only replaces links on their owning shard, a link still waiting to be
+// moved there by Rebalance is reported as changed.
+func (s *ShardedURLStore) Swap(old, next *model.URLPair) (bool, error) {
+	owner, _ := s.owners(old.ShortSuffix)
+	return owner.Swap(old, next)
+}
+
+func (s *ShardedURLStore) Load(shortSuffix string) (string, bool) {
+	owner, previous := s.owners(shortSuffix)
+	if baseURL, found := owner.Load(shortSuffix); found || previous == nil {
+		return baseURL, found
+	}
+	return previous.Load(shortSuffix)
+}
+
+func (s *ShardedURLStore) Lookup(shortSuffix string) (string, error) {
+	owner, previous := s.owners(shortSuffix)
+	baseURL, err := owner.Lookup(shortSuffix)
+	if errors.Is(err, store.ErrNotFound) && previous != nil {
+		return previous.Lookup(shortSuffix)
+	}
+	return baseURL, err
+}
+
+func (s *ShardedURLStore) LoadURLPair(shortSuffix string) (model.URLPair, bool) {
+	link, err := s.LookupURLPair(shortSuffix)
+	return link, err == nil
+}
+
+func (s *ShardedURLStore) LookupURLPair(shortSuffix string) (model.URLPair, error) {
+	owner, previous := s.owners(shortSuffix)
+	link, err := owner.LookupURLPair(shortSuffix)
+	if errors.Is(err, store.ErrNotFound) && previous != nil {
+		return previous.LookupURLPair(shortSuffix)
+	}
+	return link, err
+}
+
+// ClaimSuffix claims on the owning shard, and while rebalancing checks the
+// previous owner doesn't hold the link yet.
+func (s *ShardedURLStore) ClaimSuffix(shortSuffix string) (bool, error) {
+	owner, previous := s.owners(shortSuffix)
+	if previous != nil {
+		_, err := previous.Lookup(shortSuffix)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return false, err
+		}
+	}
+	return owner.ClaimSuffix(shortSuffix)
+}
+
+// CountClick counts on the owning shard, Clicks adds up what the previous
+// owner counted until the rebalance moves it over.
+func (s *ShardedURLStore) CountClick(shortSuffix, variant string) error {
+	owner, _ := s.owners(shortSuffix)
+	return owner.CountClick(shortSuffix, variant)
+}
+
+func (s *ShardedURLStore) Clicks(shortSuffix string) (uint64, error) {
+	owner, previous := s.owners(shortSuffix)
+	clicks, err := owner.Clicks(shortSuffix)
+	if err != nil || previous == nil {
+		return clicks, err
+	}
+	previousClicks, err := previous.Clicks(shortSuffix)
+	return clicks + previousClicks, err
+}
+
+func (s *ShardedURLStore) VariantClicks(shortSuffix string) (map[string]uint64, error) {
+	owner, previous := s.owners(shortSuffix)
+	clicks, err := owner.VariantClicks(shortSuffix)
+	if err != nil || previous == nil {
+		return clicks, err
+	}
+	previousClicks, err := previous.VariantClicks(shortSuffix)
+	for variant, count := range previousClicks {
+		clicks[variant] += count
+	}
+	return clicks, err
+}
+
+func (s *ShardedURLStore) Delete(shortSuffix string) error {
+	owner, previous := s.owners(shortSuffix)
+	if err := owner.Delete(shortSuffix); err != nil {
+		return err
+	}
+	if previous != nil {
+		return previous.Delete(shortSuffix)
+	}
+	return nil
+}
+
+// Disable, Restore and Tombstone fall back to the previous owner while
+// rebalancing, like Lookup.
+func (s *ShardedURLStore) Disable(shortSuffix, reason string) (model.Tombstone, error) {
+	owner, previous := s.owners(shortSuffix)
+	tombstone, err := owner.Disable(shortSuffix, reason)
+	if errors.Is(err, store.ErrNotFound) && previous != nil {
+		return previous.Disable(shortSuffix, reason)
+	}
+	return tombstone, err
+}
+
+func (s *ShardedURLStore) Restore(shortSuffix string) error {
+	owner, previous := s.owners(shortSuffix)
+	err := owner.Restore(shortSuffix)
+	if errors.Is(err, store.ErrNotFound) && previous != nil {
+		return previous.Restore(shortSuffix)
+	}
+	return err
+}
+
+func (s *ShardedURLStore) Tombstone(shortSuffix string) (model.Tombstone, bool) {
+	owner, previous := s.owners(shortSuffix)
+	if tombstone, found := owner.Tombstone(shortSuffix); found || previous == nil {
+		return tombstone, found
+	}
+	return previous.Tombstone(shortSuffix)
+}
+
+func (s *ShardedURLStore) ForEachSuffix(ctx context.Context, fn func(shortSuffix string)) error {
+	for _, name := range s.Shards() {
+		if err := s.shard(name).ForEachSuffix(ctx, fn); err != nil {
+			return fmt.Errorf("shard %v: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *ShardedURLStore) ForEachTombstone(ctx context.Context, fn func(shortSuffix string)) error {
+	for _, name := range s.Shards() {
+		if err := s.shard(name).ForEachTombstone(ctx, fn); err != nil {
+			return fmt.Errorf("shard %v: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Scan walks the shards one after the other, the cursor is the shard name and
+// its own cursor separated by a slash.
+func (s *ShardedURLStore) Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error) {
+	names := s.Shards()
+	index, shardCursor := 0, ""
+	if cursor != "" {
+		name, rest, ok := strings.Cut(cursor, "/")
+		index = slices.Index(names, name)
+		if !ok || index < 0 {
+			return nil, "", fmt.Errorf("error when scanning shards, invalid cursor %q", cursor)
+		}
+		shardCursor = rest
+	}
+
+	pairs, next, err := s.shard(names[index]).Scan(ctx, shardCursor, count)
+	if err != nil {
+		return nil, "", fmt.Errorf("shard %v: %w", names[index], err)
+	}
+	switch {
+	case next != "":
+		return pairs, names[index] + "/" + next, nil
+	case index+1 < len(names):
+		return pairs, names[index+1] + "/", nil
+	default:
+		return pairs, "", nil
+	}
+}
+
+// InvalidationBus publishes on the first shard, every replica must list the
+// shards in the same order.
+func (s *ShardedURLStore) InvalidationBus() *InvalidationBus {
+	return s.shard(s.Shards()[0]).InvalidationBus()
+}
+
+func (s *ShardedURLStore) shard(name string) *RedisURLStore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shards[name]
+}
+
+// Rebalance moves every link, tombstone and click count that is not on its
+// owning shard and then stops consulting the previous shard list. It is safe
+// to run while the servers keep serving, and to run again after a failure.
+func (s *ShardedURLStore) Rebalance(ctx context.Context) (RebalanceResult, error) {
+	var result RebalanceResult
+
+	for _, name := range s.Shards() {
+		source := s.shard(name)
+		for _, keyspace := range []struct {
+			pattern string
+			move    func(ctx context.Context, shortSuffix string, from, to *RedisURLStore) (bool, error)
+		}{
+			{source.keys.LinkPattern(), moveKeyFunc(Keyspace.Link)},
+			{source.keys.TombstonePattern(), moveKeyFunc(Keyspace.Tombstone)},
+			{source.keys.ClicksPattern(), moveClicks},
+			{source.keys.VariantClicksPattern(), moveVariantClicks},
+		} {
+			prefix := strings.TrimSuffix(keyspace.pattern, "*")
+			var misplaced []string
+			err := source.scan(ctx, keyspace.pattern, func(key string) error {
+				shortSuffix, ok := strings.CutPrefix(key, prefix)
+				if !ok {
+					return nil
+				}
+				result.Scanned++
+				if owner, _ := s.owners(shortSuffix); owner != source {
+					misplaced = append(misplaced, shortSuffix)
+				}
+				return nil
+			})
+			if err != nil {
+				return result, fmt.Errorf("shard %v: error when scanning redis, %v", name, err)
+			}
+
+			for _, shortSuffix := range misplaced {
+				owner, _ := s.owners(shortSuffix)
+				moved, err := keyspace.move(ctx, shortSuffix, source, owner)
+				if err != nil {
+					return result, fmt.Errorf("shard %v: %w", name, err)
+				}
+				if moved {
+					result.Moved++
+				} else {
+					result.Superseded++
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.previous = nil
+	s.mu.Unlock()
+	return result, nil
+}
+
+func moveKeyFunc(key func(Keyspace, string) string) func(context.Context, string, *RedisURLStore, *RedisURLStore) (bool, error) {
+	return func(ctx context.Context, shortSuffix string, from, to *RedisURLStore) (bool, error) {
+		return moveKey(ctx, shortSuffix, from, to, key)
+	}
+}
+
+// moveClicks adds the clicks counted on the old shard to the new one, which
+// may have counted some already, then removes them from the old shard.
+func moveClicks(ctx context.Context, shortSuffix string, from, to *RedisURLStore) (bool, error) {
+	fromKey := from.keys.Clicks(shortSuffix)
+	var get *redis.StringCmd
+	// taken off the old shard atomically, no click counted meanwhile is lost
+	_, err := from.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, fromKey)
+		pipe.Del(ctx, fromKey)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return false, fmt.Errorf("error when reading clicks of link %v, %v", shortSuffix, err)
+	}
+	clicks, err := get.Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error when reading clicks of link %v, %v", shortSuffix, err)
+	}
+	if err := to.client.IncrBy(ctx, to.keys.Clicks(shortSuffix), clicks).Err(); err != nil {
+		// put them back so a later rebalance moves them
+		from.client.IncrBy(ctx, fromKey, clicks)
+		return false, fmt.Errorf("error when writing clicks of link %v, %v", shortSuffix, err)
+	}
+	return true, nil
+}
+
+// moveVariantClicks is moveClicks for the clicks of each variant.
+func moveVariantClicks(ctx context.Context, shortSuffix string, from, to *RedisURLStore) (bool, error) {
+	fromKey := from.keys.VariantClicks(shortSuffix)
+	var get *redis.MapStringStringCmd
+	_, err := from.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.HGetAll(ctx, fromKey)
+		pipe.Del(ctx, fromKey)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error when reading variant clicks of link %v, %v", shortSuffix, err)
+	}
+	values := get.Val()
+	if len(values) == 0 {
+		return false, nil
+	}
+	clicks := make(map[string]int64, len(values))
+	for variant, value := range values {
+		if clicks[variant], err = strconv.ParseInt(value, 10, 64); err != nil {
+			return false, fmt.Errorf("invalid clicks '%v' of variant %v of link %v", value, variant, shortSuffix)
+		}
+	}
+	_, err = to.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for variant, count := range clicks {
+			pipe.HIncrBy(ctx, to.keys.VariantClicks(shortSuffix), variant, count)
+		}
+		return nil
+	})
+	if err != nil {
+		// put them back so a later rebalance moves them
+		for variant, count := range clicks {
+			from.client.HIncrBy(ctx, fromKey, variant, count)
+		}
+		return false, fmt.Errorf("error when writing variant clicks of link %v, %v", shortSuffix, err)
+	}
+	return true, nil
+}
+
+// maxMoveAttempts bounds how often a link that keeps changing is copied.
+const maxMoveAttempts = 3
+
+// moveKey copies the raw value and expiry of a link, or of the key built by
+// key for it, to its new shard unless the new shard already has a newer
+// write, then removes it from the old shard. The old shard's key is watched:
+// when the link is deleted or disabled while it is copied, the copy is
+// undone and the move tried again, so it isn't brought back.
+func moveKey(ctx context.Context, shortSuffix string, from, to *RedisURLStore, key func(Keyspace, string) string) (bool, error) {
+	fromKey, toKey := key(from.keys, shortSuffix), key(to.keys, shortSuffix)
+
+	for attempt := 0; attempt < maxMoveAttempts; attempt++ {
+		moved := false
+		err := from.client.Watch(ctx, func(tx *redis.Tx) error {
+			value, err := tx.Get(ctx, fromKey).Result()
+			if err == redis.Nil {
+				return nil // deleted since the scan
+			}
+			if err != nil {
+				return fmt.Errorf("error when reading link %v, %v", shortSuffix, err)
+			}
+
+			ttl, err := tx.PTTL(ctx, fromKey).Result()
+			if err != nil {
+				return fmt.Errorf("error when reading expiry of link %v, %v", shortSuffix, err)
+			}
+			if ttl < 0 {
+				ttl = 0
+			} else if ttl < time.Millisecond {
+				ttl = time.Millisecond
+			}
+
+			if moved, err = to.client.SetNX(ctx, toKey, value, ttl).Result(); err != nil {
+				return fmt.Errorf("error when writing link %v, %v", shortSuffix, err)
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, fromKey)
+				return nil
+			})
+			if errors.Is(err, redis.TxFailedErr) {
+				if !moved {
+					return err
+				}
+				if err := deleteIfUnchanged(ctx, to, toKey, value); err != nil {
+					return fmt.Errorf("error when undoing move of link %v, %v", shortSuffix, err)
+				}
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("error when removing moved link %v, %v", shortSuffix, err)
+			}
+			return nil
+		}, fromKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return moved, err
+	}
+	return false, fmt.Errorf("link %v kept changing while it was moved", shortSuffix)
+}
+
+// deleteIfUnchanged removes a key unless it was written since it held value.
+func deleteIfUnchanged(ctx context.Context, store *RedisURLStore, key, value string) error {
+	err := store.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil || err == nil && current != value {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil // written meanwhile, the new value stays
+	}
+	return err
+}
+
+type ShardConfig struct {
+	Name    string
+	Options *redis.Options
+}
+
+// ParseShardConfigs reads a comma separated "name=host:port" list. Names
+// identify shards on the ring, so a shard keeps its links when its address
+// changes.
+func ParseShardConfigs(spec, password string, db int) ([]ShardConfig, error) {
+	var configs []ShardConfig
+	for _, entry := range strings.Split(spec, ",") {
+		name, addr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || addr == "" {
+			return nil, fmt.Errorf("invalid shard '%v': expected name=host:port", entry)
+		}
+		configs = append(configs, ShardConfig{Name: name, Options: NewRedisConfig(addr, password, db)})
+	}
+	return configs, nil
+}
+
+// NewShards connects to every shard and namespaces its keys under prefix.
+func NewShards(configs []ShardConfig, prefix string) ([]Shard, error) {
+	var shards []Shard
+	for _, config := range configs {
+		store, err := NewRedisURLStore(config.Options)
+		if err != nil {
+			return nil, fmt.Errorf("shard %v: %w", config.Name, err)
+		}
+		if err := store.SetKeyPrefix(prefix); err != nil {
+			return nil, err
+		}
+		shards = append(shards, Shard{Name: config.Name, Store: store})
+	}
+	return shards, nil
+}
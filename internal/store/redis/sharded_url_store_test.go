@@ -0,0 +1,225 @@
+package redis_store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/0xKev/url-shortener/internal/base62"
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	"github.com/0xKev/url-shortener/internal/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// setupShards fakes independent instances with one keyspace per shard on the
+// test DB.
+func setupShards(t testing.TB, client *redis.Client, names ...string) []Shard {
+	t.Helper()
+	var shards []Shard
+	for _, name := range names {
+		shards = append(shards, Shard{Name: name, Store: &RedisURLStore{client: client, keys: Keyspace{prefix: "shard-" + name}}})
+	}
+	return shards
+}
+
+func countLinks(t testing.TB, ctx context.Context, store *RedisURLStore) int {
+	t.Helper()
+	count := 0
+	testutil.AssertNoError(t, store.ForEachSuffix(ctx, func(string) { count++ }))
+	return count
+}
+
+func TestShardedURLStore(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	shards := setupShards(t, client, "a", "b", "c", "d")
+	sharded, err := NewShardedURLStore(shards[:3])
+	testutil.AssertNoError(t, err)
+
+	linkCount := 300
+	for i := range linkCount {
+		suffix := base62.Encode(uint64(i))
+		testutil.AssertNoError(t, sharded.Save(&model.URLPair{ShortSuffix: suffix, BaseURL: fmt.Sprintf("example%d.com", i)}))
+	}
+
+	t.Run("spreads links over every shard", func(t *testing.T) {
+		total := 0
+		for _, shard := range shards[:3] {
+			count := countLinks(t, ctx, shard.Store)
+			if count == 0 {
+				t.Errorf("shard %v holds no links", shard.Name)
+			}
+			total += count
+		}
+		testutil.AssertEqual(t, total, linkCount)
+	})
+
+	t.Run("links stay reachable after adding a shard and after rebalancing", func(t *testing.T) {
+		testutil.AssertNoError(t, sharded.AddShard(shards[3]))
+		assertAllLinksLoad(t, sharded, linkCount)
+
+		// written after the new shard joined, must win over the stale copy
+		moved := ""
+		for i := range linkCount {
+			if owner, _ := sharded.owners(base62.Encode(uint64(i))); owner == shards[3].Store {
+				moved = base62.Encode(uint64(i))
+				break
+			}
+		}
+		testutil.AssertNoError(t, sharded.Save(&model.URLPair{ShortSuffix: moved, BaseURL: "updated.com"}))
+
+		result, err := sharded.Rebalance(ctx)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, result.Moved+result.Superseded, countLinks(t, ctx, shards[3].Store))
+		testutil.AssertEqual(t, result.Superseded, 1)
+
+		got, _ := sharded.Load(moved)
+		testutil.AssertEqual(t, got, "updated.com")
+
+		total := 0
+		for _, shard := range shards {
+			total += countLinks(t, ctx, shard.Store)
+		}
+		testutil.AssertEqual(t, total, linkCount)
+		assertAllLinksLoad(t, sharded, linkCount)
+	})
+
+	t.Run("delete removes the link", func(t *testing.T) {
+		suffix := base62.Encode(1)
+		testutil.AssertNoError(t, sharded.Delete(suffix))
+		_, found := sharded.Load(suffix)
+		testutil.AssertEqual(t, found, false)
+	})
+
+	t.Run("rejects duplicate and unknown shards", func(t *testing.T) {
+		_, err := NewShardedURLStore(nil)
+		testutil.AssertError(t, err)
+		testutil.AssertError(t, sharded.AddShard(shards[0]))
+		testutil.AssertError(t, sharded.SetPreviousShards([]string{"z"}))
+	})
+}
+
+func TestShardedURLStoreRebalancesTombstones(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	shards := setupShards(t, client, "a", "b")
+	sharded, err := NewShardedURLStore(shards[:1])
+	testutil.AssertNoError(t, err)
+
+	linkCount := 50
+	for i := range linkCount {
+		suffix := base62.Encode(uint64(i))
+		testutil.AssertNoError(t, sharded.Save(&model.URLPair{ShortSuffix: suffix, BaseURL: fmt.Sprintf("example%d.com", i)}))
+		_, err := sharded.Disable(suffix, model.DisabledForAbuse)
+		testutil.AssertNoError(t, err)
+	}
+
+	testutil.AssertNoError(t, sharded.AddShard(shards[1]))
+	result, err := sharded.Rebalance(ctx)
+	testutil.AssertNoError(t, err)
+	if result.Moved == 0 {
+		t.Fatal("expected some tombstones to move to the new shard")
+	}
+	for i := range linkCount {
+		suffix := base62.Encode(uint64(i))
+		owner, _ := sharded.owners(suffix)
+		if _, found := owner.Tombstone(suffix); !found {
+			t.Errorf("tombstone %v not on its owning shard", suffix)
+		}
+	}
+}
+
+func TestShardedURLStoreRebalancesClicks(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+
+	shards := setupShards(t, client, "a", "b")
+	sharded, err := NewShardedURLStore(shards[:1])
+	testutil.AssertNoError(t, err)
+
+	linkCount := 50
+	for i := range linkCount {
+		testutil.AssertNoError(t, sharded.CountClick(base62.Encode(uint64(i)), "a"))
+	}
+
+	testutil.AssertNoError(t, sharded.AddShard(shards[1]))
+	// clicks counted on the new owner before the rebalance are added up
+	for i := range linkCount {
+		suffix := base62.Encode(uint64(i))
+		testutil.AssertNoError(t, sharded.CountClick(suffix, "b"))
+		clicks, err := sharded.Clicks(suffix)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, clicks, uint64(2))
+		variants, err := sharded.VariantClicks(suffix)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, variants["a"], uint64(1))
+		testutil.AssertEqual(t, variants["b"], uint64(1))
+	}
+
+	result, err := sharded.Rebalance(ctx)
+	testutil.AssertNoError(t, err)
+	if result.Moved == 0 {
+		t.Fatal("expected some click counts to move to the new shard")
+	}
+	for i := range linkCount {
+		suffix := base62.Encode(uint64(i))
+		owner, _ := sharded.owners(suffix)
+		clicks, err := owner.Clicks(suffix)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, clicks, uint64(2))
+		variants, err := owner.VariantClicks(suffix)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, variants["a"], uint64(1))
+		testutil.AssertEqual(t, variants["b"], uint64(1))
+	}
+}
+
+func TestDeleteIfUnchanged(t *testing.T) {
+	client, ctx, cancel := setupClient(t)
+	defer cancel()
+	defer client.Close()
+	client.FlushAll(ctx)
+	shard := &RedisURLStore{client: client, keys: Keyspace{prefix: DefaultKeyPrefix}}
+
+	// an undone move must not remove a link saved on the new shard meanwhile
+	testutil.AssertNoError(t, client.Set(ctx, "moved", "copied", 0).Err())
+	testutil.AssertNoError(t, client.Set(ctx, "saved", "newer", 0).Err())
+	testutil.AssertNoError(t, deleteIfUnchanged(ctx, shard, "moved", "copied"))
+	testutil.AssertNoError(t, deleteIfUnchanged(ctx, shard, "saved", "copied"))
+	testutil.AssertNoError(t, deleteIfUnchanged(ctx, shard, "missing", "copied"))
+
+	testutil.AssertEqual[error](t, client.Get(ctx, "moved").Err(), redis.Nil)
+	value, err := client.Get(ctx, "saved").Result()
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, value, "newer")
+}
+
+func assertAllLinksLoad(t testing.TB, sharded *ShardedURLStore, linkCount int) {
+	t.Helper()
+	for i := range linkCount {
+		if _, found := sharded.Load(base62.Encode(uint64(i))); !found {
+			t.Fatalf("link %v not found", base62.Encode(uint64(i)))
+		}
+	}
+}
+
+func TestShardedURLStoreConformance(t *testing.T) {
+	testutil.RunURLStoreConformance(t, func(t *testing.T) store.URLStore {
+		client, ctx, cancel := setupClient(t)
+		defer cancel()
+		t.Cleanup(func() { client.Close() })
+		client.FlushAll(ctx)
+		sharded, err := NewShardedURLStore(setupShards(t, client, "a", "b", "c"))
+		testutil.AssertNoError(t, err)
+		return sharded
+	})
+}
@@ -0,0 +1,110 @@
+package redis_store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	"github.com/redis/go-redis/v9"
+)
+
+// Disable replaces a link with a tombstone holding its raw value. The
+// tombstone is written first, so a failure in between leaves the link served
+// and disabling it again completes the job.
+func (r *RedisURLStore) Disable(shortSuffix, reason string) (model.Tombstone, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	value, err := r.client.Get(ctx, r.keys.Link(shortSuffix)).Result()
+	if errors.Is(err, redis.Nil) {
+		return model.Tombstone{}, store.ErrNotFound
+	}
+	if err != nil {
+		return model.Tombstone{}, fmt.Errorf("%w: error when loading short link from redis, %v", store.ErrUnavailable, err)
+	}
+	link, err := decodeLink(value)
+	if err != nil {
+		return model.Tombstone{}, err
+	}
+
+	tombstone := model.Tombstone{
+		ShortSuffix: shortSuffix,
+		BaseURL:     link.BaseURL,
+		Metadata:    link.Metadata,
+		Reason:      reason,
+		DisabledAt:  time.Now().UTC(),
+	}
+	encoded, err := json.Marshal(tombstone)
+	if err != nil {
+		return model.Tombstone{}, fmt.Errorf("error when encoding tombstone, %v", err)
+	}
+	if err := r.client.Set(ctx, r.keys.Tombstone(shortSuffix), encoded, 0).Err(); err != nil {
+		return model.Tombstone{}, fmt.Errorf("%w: error when saving tombstone to redis, %v", store.ErrUnavailable, err)
+	}
+	if err := r.client.Del(ctx, r.keys.Link(shortSuffix)).Err(); err != nil {
+		return model.Tombstone{}, fmt.Errorf("%w: error when deleting disabled short link from redis, %v", store.ErrUnavailable, err)
+	}
+	return tombstone, nil
+}
+
+// Restore puts a disabled link back unless the suffix holds a link again. A
+// link that expired while disabled only loses its tombstone.
+func (r *RedisURLStore) Restore(shortSuffix string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tombstone, err := r.lookupTombstone(ctx, shortSuffix)
+	if err != nil {
+		return err
+	}
+	urlPair := tombstone.URLPair()
+	value, err := encodeLink(&urlPair)
+	if err != nil {
+		return err
+	}
+
+	var ttl time.Duration
+	expiresAt, expires := urlPair.ExpiresAt()
+	if expires {
+		ttl = time.Until(expiresAt)
+	}
+	if !expires || ttl > 0 {
+		restored, err := r.client.SetNX(ctx, r.keys.Link(shortSuffix), value, ttl).Result()
+		if err != nil {
+			return fmt.Errorf("%w: error when restoring short link to redis, %v", store.ErrUnavailable, err)
+		}
+		if !restored {
+			return store.ErrExists
+		}
+	}
+	if err := r.client.Del(ctx, r.keys.Tombstone(shortSuffix)).Err(); err != nil {
+		return fmt.Errorf("%w: error when deleting tombstone from redis, %v", store.ErrUnavailable, err)
+	}
+	return nil
+}
+
+func (r *RedisURLStore) Tombstone(shortSuffix string) (model.Tombstone, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tombstone, err := r.lookupTombstone(ctx, shortSuffix)
+	return tombstone, err == nil
+}
+
+func (r *RedisURLStore) lookupTombstone(ctx context.Context, shortSuffix string) (model.Tombstone, error) {
+	value, err := r.client.Get(ctx, r.keys.Tombstone(shortSuffix)).Result()
+	if errors.Is(err, redis.Nil) {
+		return model.Tombstone{}, store.ErrNotFound
+	}
+	if err != nil {
+		return model.Tombstone{}, fmt.Errorf("%w: error when loading tombstone from redis, %v", store.ErrUnavailable, err)
+	}
+	var tombstone model.Tombstone
+	if err := json.Unmarshal([]byte(value), &tombstone); err != nil {
+		return model.Tombstone{}, fmt.Errorf("error when decoding tombstone for %s, %v", shortSuffix, err)
+	}
+	return tombstone, nil
+}
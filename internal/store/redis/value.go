@@ -0,0 +1,40 @@
+package redis_store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/0xKev/url-shortener/internal/model"
+)
+
+// linkValue is what a link key holds once the link has metadata. Links
+// without any keep the plain base URL, which is what older releases wrote.
+type linkValue struct {
+	BaseURL  string            `json:"baseURL"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func encodeLink(urlPair *model.URLPair) (string, error) {
+	if len(urlPair.Metadata) == 0 {
+		return urlPair.BaseURL, nil
+	}
+	value, err := json.Marshal(linkValue{BaseURL: urlPair.BaseURL, Metadata: urlPair.Metadata})
+	if err != nil {
+		return "", fmt.Errorf("error when encoding short link, %v", err)
+	}
+	return string(value), nil
+}
+
+// decodeLink reads either format. Base URLs are validated URLs, so they
+// never start with a brace.
+func decodeLink(value string) (model.URLPair, error) {
+	if !strings.HasPrefix(value, "{") {
+		return model.URLPair{BaseURL: value}, nil
+	}
+	var link linkValue
+	if err := json.Unmarshal([]byte(value), &link); err != nil {
+		return model.URLPair{}, fmt.Errorf("error when decoding short link, %v", err)
+	}
+	return model.URLPair{BaseURL: link.BaseURL, Metadata: link.Metadata}, nil
+}
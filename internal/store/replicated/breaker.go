@@ -0,0 +1,87 @@
+package replicated_store
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultCooldown         = 10 * time.Second
+)
+
+type breakerState int
+
+const (
+	closed breakerState = iota
+	open
+	halfOpen
+)
+
+// Breaker stops calls to a backend after consecutive failures so requests
+// fail fast instead of each waiting out a timeout. After the cooldown one
+// trial call is let through; its outcome closes or reopens the breaker.
+type Breaker struct {
+	failureThreshold int
+	cooldown         time.Duration
+	now              func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func NewBreaker(failureThreshold int, cooldown time.Duration) *Breaker {
+	if failureThreshold <= 0 {
+		failureThreshold = defaultFailureThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultCooldown
+	}
+	return &Breaker{failureThreshold: failureThreshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be
+// followed by Success or Failure.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case open:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = halfOpen
+		return true
+	case halfOpen:
+		return false // a trial call is already in flight
+	default:
+		return true
+	}
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = closed
+	b.failures = 0
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == halfOpen || b.failures >= b.failureThreshold {
+		b.state = open
+		b.openedAt = b.now()
+	}
+}
+
+// Open reports whether calls are currently being refused.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != closed && b.now().Sub(b.openedAt) < b.cooldown
+}
@@ -0,0 +1,342 @@
+package replicated_store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+)
+
+// Backend is a store that can tell a missing link from an unreachable store.
+type Backend interface {
+	store.URLStore
+	Lookup(shortSuffix string) (string, error)
+	LookupURLPair(shortSuffix string) (model.URLPair, error)
+}
+
+type Config struct {
+	failureThreshold int
+	cooldown         time.Duration
+}
+
+func NewDefaultConfig() *Config {
+	return &Config{
+		failureThreshold: defaultFailureThreshold,
+		cooldown:         defaultCooldown,
+	}
+}
+
+func (c *Config) FailureThreshold() int {
+	return c.failureThreshold
+}
+
+func (c *Config) Cooldown() time.Duration {
+	return c.cooldown
+}
+
+// SetFailureThreshold sets how many consecutive failures take a store out
+// of rotation.
+func (c *Config) SetFailureThreshold(failures int) error {
+	if failures <= 0 {
+		return fmt.Errorf("invalid failure threshold %d: must be positive", failures)
+	}
+	c.failureThreshold = failures
+	return nil
+}
+
+// SetCooldown sets how long a failed store is skipped before it is tried again.
+func (c *Config) SetCooldown(cooldown time.Duration) error {
+	if cooldown <= 0 {
+		return fmt.Errorf("invalid cooldown %v: must be positive", cooldown)
+	}
+	c.cooldown = cooldown
+	return nil
+}
+
+type member struct {
+	store   Backend
+	breaker *Breaker
+}
+
+// ReplicatedURLStore writes to a primary and reads from replicas. Reads fall
+// back to the primary, then to an optional local snapshot, so redirects keep
+// working while stores are down. When the primary is down writes fail fast
+// with store.ErrUnavailable and the store is read-only until it recovers.
+type ReplicatedURLStore struct {
+	primary  member
+	replicas []member
+	snapshot store.URLStore
+	next     atomic.Uint64
+}
+
+func NewReplicatedURLStore(primary Backend, replicas []Backend, config *Config) *ReplicatedURLStore {
+	if config == nil {
+		config = NewDefaultConfig()
+	}
+	r := &ReplicatedURLStore{
+		primary: member{store: primary, breaker: NewBreaker(config.failureThreshold, config.cooldown)},
+	}
+	for _, replica := range replicas {
+		r.replicas = append(r.replicas, member{store: replica, breaker: NewBreaker(config.failureThreshold, config.cooldown)})
+	}
+	return r
+}
+
+// SetSnapshot keeps a local copy of every link written or read through the
+// store, served when no primary or replica can answer.
+func (r *ReplicatedURLStore) SetSnapshot(snapshot store.URLStore) {
+	r.snapshot = snapshot
+}
+
+// ReadOnly reports whether writes are currently refused.
+func (r *ReplicatedURLStore) ReadOnly() bool {
+	return r.primary.breaker.Open()
+}
+
+func (r *ReplicatedURLStore) Save(urlPair *model.URLPair) error {
+	if !r.primary.breaker.Allow() {
+		return fmt.Errorf("%w: primary is down, links are read-only", store.ErrUnavailable)
+	}
+
+	err := r.primary.store.Save(urlPair)
+	record(r.primary.breaker, err)
+	if err != nil {
+		return err
+	}
+
+	r.remember(*urlPair)
+	return nil
+}
+
+func (r *ReplicatedURLStore) Load(shortSuffix string) (string, bool) {
+	baseURL, err := r.Lookup(shortSuffix)
+	return baseURL, err == nil
+}
+
+// Lookup asks the replicas in turn until one answers, and the primary when none
+// had the link, which may not have replicated yet. When the primary is down
+// the snapshot is tried, and without the link in it the lookup fails with
+// store.ErrUnavailable unless a replica already said the link doesn't exist.
+func (r *ReplicatedURLStore) Lookup(shortSuffix string) (string, error) {
+	urlPair, err := r.LookupURLPair(shortSuffix)
+	return urlPair.BaseURL, err
+}
+
+func (r *ReplicatedURLStore) LoadURLPair(shortSuffix string) (model.URLPair, bool) {
+	urlPair, err := r.LookupURLPair(shortSuffix)
+	return urlPair, err == nil
+}
+
+// LookupURLPair is Lookup returning the metadata of the link too.
+func (r *ReplicatedURLStore) LookupURLPair(shortSuffix string) (model.URLPair, error) {
+	notFound := false
+	for _, m := range r.replicaOrder() {
+		if !m.breaker.Allow() {
+			continue
+		}
+
+		urlPair, err := m.store.LookupURLPair(shortSuffix)
+		record(m.breaker, err)
+		if err == nil {
+			r.remember(urlPair)
+			return urlPair, nil
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			notFound = true
+			break
+		}
+	}
+
+	// the primary has every link, a replica that answered may be behind
+	if r.primary.breaker.Allow() {
+		urlPair, err := r.primary.store.LookupURLPair(shortSuffix)
+		record(r.primary.breaker, err)
+		if err == nil {
+			r.remember(urlPair)
+			return urlPair, nil
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			return model.URLPair{}, store.ErrNotFound
+		}
+	}
+	if r.snapshot != nil {
+		if urlPair, found := store.LoadURLPair(r.snapshot, shortSuffix); found {
+			return urlPair, nil
+		}
+	}
+	if notFound {
+		return model.URLPair{}, store.ErrNotFound
+	}
+	return model.URLPair{}, fmt.Errorf("%w: no store could answer for %v", store.ErrUnavailable, shortSuffix)
+}
+
+// ClaimSuffix claims on the primary, where the link will be saved.
+func (r *ReplicatedURLStore) ClaimSuffix(shortSuffix string) (bool, error) {
+	if !r.primary.breaker.Allow() {
+		return false, fmt.Errorf("%w: primary is down, links are read-only", store.ErrUnavailable)
+	}
+	claimed, err := store.ClaimSuffix(r.primary.store, shortSuffix)
+	record(r.primary.breaker, err)
+	return claimed, err
+}
+
+func (r *ReplicatedURLStore) Delete(shortSuffix string) error {
+	deleter, ok := r.primary.store.(store.URLDeleter)
+	if !ok {
+		return fmt.Errorf("primary store does not support deleting links")
+	}
+	if !r.primary.breaker.Allow() {
+		return fmt.Errorf("%w: primary is down, links are read-only", store.ErrUnavailable)
+	}
+
+	err := deleter.Delete(shortSuffix)
+	record(r.primary.breaker, err)
+	if err != nil {
+		return err
+	}
+
+	if snapshot, ok := r.snapshot.(store.URLDeleter); ok {
+		return snapshot.Delete(shortSuffix)
+	}
+	return nil
+}
+
+// Disable, Restore and Tombstone go to the primary, replicas may not have
+// caught up with a tombstone yet.
+func (r *ReplicatedURLStore) Disable(shortSuffix, reason string) (model.Tombstone, error) {
+	disabler, ok := r.primary.store.(store.LinkDisabler)
+	if !ok {
+		return model.Tombstone{}, fmt.Errorf("primary store does not support disabling links")
+	}
+	if !r.primary.breaker.Allow() {
+		return model.Tombstone{}, fmt.Errorf("%w: primary is down, links are read-only", store.ErrUnavailable)
+	}
+
+	tombstone, err := disabler.Disable(shortSuffix, reason)
+	record(r.primary.breaker, err)
+	if err != nil {
+		return model.Tombstone{}, err
+	}
+
+	if snapshot, ok := r.snapshot.(store.URLDeleter); ok {
+		return tombstone, snapshot.Delete(shortSuffix)
+	}
+	return tombstone, nil
+}
+
+func (r *ReplicatedURLStore) Restore(shortSuffix string) error {
+	disabler, ok := r.primary.store.(store.LinkDisabler)
+	if !ok {
+		return fmt.Errorf("primary store does not support restoring links")
+	}
+	if !r.primary.breaker.Allow() {
+		return fmt.Errorf("%w: primary is down, links are read-only", store.ErrUnavailable)
+	}
+
+	err := disabler.Restore(shortSuffix)
+	record(r.primary.breaker, err)
+	return err
+}
+
+func (r *ReplicatedURLStore) Tombstone(shortSuffix string) (model.Tombstone, bool) {
+	// Open rather than Allow: there is no error to record the outcome with,
+	// and an unrecorded trial call would keep the breaker half-open
+	disabler, ok := r.primary.store.(store.LinkDisabler)
+	if !ok || r.primary.breaker.Open() {
+		return model.Tombstone{}, false
+	}
+	return disabler.Tombstone(shortSuffix)
+}
+
+// CountClick, Clicks and VariantClicks go to the primary, counting on a replica would be
+// overwritten by replication.
+func (r *ReplicatedURLStore) CountClick(shortSuffix, variant string) error {
+	counter, ok := r.primary.store.(store.ClickCounter)
+	if !ok {
+		return fmt.Errorf("primary store does not support counting clicks")
+	}
+	if !r.primary.breaker.Allow() {
+		return fmt.Errorf("%w: primary is down, clicks are not counted", store.ErrUnavailable)
+	}
+
+	err := counter.CountClick(shortSuffix, variant)
+	record(r.primary.breaker, err)
+	return err
+}
+
+func (r *ReplicatedURLStore) Clicks(shortSuffix string) (uint64, error) {
+	counter, ok := r.primary.store.(store.ClickCounter)
+	if !ok {
+		return 0, fmt.Errorf("primary store does not support counting clicks")
+	}
+	if !r.primary.breaker.Allow() {
+		return 0, fmt.Errorf("%w: primary is down", store.ErrUnavailable)
+	}
+
+	clicks, err := counter.Clicks(shortSuffix)
+	record(r.primary.breaker, err)
+	return clicks, err
+}
+
+func (r *ReplicatedURLStore) VariantClicks(shortSuffix string) (map[string]uint64, error) {
+	counter, ok := r.primary.store.(store.ClickCounter)
+	if !ok {
+		return nil, fmt.Errorf("primary store does not support counting clicks")
+	}
+	if !r.primary.breaker.Allow() {
+		return nil, fmt.Errorf("%w: primary is down", store.ErrUnavailable)
+	}
+
+	clicks, err := counter.VariantClicks(shortSuffix)
+	record(r.primary.breaker, err)
+	return clicks, err
+}
+
+// replicaOrder rotates through the replicas so reads are spread evenly.
+func (r *ReplicatedURLStore) replicaOrder() []member {
+	n := len(r.replicas)
+	if n == 0 {
+		return nil
+	}
+	start := int(r.next.Add(1) % uint64(n))
+	return append(append(make([]member, 0, n), r.replicas[start:]...), r.replicas[:start]...)
+}
+
+func (r *ReplicatedURLStore) remember(urlPair model.URLPair) {
+	if r.snapshot == nil {
+		return
+	}
+	if err := r.snapshot.Save(&urlPair); err != nil {
+		log.Printf("unable to update local snapshot for %v: %v", urlPair.ShortSuffix, err)
+	}
+}
+
+// Scan pages through the primary, which is the only member known to hold
+// every link.
+func (r *ReplicatedURLStore) Scan(ctx context.Context, cursor string, count int) ([]model.URLPair, string, error) {
+	scanner, ok := r.primary.store.(store.URLScanner)
+	if !ok {
+		return nil, "", fmt.Errorf("primary store does not support scanning links")
+	}
+	if !r.primary.breaker.Allow() {
+		return nil, "", fmt.Errorf("%w: primary is down", store.ErrUnavailable)
+	}
+	pairs, next, err := scanner.Scan(ctx, cursor, count)
+	record(r.primary.breaker, err)
+	return pairs, next, err
+}
+
+// record counts only outages against a store, a store that answers "not
+// found" or rejects a value is healthy.
+func record(breaker *Breaker, err error) {
+	if errors.Is(err, store.ErrUnavailable) {
+		breaker.Failure()
+	} else {
+		breaker.Success()
+	}
+}
@@ -0,0 +1,203 @@
+package replicated_store
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+	memoryStore "github.com/0xKev/url-shortener/internal/store/memory"
+	"github.com/0xKev/url-shortener/internal/testutil"
+)
+
+// FlakyBackend wraps an in-memory store and can be switched off.
+type FlakyBackend struct {
+	*memoryStore.InMemoryURLStore
+	down  bool
+	calls int
+	mu    sync.Mutex
+}
+
+func newFlakyBackend(links map[string]string) *FlakyBackend {
+	backend := &FlakyBackend{InMemoryURLStore: memoryStore.NewInMemoryURLStore()}
+	for shortSuffix, baseURL := range links {
+		backend.InMemoryURLStore.Save(&model.URLPair{ShortSuffix: shortSuffix, BaseURL: baseURL})
+	}
+	return backend
+}
+
+func (f *FlakyBackend) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *FlakyBackend) call() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.down {
+		return store.ErrUnavailable
+	}
+	return nil
+}
+
+func (f *FlakyBackend) Save(urlPair *model.URLPair) error {
+	if err := f.call(); err != nil {
+		return err
+	}
+	return f.InMemoryURLStore.Save(urlPair)
+}
+
+func (f *FlakyBackend) Lookup(shortSuffix string) (string, error) {
+	if err := f.call(); err != nil {
+		return "", err
+	}
+	return f.InMemoryURLStore.Lookup(shortSuffix)
+}
+
+func (f *FlakyBackend) LookupURLPair(shortSuffix string) (model.URLPair, error) {
+	if err := f.call(); err != nil {
+		return model.URLPair{}, err
+	}
+	return f.InMemoryURLStore.LookupURLPair(shortSuffix)
+}
+
+func (f *FlakyBackend) Load(shortSuffix string) (string, bool) {
+	baseURL, err := f.Lookup(shortSuffix)
+	return baseURL, err == nil
+}
+
+func TestReplicatedURLStore(t *testing.T) {
+	links := map[string]string{"0000001": "google.com"}
+
+	t.Run("reads from replicas and writes to the primary", func(t *testing.T) {
+		primary, replica := newFlakyBackend(links), newFlakyBackend(links)
+		replicated := NewReplicatedURLStore(primary, []Backend{replica}, nil)
+
+		got, err := replicated.Lookup("0000001")
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, got, "google.com")
+		testutil.AssertEqual(t, primary.calls, 0)
+
+		testutil.AssertNoError(t, replicated.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: "github.com"}))
+		testutil.AssertEqual(t, primary.calls, 1)
+		testutil.AssertEqual(t, replica.calls, 1)
+	})
+
+	t.Run("falls back to the primary for links not replicated yet", func(t *testing.T) {
+		primary, replica := newFlakyBackend(links), newFlakyBackend(nil)
+		replicated := NewReplicatedURLStore(primary, []Backend{replica}, nil)
+
+		got, err := replicated.Lookup("0000001")
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, got, "google.com")
+
+		_, err = replicated.Lookup("1000000")
+		testutil.AssertEqual(t, errors.Is(err, store.ErrNotFound), true)
+	})
+
+	t.Run("asks one replica and the primary about a missing link", func(t *testing.T) {
+		primary, first, second := newFlakyBackend(links), newFlakyBackend(links), newFlakyBackend(links)
+		replicated := NewReplicatedURLStore(primary, []Backend{first, second}, nil)
+
+		_, err := replicated.Lookup("1000000")
+		testutil.AssertEqual(t, errors.Is(err, store.ErrNotFound), true)
+		testutil.AssertEqual(t, first.calls+second.calls, 1)
+		testutil.AssertEqual(t, primary.calls, 1)
+	})
+
+	t.Run("fails as unavailable when nothing can answer", func(t *testing.T) {
+		primary, replica := newFlakyBackend(links), newFlakyBackend(links)
+		replicated := NewReplicatedURLStore(primary, []Backend{replica}, nil)
+		primary.setDown(true)
+		replica.setDown(true)
+
+		_, err := replicated.Lookup("0000001")
+		testutil.AssertEqual(t, errors.Is(err, store.ErrUnavailable), true)
+	})
+
+	t.Run("falls back to the primary and then the snapshot when replicas are down", func(t *testing.T) {
+		primary, replica := newFlakyBackend(links), newFlakyBackend(links)
+		replicated := NewReplicatedURLStore(primary, []Backend{replica}, nil)
+		replicated.SetSnapshot(memoryStore.NewInMemoryURLStore())
+
+		replica.setDown(true)
+		got, err := replicated.Lookup("0000001")
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, got, "google.com")
+
+		primary.setDown(true)
+		got, err = replicated.Lookup("0000001")
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, got, "google.com")
+
+		_, err = replicated.Lookup("1000000")
+		testutil.AssertEqual(t, errors.Is(err, store.ErrUnavailable), true)
+	})
+
+	t.Run("refuses writes without calling a primary that keeps failing", func(t *testing.T) {
+		primary := newFlakyBackend(links)
+		replicated := NewReplicatedURLStore(primary, nil, nil)
+		primary.setDown(true)
+
+		for range defaultFailureThreshold {
+			testutil.AssertError(t, replicated.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: "github.com"}))
+		}
+		testutil.AssertEqual(t, replicated.ReadOnly(), true)
+
+		calls := primary.calls
+		err := replicated.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: "github.com"})
+		testutil.AssertEqual(t, errors.Is(err, store.ErrUnavailable), true)
+		testutil.AssertEqual(t, primary.calls, calls)
+	})
+
+	t.Run("tombstone lookups don't hold the trial call of a recovering primary", func(t *testing.T) {
+		primary := newFlakyBackend(links)
+		replicated := NewReplicatedURLStore(primary, nil, nil)
+		now := time.Unix(0, 0)
+		replicated.primary.breaker.now = func() time.Time { return now }
+		primary.setDown(true)
+		for range defaultFailureThreshold {
+			testutil.AssertError(t, replicated.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: "github.com"}))
+		}
+
+		primary.setDown(false)
+		now = now.Add(defaultCooldown)
+		replicated.Tombstone("0000002")
+		testutil.AssertNoError(t, replicated.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: "github.com"}))
+		testutil.AssertEqual(t, replicated.ReadOnly(), false)
+	})
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	breaker := NewBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	testutil.AssertEqual(t, breaker.Allow(), true)
+	breaker.Failure()
+	testutil.AssertEqual(t, breaker.Open(), true)
+	testutil.AssertEqual(t, breaker.Allow(), false)
+
+	now = now.Add(time.Minute)
+	testutil.AssertEqual(t, breaker.Allow(), true)  // trial call
+	testutil.AssertEqual(t, breaker.Allow(), false) // only one at a time
+	breaker.Failure()
+	testutil.AssertEqual(t, breaker.Allow(), false)
+
+	now = now.Add(time.Minute)
+	testutil.AssertEqual(t, breaker.Allow(), true)
+	breaker.Success()
+	testutil.AssertEqual(t, breaker.Open(), false)
+	testutil.AssertEqual(t, breaker.Allow(), true)
+}
+
+func TestReplicatedURLStoreConformance(t *testing.T) {
+	testutil.RunURLStoreConformance(t, func(t *testing.T) store.URLStore {
+		return NewReplicatedURLStore(memoryStore.NewInMemoryURLStore(), nil, nil)
+	})
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+
+	"github.com/0xKev/url-shortener/internal/model"
+)
+
+type URLStore interface {
+	Save(*model.URLPair) error
+	Load(shortSuffix string) (string, bool)
+}
+
+// URLPairLoader is implemented by stores that keep per link metadata and
+// can load it along with the base URL.
+type URLPairLoader interface {
+	LoadURLPair(shortSuffix string) (model.URLPair, bool)
+}
+
+// LoadURLPair loads a link with its metadata when the store keeps any, and
+// with the base URL only otherwise.
+func LoadURLPair(urlStore URLStore, shortSuffix string) (model.URLPair, bool) {
+	if loader, ok := urlStore.(URLPairLoader); ok {
+		return loader.LoadURLPair(shortSuffix)
+	}
+	baseURL, found := urlStore.Load(shortSuffix)
+	if !found {
+		return model.URLPair{}, false
+	}
+	return model.URLPair{ShortSuffix: shortSuffix, BaseURL: baseURL}, true
+}
+
+// URLPairLookup is implemented by stores that can tell a missing link,
+// ErrNotFound, from a store that couldn't answer, ErrUnavailable.
+type URLPairLookup interface {
+	LookupURLPair(shortSuffix string) (model.URLPair, error)
+}
+
+// LookupURLPair is LoadURLPair failing with ErrNotFound for missing links,
+// and with ErrUnavailable when a store implementing URLPairLookup couldn't
+// answer.
+func LookupURLPair(urlStore URLStore, shortSuffix string) (model.URLPair, error) {
+	if lookup, ok := urlStore.(URLPairLookup); ok {
+		return lookup.LookupURLPair(shortSuffix)
+	}
+	urlPair, found := LoadURLPair(urlStore, shortSuffix)
+	if !found {
+		return model.URLPair{}, ErrNotFound
+	}
+	return urlPair, nil
+}
+
+// SuffixClaimer is implemented by stores shared between servers, so that two
+// of them never hand out the same suffix. ClaimSuffix atomically reserves a
+// suffix for a new link, reporting false when it is already claimed or holds
+// a link. A claim only needs to outlive the save of the link.
+type SuffixClaimer interface {
+	ClaimSuffix(shortSuffix string) (bool, error)
+}
+
+// ClaimSuffix claims a suffix in stores implementing SuffixClaimer. Other
+// stores are only used by one server, which doesn't compete for suffixes.
+func ClaimSuffix(urlStore URLStore, shortSuffix string) (bool, error) {
+	if claimer, ok := urlStore.(SuffixClaimer); ok {
+		return claimer.ClaimSuffix(shortSuffix)
+	}
+	return true, nil
+}
+
+// ClickCounter is implemented by stores that count the redirects of each
+// link. The variant is empty for links without variants, a click on a
+// variant counts towards the link too.
+type ClickCounter interface {
+	CountClick(shortSuffix, variant string) error
+	Clicks(shortSuffix string) (uint64, error)
+	VariantClicks(shortSuffix string) (map[string]uint64, error)
+}
+
+// URLDeleter is implemented by stores that can remove a link. Deleting a
+// suffix that does not exist is not an error.
+type URLDeleter interface {
+	Delete(shortSuffix string) error
+}
+
+// LinkDisabler is implemented by stores that can soft delete links. A
+// disabled link is no longer loaded, but its tombstone keeps the suffix
+// taken and holds the link until it is restored. Disable and Restore fail
+// with ErrNotFound when there is nothing to disable or restore, and Restore
+// with ErrExists when the suffix holds a link again.
+type LinkDisabler interface {
+	Disable(shortSuffix, reason string) (model.Tombstone, error)
+	Restore(shortSuffix string) error
+	Tombstone(shortSuffix string) (model.Tombstone, bool)
+}
+
+// URLSwapper is implemented by stores that can replace a link only while it
+// is stored as it was read, so that a link changed, deleted or disabled
+// meanwhile isn't written back. Swap reports false when the suffix no longer
+// holds old, and saves next otherwise.
+type URLSwapper interface {
+	Swap(old, next *model.URLPair) (bool, error)
+}
+
+// URLScanner is implemented by stores that can list their links page by page.
+// A scan starts from an empty cursor and is complete once the returned cursor
+// is empty. Cursors outlive the process, so an interrupted scan can resume
+// from the last cursor it returned. Links saved or deleted while a scan runs
+// may or may not be returned, and a link may be returned more than once.
+type URLScanner interface {
+	Scan(ctx context.Context, cursor string, count int) (pairs []model.URLPair, next string, err error)
+}
@@ -0,0 +1,282 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/model"
+	"github.com/0xKev/url-shortener/internal/store"
+)
+
+// URLStoreFactory returns an empty store for one conformance test.
+type URLStoreFactory func(t *testing.T) store.URLStore
+
+// RunURLStoreConformance checks the behaviour every URLStore must share.
+// Loading metadata, counting clicks, deleting, scanning and disabling are
+// only checked on stores implementing store.URLPairLoader,
+// store.ClickCounter, store.URLDeleter, store.URLScanner and
+// store.LinkDisabler.
+func RunURLStoreConformance(t *testing.T, newStore URLStoreFactory) {
+	t.Helper()
+
+	t.Run("save and load round trip", func(t *testing.T) {
+		urlStore := newStore(t)
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "https://example.com/a?b=c"}))
+
+		baseURL, found := urlStore.Load("0000001")
+		AssertEqual(t, found, true)
+		AssertEqual(t, baseURL, "https://example.com/a?b=c")
+	})
+
+	t.Run("unknown suffix is not found", func(t *testing.T) {
+		urlStore := newStore(t)
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com"}))
+
+		baseURL, found := urlStore.Load("0000002")
+		AssertEqual(t, found, false)
+		AssertEqual(t, baseURL, "")
+	})
+
+	t.Run("saving a suffix again overwrites it", func(t *testing.T) {
+		urlStore := newStore(t)
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com"}))
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.org"}))
+
+		baseURL, found := urlStore.Load("0000001")
+		AssertEqual(t, found, true)
+		AssertEqual(t, baseURL, "example.org")
+	})
+
+	t.Run("concurrent saves and loads", func(t *testing.T) {
+		urlStore := newStore(t)
+		const workers, links = 8, 25
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*links)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < links; i++ {
+					suffix := fmt.Sprintf("%03d%04d", w, i)
+					if err := urlStore.Save(&model.URLPair{ShortSuffix: suffix, BaseURL: "example.com/" + suffix}); err != nil {
+						errs <- err
+						continue
+					}
+					// every worker also fights over one shared suffix
+					if err := urlStore.Save(&model.URLPair{ShortSuffix: "shared", BaseURL: fmt.Sprintf("example.com/%d", w)}); err != nil {
+						errs <- err
+					}
+					if baseURL, found := urlStore.Load(suffix); !found || baseURL != "example.com/"+suffix {
+						errs <- fmt.Errorf("%v: expected example.com/%v, got %q", suffix, suffix, baseURL)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		_, found := urlStore.Load("shared")
+		AssertEqual(t, found, true)
+	})
+
+	t.Run("expired links are not returned", func(t *testing.T) {
+		urlStore := newStore(t)
+		expired := time.Now().Add(-time.Minute).Format(time.RFC3339)
+		later := time.Now().Add(time.Hour).Format(time.RFC3339)
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com", Metadata: map[string]string{model.MetadataExpiresAt: expired}}))
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000002", BaseURL: "example.org", Metadata: map[string]string{model.MetadataExpiresAt: later}}))
+
+		_, found := urlStore.Load("0000001")
+		AssertEqual(t, found, false)
+		baseURL, found := urlStore.Load("0000002")
+		AssertEqual(t, found, true)
+		AssertEqual(t, baseURL, "example.org")
+	})
+
+	t.Run("load with metadata", func(t *testing.T) {
+		urlStore := newStore(t)
+		loader, ok := urlStore.(store.URLPairLoader)
+		if !ok {
+			t.Skip("store does not load metadata")
+		}
+		createdAt := time.Now().UTC().Format(time.RFC3339)
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com", Metadata: map[string]string{model.MetadataCreatedAt: createdAt}}))
+
+		urlPair, found := loader.LoadURLPair("0000001")
+		AssertEqual(t, found, true)
+		AssertEqual(t, urlPair.BaseURL, "example.com")
+		AssertEqual(t, urlPair.Metadata[model.MetadataCreatedAt], createdAt)
+
+		urlPair.Metadata[model.MetadataCreatedAt] = "changed"
+		urlPair, _ = loader.LoadURLPair("0000001")
+		AssertEqual(t, urlPair.Metadata[model.MetadataCreatedAt], createdAt)
+
+		_, found = loader.LoadURLPair("0000002")
+		AssertEqual(t, found, false)
+	})
+
+	t.Run("count clicks", func(t *testing.T) {
+		urlStore := newStore(t)
+		counter, ok := urlStore.(store.ClickCounter)
+		if !ok {
+			t.Skip("store does not count clicks")
+		}
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com"}))
+
+		clicks, err := counter.Clicks("0000001")
+		AssertNoError(t, err)
+		AssertEqual(t, clicks, uint64(0))
+		for i := 0; i < 3; i++ {
+			AssertNoError(t, counter.CountClick("0000001", ""))
+		}
+		clicks, err = counter.Clicks("0000001")
+		AssertNoError(t, err)
+		AssertEqual(t, clicks, uint64(3))
+	})
+
+	t.Run("count variant clicks", func(t *testing.T) {
+		urlStore := newStore(t)
+		counter, ok := urlStore.(store.ClickCounter)
+		if !ok {
+			t.Skip("store does not count clicks")
+		}
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com"}))
+
+		variants, err := counter.VariantClicks("0000001")
+		AssertNoError(t, err)
+		AssertEqual(t, len(variants), 0)
+		AssertNoError(t, counter.CountClick("0000001", "a"))
+		AssertNoError(t, counter.CountClick("0000001", "a"))
+		AssertNoError(t, counter.CountClick("0000001", "b"))
+		AssertNoError(t, counter.CountClick("0000001", ""))
+
+		variants, err = counter.VariantClicks("0000001")
+		AssertNoError(t, err)
+		AssertEqual(t, len(variants), 2)
+		AssertEqual(t, variants["a"], uint64(2))
+		AssertEqual(t, variants["b"], uint64(1))
+		clicks, err := counter.Clicks("0000001")
+		AssertNoError(t, err)
+		AssertEqual(t, clicks, uint64(4))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		urlStore := newStore(t)
+		deleter, ok := urlStore.(store.URLDeleter)
+		if !ok {
+			t.Skip("store does not delete links")
+		}
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com"}))
+		AssertNoError(t, deleter.Delete("0000001"))
+
+		_, found := urlStore.Load("0000001")
+		AssertEqual(t, found, false)
+		AssertNoError(t, deleter.Delete("0000001"))
+	})
+
+	t.Run("swap", func(t *testing.T) {
+		urlStore := newStore(t)
+		swapper, ok := urlStore.(store.URLSwapper)
+		if !ok {
+			t.Skip("store does not swap links")
+		}
+		old := &model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com", Metadata: map[string]string{"n": "1"}}
+		AssertNoError(t, urlStore.Save(old))
+
+		swapped, err := swapper.Swap(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com"}, &model.URLPair{ShortSuffix: "0000001", BaseURL: "example.net"})
+		AssertNoError(t, err)
+		AssertEqual(t, swapped, false)
+		swapped, err = swapper.Swap(old, &model.URLPair{ShortSuffix: "0000001", BaseURL: "example.org"})
+		AssertNoError(t, err)
+		AssertEqual(t, swapped, true)
+		baseURL, _ := urlStore.Load("0000001")
+		AssertEqual(t, baseURL, "example.org")
+
+		swapped, err = swapper.Swap(&model.URLPair{ShortSuffix: "0000002", BaseURL: "example.com"}, &model.URLPair{ShortSuffix: "0000002", BaseURL: "example.org"})
+		AssertNoError(t, err)
+		AssertEqual(t, swapped, false)
+		_, found := urlStore.Load("0000002")
+		AssertEqual(t, found, false)
+	})
+
+	t.Run("disable and restore", func(t *testing.T) {
+		urlStore := newStore(t)
+		disabler, ok := urlStore.(store.LinkDisabler)
+		if !ok {
+			t.Skip("store does not disable links")
+		}
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.com", Metadata: map[string]string{"n": "1"}}))
+
+		tombstone, err := disabler.Disable("0000001", model.DisabledForAbuse)
+		AssertNoError(t, err)
+		AssertEqual(t, tombstone.BaseURL, "example.com")
+		AssertEqual(t, tombstone.Reason, model.DisabledForAbuse)
+		_, found := urlStore.Load("0000001")
+		AssertEqual(t, found, false)
+		tombstone, found = disabler.Tombstone("0000001")
+		AssertEqual(t, found, true)
+		AssertEqual(t, tombstone.BaseURL, "example.com")
+		AssertEqual(t, tombstone.Metadata["n"], "1")
+		if tombstone.DisabledAt.IsZero() {
+			t.Error("expected the tombstone to record when the link was disabled")
+		}
+
+		_, err = disabler.Disable("0000002", model.DisabledForAbuse)
+		AssertEqual(t, errors.Is(err, store.ErrNotFound), true)
+
+		AssertNoError(t, disabler.Restore("0000001"))
+		baseURL, found := urlStore.Load("0000001")
+		AssertEqual(t, found, true)
+		AssertEqual(t, baseURL, "example.com")
+		_, found = disabler.Tombstone("0000001")
+		AssertEqual(t, found, false)
+		AssertEqual(t, errors.Is(disabler.Restore("0000001"), store.ErrNotFound), true)
+
+		// a link saved over the tombstone isn't replaced by restoring
+		_, err = disabler.Disable("0000001", model.DisabledByOwner)
+		AssertNoError(t, err)
+		AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: "0000001", BaseURL: "example.org"}))
+		AssertEqual(t, errors.Is(disabler.Restore("0000001"), store.ErrExists), true)
+	})
+
+	t.Run("scan", func(t *testing.T) {
+		urlStore := newStore(t)
+		scanner, ok := urlStore.(store.URLScanner)
+		if !ok {
+			t.Skip("store does not scan links")
+		}
+		want := map[string]string{}
+		for i := 0; i < 30; i++ {
+			suffix := fmt.Sprintf("%07d", i)
+			want[suffix] = "example.com/" + suffix
+			AssertNoError(t, urlStore.Save(&model.URLPair{ShortSuffix: suffix, BaseURL: want[suffix], Metadata: map[string]string{"n": suffix}}))
+		}
+
+		got := map[string]string{}
+		cursor := ""
+		for {
+			pairs, next, err := scanner.Scan(context.Background(), cursor, 7)
+			AssertNoError(t, err)
+			for _, pair := range pairs {
+				got[pair.ShortSuffix] = pair.BaseURL
+				AssertEqual(t, pair.Metadata["n"], pair.ShortSuffix)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		AssertEqual(t, len(got), len(want))
+		for suffix, baseURL := range want {
+			AssertEqual(t, got[suffix], baseURL)
+		}
+	})
+}
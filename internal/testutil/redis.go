@@ -0,0 +1,1042 @@
+package testutil
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RedisAddrEnv names a real redis to run the tests against instead of the
+// in-process stand-in. Tests flush it, point it at a throwaway instance.
+const RedisAddrEnv = "URL_SHORTENER_TEST_REDIS"
+
+// RedisAddr returns the redis the tests should use: the one named by
+// RedisAddrEnv, or else a fresh RedisServer stopped when the test ends.
+func RedisAddr(t testing.TB) string {
+	t.Helper()
+	if addr := os.Getenv(RedisAddrEnv); addr != "" {
+		return addr
+	}
+	return NewRedisServer(t).Addr()
+}
+
+// RedisServer speaks enough of the redis protocol for the stores and their
+// tests: strings with expiry, hashes, SCAN, pub/sub, MULTI with WATCH,
+// pipelining and an EVAL subset that runs scripts made of redis.call statements. It only speaks
+// RESP2, clients fall back to it after HELLO fails.
+type RedisServer struct {
+	listener net.Listener
+	now      func() time.Time
+
+	mu      sync.Mutex
+	dbs     map[int]map[string]*redisEntry
+	scripts map[string]string
+	cursors map[uint64]string // SCAN cursor -> last key returned
+	cursor  uint64
+	subs    map[string]map[*redisConn]bool
+	conns   map[*redisConn]bool
+	// versions counts the writes to each key, flushes those to every key,
+	// for WATCH
+	versions map[watchedKey]uint64
+	flushes  uint64
+	closed   bool
+	wg      sync.WaitGroup
+}
+
+type redisEntry struct {
+	str       string
+	hash      map[string]string
+	expiresAt time.Time
+}
+
+func (e *redisEntry) kind() string {
+	if e.hash != nil {
+		return "hash"
+	}
+	return "string"
+}
+
+// NewRedisServer listens on a random local port until the test ends.
+func NewRedisServer(t testing.TB) *RedisServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start redis stand-in, %v", err)
+	}
+	s := &RedisServer{
+		listener: listener,
+		now:      time.Now,
+		dbs:      map[int]map[string]*redisEntry{},
+		scripts:  map[string]string{},
+		cursors:  map[uint64]string{},
+		subs:     map[string]map[*redisConn]bool{},
+		conns:    map[*redisConn]bool{},
+		versions: map[watchedKey]uint64{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *RedisServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and drops every connection.
+func (s *RedisServer) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.listener.Close()
+	for c := range s.conns {
+		c.netConn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *RedisServer) serve() {
+	defer s.wg.Done()
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &redisConn{server: s, netConn: netConn, w: bufio.NewWriter(netConn), channels: map[string]bool{}}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			netConn.Close()
+			return
+		}
+		s.conns[c] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// replies mirror RESP2 types
+type (
+	redisStatus string
+	redisError  string
+	redisNil    struct{}
+)
+
+var (
+	redisOK           = redisStatus("OK")
+	errRedisSyntax    = redisError("ERR syntax error")
+	errRedisWrongType = redisError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errRedisNotInt    = redisError("ERR value is not an integer or out of range")
+)
+
+type redisConn struct {
+	server   *RedisServer
+	netConn  net.Conn
+	writeMu  sync.Mutex
+	w        *bufio.Writer
+	db       int
+	channels map[string]bool
+	queued   [][]string
+	inMulti  bool
+	// watched holds the versions of the watched keys when WATCH ran
+	watched map[watchedKey]uint64
+	flushes uint64
+}
+
+type watchedKey struct {
+	db  int
+	key string
+}
+
+func (c *redisConn) serve() {
+	defer c.server.wg.Done()
+	defer c.close()
+	r := bufio.NewReader(c.netConn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		reply := c.handle(args)
+		c.writeMu.Lock()
+		writeReply(c.w, reply)
+		// flush once the pipeline sent so far is answered
+		var flushErr error
+		if r.Buffered() == 0 {
+			flushErr = c.w.Flush()
+		}
+		c.writeMu.Unlock()
+		if flushErr != nil || strings.EqualFold(args[0], "quit") {
+			return
+		}
+	}
+}
+
+func (c *redisConn) close() {
+	s := c.server
+	s.mu.Lock()
+	for channel := range c.channels {
+		delete(s.subs[channel], c)
+	}
+	delete(s.conns, c)
+	s.mu.Unlock()
+	c.netConn.Close()
+}
+
+func (c *redisConn) push(reply any) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	writeReply(c.w, reply)
+	c.w.Flush()
+}
+
+func (c *redisConn) handle(args []string) any {
+	name := strings.ToLower(args[0])
+	switch name {
+	case "multi":
+		if c.inMulti {
+			return redisError("ERR MULTI calls can not be nested")
+		}
+		c.inMulti = true
+		return redisOK
+	case "watch":
+		if c.inMulti {
+			return redisError("ERR WATCH inside MULTI is not allowed")
+		}
+		c.server.mu.Lock()
+		defer c.server.mu.Unlock()
+		if c.watched == nil {
+			c.watched, c.flushes = map[watchedKey]uint64{}, c.server.flushes
+		}
+		for _, key := range args[1:] {
+			k := watchedKey{c.db, key}
+			if _, ok := c.watched[k]; !ok {
+				c.watched[k] = c.server.versions[k]
+			}
+		}
+		return redisOK
+	case "unwatch":
+		c.watched = nil
+		return redisOK
+	case "discard":
+		c.inMulti, c.queued, c.watched = false, nil, nil
+		return redisOK
+	case "exec":
+		if !c.inMulti {
+			return redisError("ERR EXEC without MULTI")
+		}
+		queued, watched := c.queued, c.watched
+		c.inMulti, c.queued, c.watched = false, nil, nil
+		c.server.mu.Lock()
+		defer c.server.mu.Unlock()
+		if watched != nil && c.server.touched(watched, c.flushes) {
+			return redisNil{}
+		}
+		replies := make([]any, len(queued))
+		for i, command := range queued {
+			replies[i] = c.server.exec(c, command)
+		}
+		return replies
+	case "subscribe", "unsubscribe":
+		return c.pubsub(name, args[1:])
+	case "ping":
+		if len(c.channels) > 0 {
+			message := ""
+			if len(args) > 1 {
+				message = args[1]
+			}
+			return []any{"pong", message}
+		}
+	}
+
+	if c.inMulti {
+		c.queued = append(c.queued, args)
+		return redisStatus("QUEUED")
+	}
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	return c.server.exec(c, args)
+}
+
+// pubsub replies are pushed one per channel, the returned reply is the last.
+func (c *redisConn) pubsub(name string, channels []string) any {
+	s := c.server
+	s.mu.Lock()
+	if name == "unsubscribe" && len(channels) == 0 {
+		for channel := range c.channels {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+	}
+	var replies []any
+	for _, channel := range channels {
+		if name == "subscribe" {
+			c.channels[channel] = true
+			if s.subs[channel] == nil {
+				s.subs[channel] = map[*redisConn]bool{}
+			}
+			s.subs[channel][c] = true
+		} else {
+			delete(c.channels, channel)
+			delete(s.subs[channel], c)
+		}
+		replies = append(replies, []any{name, channel, int64(len(c.channels))})
+	}
+	s.mu.Unlock()
+
+	if len(replies) == 0 {
+		return []any{name, redisNil{}, int64(0)}
+	}
+	for _, reply := range replies[:len(replies)-1] {
+		c.push(reply)
+	}
+	return replies[len(replies)-1]
+}
+
+// touched reports whether a watched key was written since it was watched.
+func (s *RedisServer) touched(watched map[watchedKey]uint64, flushes uint64) bool {
+	if s.flushes != flushes {
+		return true
+	}
+	for key, version := range watched {
+		if s.versions[key] != version {
+			return true
+		}
+	}
+	return false
+}
+
+// exec runs one command with s.mu held, a write that didn't fail counts as
+// touching its keys for WATCH whether it changed them or not.
+func (s *RedisServer) exec(c *redisConn, args []string) any {
+	reply := s.run(c, args)
+	if _, failed := reply.(redisError); failed {
+		return reply
+	}
+	switch strings.ToLower(args[0]) {
+	case "set", "setnx", "incr", "incrby", "decr", "expire", "pexpire", "persist", "hset", "hincrby":
+		s.versions[watchedKey{c.db, args[1]}]++
+	case "del", "rename", "renamenx":
+		for _, key := range args[1:] {
+			s.versions[watchedKey{c.db, key}]++
+		}
+	case "flushall", "flushdb":
+		s.flushes++
+	}
+	return reply
+}
+
+func (s *RedisServer) run(c *redisConn, args []string) any {
+	name := strings.ToLower(args[0])
+	args = args[1:]
+	db := s.db(c.db)
+
+	arity := map[string]int{
+		"get": 1, "set": 2, "setnx": 2, "del": 1, "exists": 1, "incr": 1, "incrby": 2, "decr": 1,
+		"type": 1, "renamenx": 2, "rename": 2, "pttl": 1, "ttl": 1, "expire": 2, "pexpire": 2,
+		"persist": 1, "mget": 1, "scan": 1, "hset": 3, "hget": 2, "hgetall": 1, "publish": 2,
+		"select": 1, "eval": 2, "evalsha": 2, "script": 1, "echo": 1,
+	}
+	if n, ok := arity[name]; ok && len(args) < n {
+		return redisError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	}
+
+	switch name {
+	case "ping":
+		if len(args) > 0 {
+			return args[0]
+		}
+		return redisStatus("PONG")
+	case "echo":
+		return args[0]
+	case "hello":
+		return redisError("ERR unknown command 'hello'")
+	case "auth", "client", "readonly", "quit":
+		return redisOK
+	case "select":
+		index, err := strconv.Atoi(args[0])
+		if err != nil || index < 0 || index > 15 {
+			return redisError("ERR DB index is out of range")
+		}
+		c.db = index
+		return redisOK
+	case "flushall":
+		s.dbs = map[int]map[string]*redisEntry{}
+		return redisOK
+	case "flushdb":
+		s.dbs[c.db] = map[string]*redisEntry{}
+		return redisOK
+
+	case "get":
+		e := s.lookup(db, args[0])
+		if e == nil {
+			return redisNil{}
+		}
+		if e.hash != nil {
+			return errRedisWrongType
+		}
+		return e.str
+	case "mget":
+		values := make([]any, len(args))
+		for i, key := range args {
+			if e := s.lookup(db, key); e != nil && e.hash == nil {
+				values[i] = e.str
+			} else {
+				values[i] = redisNil{}
+			}
+		}
+		return values
+	case "set":
+		return s.set(db, args)
+	case "setnx":
+		if s.lookup(db, args[0]) != nil {
+			return int64(0)
+		}
+		db[args[0]] = &redisEntry{str: args[1]}
+		return int64(1)
+	case "del":
+		deleted := int64(0)
+		for _, key := range args {
+			if s.lookup(db, key) != nil {
+				delete(db, key)
+				deleted++
+			}
+		}
+		return deleted
+	case "exists":
+		count := int64(0)
+		for _, key := range args {
+			if s.lookup(db, key) != nil {
+				count++
+			}
+		}
+		return count
+	case "incr", "incrby", "decr":
+		by := int64(1)
+		if name == "decr" {
+			by = -1
+		}
+		if name == "incrby" {
+			var err error
+			if by, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return errRedisNotInt
+			}
+		}
+		e := s.lookup(db, args[0])
+		if e == nil {
+			e = &redisEntry{str: "0"}
+			db[args[0]] = e
+		}
+		if e.hash != nil {
+			return errRedisWrongType
+		}
+		n, err := strconv.ParseInt(e.str, 10, 64)
+		if err != nil {
+			return errRedisNotInt
+		}
+		n += by
+		e.str = strconv.FormatInt(n, 10)
+		return n
+	case "type":
+		if e := s.lookup(db, args[0]); e != nil {
+			return redisStatus(e.kind())
+		}
+		return redisStatus("none")
+	case "rename", "renamenx":
+		e := s.lookup(db, args[0])
+		if e == nil {
+			return redisError("ERR no such key")
+		}
+		if name == "renamenx" && s.lookup(db, args[1]) != nil {
+			return int64(0)
+		}
+		delete(db, args[0])
+		db[args[1]] = e
+		if name == "rename" {
+			return redisOK
+		}
+		return int64(1)
+	case "pttl", "ttl":
+		e := s.lookup(db, args[0])
+		switch {
+		case e == nil:
+			return int64(-2)
+		case e.expiresAt.IsZero():
+			return int64(-1)
+		case name == "ttl":
+			return int64(e.expiresAt.Sub(s.now()).Round(time.Second) / time.Second)
+		default:
+			return int64(e.expiresAt.Sub(s.now()) / time.Millisecond)
+		}
+	case "expire", "pexpire":
+		e := s.lookup(db, args[0])
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errRedisNotInt
+		}
+		if e == nil {
+			return int64(0)
+		}
+		unit := time.Second
+		if name == "pexpire" {
+			unit = time.Millisecond
+		}
+		e.expiresAt = s.now().Add(time.Duration(n) * unit)
+		return int64(1)
+	case "persist":
+		e := s.lookup(db, args[0])
+		if e == nil || e.expiresAt.IsZero() {
+			return int64(0)
+		}
+		e.expiresAt = time.Time{}
+		return int64(1)
+	case "scan":
+		return s.scan(db, args)
+
+	case "hset":
+		if len(args)%2 != 1 {
+			return redisError("ERR wrong number of arguments for 'hset' command")
+		}
+		e := s.lookup(db, args[0])
+		if e == nil {
+			e = &redisEntry{hash: map[string]string{}}
+			db[args[0]] = e
+		}
+		if e.hash == nil {
+			return errRedisWrongType
+		}
+		added := int64(0)
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := e.hash[args[i]]; !ok {
+				added++
+			}
+			e.hash[args[i]] = args[i+1]
+		}
+		return added
+	case "hincrby":
+		by, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errRedisNotInt
+		}
+		e := s.lookup(db, args[0])
+		if e == nil {
+			e = &redisEntry{hash: map[string]string{}}
+			db[args[0]] = e
+		}
+		if e.hash == nil {
+			return errRedisWrongType
+		}
+		n := int64(0)
+		if value, ok := e.hash[args[1]]; ok {
+			if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return redisError("ERR hash value is not an integer")
+			}
+		}
+		n += by
+		e.hash[args[1]] = strconv.FormatInt(n, 10)
+		return n
+	case "hget":
+		e := s.lookup(db, args[0])
+		if e == nil {
+			return redisNil{}
+		}
+		if e.hash == nil {
+			return errRedisWrongType
+		}
+		if value, ok := e.hash[args[1]]; ok {
+			return value
+		}
+		return redisNil{}
+	case "hgetall":
+		e := s.lookup(db, args[0])
+		if e == nil {
+			return []any{}
+		}
+		if e.hash == nil {
+			return errRedisWrongType
+		}
+		fields := make([]string, 0, len(e.hash))
+		for field := range e.hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		reply := make([]any, 0, 2*len(fields))
+		for _, field := range fields {
+			reply = append(reply, field, e.hash[field])
+		}
+		return reply
+
+	case "publish":
+		receivers := s.subs[args[0]]
+		for receiver := range receivers {
+			// pushed outside the lock, the receiver may be mid-write
+			go receiver.push([]any{"message", args[0], args[1]})
+		}
+		return int64(len(receivers))
+
+	case "script":
+		switch strings.ToLower(args[0]) {
+		case "load":
+			if len(args) != 2 {
+				return errRedisSyntax
+			}
+			sum := sha1.Sum([]byte(args[1]))
+			sha := hex.EncodeToString(sum[:])
+			s.scripts[sha] = args[1]
+			return sha
+		case "exists":
+			reply := make([]any, len(args)-1)
+			for i, sha := range args[1:] {
+				_, ok := s.scripts[strings.ToLower(sha)]
+				reply[i] = int64(0)
+				if ok {
+					reply[i] = int64(1)
+				}
+			}
+			return reply
+		case "flush":
+			s.scripts = map[string]string{}
+			return redisOK
+		}
+		return errRedisSyntax
+	case "eval", "evalsha":
+		script := args[0]
+		if name == "evalsha" {
+			var ok bool
+			if script, ok = s.scripts[strings.ToLower(args[0])]; !ok {
+				return redisError("NOSCRIPT No matching script. Please use EVAL.")
+			}
+		} else {
+			sum := sha1.Sum([]byte(script))
+			s.scripts[hex.EncodeToString(sum[:])] = script
+		}
+		numKeys, err := strconv.Atoi(args[1])
+		if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+			return redisError("ERR Number of keys can't be greater than number of args")
+		}
+		return s.eval(c, script, args[2:2+numKeys], args[2+numKeys:])
+	}
+	return redisError(fmt.Sprintf("ERR unknown command '%s'", name))
+}
+
+func (s *RedisServer) db(index int) map[string]*redisEntry {
+	if s.dbs[index] == nil {
+		s.dbs[index] = map[string]*redisEntry{}
+	}
+	return s.dbs[index]
+}
+
+// lookup returns a live entry, dropping it if it expired.
+func (s *RedisServer) lookup(db map[string]*redisEntry, key string) *redisEntry {
+	e, ok := db[key]
+	if !ok {
+		return nil
+	}
+	if !e.expiresAt.IsZero() && !s.now().Before(e.expiresAt) {
+		delete(db, key)
+		return nil
+	}
+	return e
+}
+
+func (s *RedisServer) set(db map[string]*redisEntry, args []string) any {
+	key, value := args[0], args[1]
+	var expiresAt time.Time
+	var nx, xx, keepTTL, get bool
+	for i := 2; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		switch option {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "keepttl":
+			keepTTL = true
+		case "get":
+			get = true
+		case "ex", "px", "exat", "pxat":
+			if i+1 >= len(args) {
+				return errRedisSyntax
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return errRedisNotInt
+			}
+			if n <= 0 && (option == "ex" || option == "px") {
+				return redisError("ERR invalid expire time in 'set' command")
+			}
+			switch option {
+			case "ex":
+				expiresAt = s.now().Add(time.Duration(n) * time.Second)
+			case "px":
+				expiresAt = s.now().Add(time.Duration(n) * time.Millisecond)
+			case "exat":
+				expiresAt = time.Unix(n, 0)
+			case "pxat":
+				expiresAt = time.UnixMilli(n)
+			}
+		default:
+			return errRedisSyntax
+		}
+	}
+	if nx && xx {
+		return errRedisSyntax
+	}
+
+	old := s.lookup(db, key)
+	var previous any = redisNil{}
+	if old != nil {
+		if get && old.hash != nil {
+			return errRedisWrongType
+		}
+		previous = old.str
+	}
+	if (nx && old != nil) || (xx && old == nil) {
+		if get {
+			return previous
+		}
+		return redisNil{}
+	}
+	if keepTTL && old != nil {
+		expiresAt = old.expiresAt
+	}
+	db[key] = &redisEntry{str: value, expiresAt: expiresAt}
+	if get {
+		return previous
+	}
+	return redisOK
+}
+
+// scan walks keys in sorted order. Cursors remember the last key returned,
+// so keys present for the whole iteration are returned exactly once.
+func (s *RedisServer) scan(db map[string]*redisEntry, args []string) any {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return redisError("ERR invalid cursor")
+	}
+	after, ok := s.cursors[cursor]
+	if cursor != 0 && !ok {
+		return []any{"0", []any{}}
+	}
+	delete(s.cursors, cursor)
+
+	pattern, count, keyType := "*", 10, ""
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errRedisSyntax
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				return errRedisSyntax
+			}
+		case "type":
+			keyType = strings.ToLower(args[i+1])
+		default:
+			return errRedisSyntax
+		}
+	}
+
+	keys := make([]string, 0, len(db))
+	for key := range db {
+		if key > after || cursor == 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	matched := []any{}
+	next := "0"
+	for i, key := range keys {
+		if i == count {
+			s.cursor++
+			s.cursors[s.cursor] = keys[i-1]
+			next = strconv.FormatUint(s.cursor, 10)
+			break
+		}
+		e := s.lookup(db, key)
+		if e == nil || !matchGlob(pattern, key) || (keyType != "" && e.kind() != keyType) {
+			continue
+		}
+		matched = append(matched, key)
+	}
+	return []any{next, matched}
+}
+
+// matchGlob implements the redis glob syntax: *, ?, [set], [^set], [a-z]
+// and backslash escapes.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		case '[':
+			if s == "" {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return false
+			}
+			set := pattern[1 : end+1]
+			negate := strings.HasPrefix(set, "^")
+			if negate {
+				set = set[1:]
+			}
+			matched := false
+			for i := 0; i < len(set); i++ {
+				if i+2 < len(set) && set[i+1] == '-' {
+					if set[i] <= s[0] && s[0] <= set[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if set[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+var (
+	luaCall  = regexp.MustCompile(`^redis\.p?call\((.*)\)$`)
+	luaLocal = regexp.MustCompile(`^local\s+([A-Za-z_]\w*)\s*=\s*(.+)$`)
+	luaIndex = regexp.MustCompile(`^(KEYS|ARGV)\[(\d+)\]$`)
+)
+
+// eval runs scripts made of statements separated by newlines or
+// semicolons, each one of:
+//
+//	redis.call('CMD', arg, ...)
+//	local name = <expression>
+//	return <expression>
+//
+// where an expression is a redis.call, a quoted string, a number, KEYS[n],
+// ARGV[n] or a local. Anything else is rejected rather than misread.
+func (s *RedisServer) eval(c *redisConn, script string, keys, argv []string) any {
+	locals := map[string]any{}
+	var evalExpr func(expr string) (any, error)
+	evalExpr = func(expr string) (any, error) {
+		expr = strings.TrimSpace(expr)
+		if m := luaCall.FindStringSubmatch(expr); m != nil {
+			var args []string
+			for _, arg := range splitLuaArgs(m[1]) {
+				value, err := evalExpr(arg)
+				if err != nil {
+					return nil, err
+				}
+				switch v := value.(type) {
+				case string:
+					args = append(args, v)
+				case int64:
+					args = append(args, strconv.FormatInt(v, 10))
+				default:
+					return nil, fmt.Errorf("unsupported argument %q", arg)
+				}
+			}
+			if len(args) == 0 {
+				return nil, errors.New("redis.call needs a command")
+			}
+			reply := s.exec(c, args)
+			if e, ok := reply.(redisError); ok && strings.HasPrefix(expr, "redis.call") {
+				return nil, errors.New(string(e))
+			}
+			return reply, nil
+		}
+		if m := luaIndex.FindStringSubmatch(expr); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			list := keys
+			if m[1] == "ARGV" {
+				list = argv
+			}
+			if n < 1 || n > len(list) {
+				return redisNil{}, nil
+			}
+			return list[n-1], nil
+		}
+		if len(expr) >= 2 && (expr[0] == '\'' || expr[0] == '"') && expr[len(expr)-1] == expr[0] {
+			return expr[1 : len(expr)-1], nil
+		}
+		if n, err := strconv.ParseInt(expr, 10, 64); err == nil {
+			return n, nil
+		}
+		if expr == "nil" || expr == "false" {
+			return redisNil{}, nil
+		}
+		if value, ok := locals[expr]; ok {
+			return value, nil
+		}
+		return nil, fmt.Errorf("unsupported expression %q", expr)
+	}
+
+	for _, statement := range strings.FieldsFunc(script, func(r rune) bool { return r == '\n' || r == ';' }) {
+		statement = strings.TrimSpace(statement)
+		if statement == "" || strings.HasPrefix(statement, "--") {
+			continue
+		}
+		var err error
+		var value any
+		switch {
+		case strings.HasPrefix(statement, "return "):
+			if value, err = evalExpr(strings.TrimPrefix(statement, "return ")); err == nil {
+				return value
+			}
+		case luaLocal.MatchString(statement):
+			m := luaLocal.FindStringSubmatch(statement)
+			if value, err = evalExpr(m[2]); err == nil {
+				locals[m[1]] = value
+			}
+		default:
+			_, err = evalExpr(statement)
+		}
+		if err != nil {
+			return redisError("ERR Error running script: " + err.Error())
+		}
+	}
+	return redisNil{}
+}
+
+// splitLuaArgs splits on commas outside quotes and parentheses.
+func splitLuaArgs(list string) []string {
+	var args []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(list); i++ {
+		switch ch := list[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '(' || ch == '[':
+			depth++
+		case ch == ')' || ch == ']':
+			depth--
+		case ch == ',' && depth == 0:
+			args = append(args, list[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(list[start:]) != "" {
+		args = append(args, list[start:])
+	}
+	return args
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil // inline command
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid multibulk length %q", line)
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		header, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("expected bulk string, got %q", header)
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", header)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case redisStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case redisError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case redisNil:
+		w.WriteString("$-1\r\n")
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unsupported reply %T\r\n", v)
+	}
+}
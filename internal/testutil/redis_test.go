@@ -0,0 +1,175 @@
+package testutil_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/0xKev/url-shortener/internal/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedisClient(t *testing.T) (*redis.Client, context.Context) {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: testutil.NewRedisServer(t).Addr(), DB: 9})
+	t.Cleanup(func() { client.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return client, ctx
+}
+
+func TestRedisServer(t *testing.T) {
+	t.Run("get, set and del", func(t *testing.T) {
+		client, ctx := newRedisClient(t)
+		testutil.AssertNoError(t, client.Set(ctx, "key", "value", 0).Err())
+
+		value, err := client.Get(ctx, "key").Result()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, value, "value")
+
+		deleted, err := client.Del(ctx, "key", "missing").Result()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, deleted, int64(1))
+		testutil.AssertEqual[error](t, client.Get(ctx, "key").Err(), redis.Nil)
+	})
+
+	t.Run("set with expiry and nx", func(t *testing.T) {
+		client, ctx := newRedisClient(t)
+		set, err := client.SetNX(ctx, "key", "first", 50*time.Millisecond).Result()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, set, true)
+		set, err = client.SetNX(ctx, "key", "second", 0).Result()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, set, false)
+
+		ttl, err := client.PTTL(ctx, "key").Result()
+		testutil.AssertNoError(t, err)
+		if ttl <= 0 || ttl > 50*time.Millisecond {
+			t.Errorf("expected a ttl up to 50ms, got %v", ttl)
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		testutil.AssertEqual[error](t, client.Get(ctx, "key").Err(), redis.Nil)
+		testutil.AssertNoError(t, client.Set(ctx, "seconds", "value", time.Minute).Err())
+		ttl, err = client.TTL(ctx, "seconds").Result()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, ttl, time.Minute)
+	})
+
+	t.Run("incr", func(t *testing.T) {
+		client, ctx := newRedisClient(t)
+		for want := int64(1); want <= 3; want++ {
+			got, err := client.Incr(ctx, "counter").Result()
+			testutil.AssertNoError(t, err)
+			testutil.AssertEqual(t, got, want)
+		}
+		testutil.AssertNoError(t, client.Set(ctx, "text", "abc", 0).Err())
+		testutil.AssertError(t, client.Incr(ctx, "text").Err())
+	})
+
+	t.Run("hincrby", func(t *testing.T) {
+		client, ctx := newRedisClient(t)
+		testutil.AssertNoError(t, client.HIncrBy(ctx, "hash", "a", 2).Err())
+		got, err := client.HIncrBy(ctx, "hash", "a", 3).Result()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, got, int64(5))
+
+		fields, err := client.HGetAll(ctx, "hash").Result()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, fields["a"], "5")
+		testutil.AssertNoError(t, client.Set(ctx, "text", "abc", 0).Err())
+		testutil.AssertError(t, client.HIncrBy(ctx, "text", "a", 1).Err())
+	})
+
+	t.Run("scan with match and count", func(t *testing.T) {
+		client, ctx := newRedisClient(t)
+		for _, key := range []string{"link:a", "link:b", "link:c", "link:d", "link:e", "other"} {
+			testutil.AssertNoError(t, client.Set(ctx, key, "x", 0).Err())
+		}
+
+		var got []string
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, "link:*", 2).Result()
+			testutil.AssertNoError(t, err)
+			got = append(got, keys...)
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+		sort.Strings(got)
+		testutil.AssertEqual(t, len(got), 5)
+		testutil.AssertEqual(t, got[0], "link:a")
+		testutil.AssertEqual(t, got[4], "link:e")
+	})
+
+	t.Run("pipelines and transactions", func(t *testing.T) {
+		client, ctx := newRedisClient(t)
+		for _, pipeline := range []redis.Pipeliner{client.Pipeline(), client.TxPipeline()} {
+			incr := pipeline.Incr(ctx, "counter")
+			pipeline.Set(ctx, "key", "value", 0)
+			get := pipeline.Get(ctx, "key")
+			_, err := pipeline.Exec(ctx)
+			testutil.AssertNoError(t, err)
+			testutil.AssertEqual(t, get.Val(), "value")
+			if incr.Val() < 1 {
+				t.Errorf("expected the counter to be incremented, got %d", incr.Val())
+			}
+		}
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		client, ctx := newRedisClient(t)
+		testutil.AssertNoError(t, client.Set(ctx, "key", "one", 0).Err())
+		update := func(write func()) error {
+			return client.Watch(ctx, func(tx *redis.Tx) error {
+				write()
+				_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					pipe.Set(ctx, "key", "mine", 0)
+					return nil
+				})
+				return err
+			}, "key")
+		}
+
+		testutil.AssertEqual[error](t, update(func() { client.Del(ctx, "key") }), redis.TxFailedErr)
+		testutil.AssertEqual[error](t, client.Get(ctx, "key").Err(), redis.Nil)
+		testutil.AssertNoError(t, update(func() { client.Set(ctx, "other", "x", 0) }))
+		value, err := client.Get(ctx, "key").Result()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, value, "mine")
+	})
+
+	t.Run("eval subset", func(t *testing.T) {
+		client, ctx := newRedisClient(t)
+		script := redis.NewScript(`
+			local previous = redis.call('GET', KEYS[1])
+			redis.call('SET', KEYS[1], ARGV[1])
+			return previous`)
+
+		testutil.AssertEqual[error](t, script.Run(ctx, client, []string{"key"}, "one").Err(), redis.Nil)
+		previous, err := script.Run(ctx, client, []string{"key"}, "two").Text()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, previous, "one")
+
+		testutil.AssertError(t, client.Eval(ctx, "if true then return 1 end", nil).Err())
+	})
+
+	t.Run("publish and subscribe", func(t *testing.T) {
+		client, ctx := newRedisClient(t)
+		subscription := client.Subscribe(ctx, "channel")
+		defer subscription.Close()
+		_, err := subscription.Receive(ctx)
+		testutil.AssertNoError(t, err)
+
+		receivers, err := client.Publish(ctx, "channel", "hello").Result()
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, receivers, int64(1))
+
+		message, err := subscription.ReceiveMessage(ctx)
+		testutil.AssertNoError(t, err)
+		testutil.AssertEqual(t, message.Payload, "hello")
+	})
+}
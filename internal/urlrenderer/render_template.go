@@ -2,6 +2,7 @@ package urlrenderer
 
 import (
 	"embed"
+	"encoding/base64"
 	"html/template"
 	"io"
 
@@ -51,3 +52,30 @@ func (u *URLPairRenderer) RenderInvalidUserInput(w io.Writer, urlPair model.URLP
 	}
 	return nil
 }
+
+// RenderLinkDisabled renders the page served instead of redirecting to a
+// disabled link. The destination is left out, it may be what got the link
+// disabled.
+func (u *URLPairRenderer) RenderLinkDisabled(w io.Writer, tombstone model.Tombstone) error {
+	if err := u.templ.ExecuteTemplate(w, "link_disabled.gohtml", tombstone); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RenderLinkPreview renders the page showing where a short link leads
+// instead of redirecting.
+func (u *URLPairRenderer) RenderLinkPreview(w io.Writer, preview model.LinkPreview) error {
+	view := struct {
+		model.LinkPreview
+		QRCodeURL template.URL
+	}{LinkPreview: preview}
+	if len(preview.QRCode) > 0 {
+		// the PNG is ours, it is safe as an image source
+		view.QRCodeURL = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(preview.QRCode))
+	}
+	if err := u.templ.ExecuteTemplate(w, "link_preview.gohtml", view); err != nil {
+		return err
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/0xKev/url-shortener/internal/model"
 	approvals "github.com/approvals/go-approval-tests"
@@ -55,6 +56,36 @@ func TestRender(t *testing.T) {
 		}
 		approvals.VerifyString(t, cleanHTML(buf.String()))
 	})
+
+	t.Run("renders link_disabled.gohtml with the reason", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		tombstone := model.Tombstone{ShortSuffix: "0000001", BaseURL: "example.com", Reason: model.DisabledForAbuse, DisabledAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+
+		if err := urlPairRenderer.RenderLinkDisabled(&buf, tombstone); err != nil {
+			t.Fatal(err)
+		}
+
+		approvals.VerifyString(t, cleanHTML(buf.String()))
+	})
+
+	t.Run("renders link_preview.gohtml with the destination", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		preview := model.LinkPreview{
+			ShortURL:  "https://s.domain.com/0000001",
+			BaseURL:   "https://example.com/a?b=c",
+			Domain:    "example.com",
+			CreatedAt: &createdAt,
+			Clicks:    1,
+			QRCode:    []byte("png"),
+		}
+
+		if err := urlPairRenderer.RenderLinkPreview(&buf, preview); err != nil {
+			t.Fatal(err)
+		}
+
+		approvals.VerifyString(t, cleanHTML(buf.String()))
+	})
 }
 func cleanHTML(html string) string {
 	re := regexp.MustCompile(`>\s+<`)